@@ -0,0 +1,32 @@
+// hashpw prints a source password hash for STUDIOS_FILE / LIVE_SOURCE_PASSWORD_HASH.
+//
+//	go run ./cmd/hashpw 'secret'
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/ivugurura/radio-studio/internal/stream"
+)
+
+func main() {
+	var pw string
+	if len(os.Args) > 1 {
+		pw = os.Args[1]
+	} else {
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			log.Fatal("usage: hashpw <password> (or pass it on stdin)")
+		}
+		pw = strings.TrimRight(line, "\r\n")
+	}
+	h, err := stream.HashSourcePassword(pw)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(h)
+}
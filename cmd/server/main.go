@@ -3,9 +3,13 @@ package main
 import (
 	"log"
 	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/ivugurura/radio-studio/config"
 	"github.com/ivugurura/radio-studio/internal/geo"
+	"github.com/ivugurura/radio-studio/internal/netutil"
 	"github.com/ivugurura/radio-studio/internal/stream"
 	"github.com/joho/godotenv"
 )
@@ -19,15 +23,37 @@ func main() {
 	opts := []stream.ManagerOption{
 		stream.WithDefaultBitrate(cfg.DefaultBitrateKbps),
 		stream.WithSnapshotInterval(cfg.SnapshotInterval),
+		stream.WithRequestValidator(stream.AdminTokenValidator(cfg.AdminAPIKey, "keys", "sources")),
+		stream.WithStudioOptions(stream.WithAuthLimit(cfg.AuthMaxFailures, cfg.AuthFailureWindow, cfg.AuthLockoutDuration)),
+	}
+	proxies, err := netutil.ParseCIDRs(cfg.TrustedProxies)
+	if err != nil {
+		log.Fatalf("TRUSTED_PROXIES: %v", err)
+	}
+	opts = append(opts, stream.WithStudioOptions(stream.WithTrustedProxies(proxies...)))
+	if cfg.MaxListeners > 0 {
+		opts = append(opts, stream.WithStudioOptions(stream.WithListenerQuota(stream.NewListenerQuota(cfg.MaxListeners))))
 	}
 
-	// If playlist URL is configured, use backend-driven AutoDJ
-	if cfg.BackendAPI != "" {
-		opts = append(opts, stream.WithAutoDJFactory(func(dir string, studioID string, bitrate int, push func([]byte)) stream.AutoDJ {
-			studioEndpoint := cfg.BackendAPI + "/studios/" + studioID
-			return stream.NewAutoDJ(dir, studioID, bitrate, push, studioEndpoint, cfg.BackendAPIKey, cfg.DefaultTrackFile)
-		}))
+	studios := []config.StudioConfig{{ID: "reformation-rw"}}
+	if cfg.StudiosFile != "" {
+		loaded, err := config.LoadStudios(cfg.StudiosFile)
+		if err != nil {
+			log.Fatalf("studios: %v", err)
+		}
+		studios = loaded
+	}
+
+	// AutoDJ: backend or filesystem playlist, selected per studio
+	byID := make(map[string]config.StudioConfig, len(studios))
+	for _, sc := range studios {
+		byID[sc.ID] = sc
 	}
+	opts = append(opts, stream.WithAutoDJFactory(func(dir string, studioID string, bitrate int, push func([]byte)) stream.AutoDJ {
+		sc := byID[studioID]
+		sc.ID = studioID
+		return newAutoDJ(cfg, sc, dir, bitrate, push)
+	}))
 
 	manager := stream.NewManager(
 		cfg.AudioDir,
@@ -35,16 +61,18 @@ func main() {
 		opts...,
 	)
 
-	s1 := manager.RegisterStudio("reformation-rw")
-	// manager.RegisterStudio("reformation-congo")
+	for _, sc := range studios {
+		s := manager.RegisterStudio(sc.ID, studioOptions(cfg, sc)...)
 
-	// Start analytics sync if configured
-	if cfg.BackendAPI != "" {
-		backendIngestURL := cfg.BackendAPI + "/studios/" + s1.ID + "/listener-events"
-		s1.StartAnalytics(backendIngestURL, cfg.BackendAPIKey, cfg.EventFlushInterval)
+		// Start analytics sync if configured
+		if cfg.BackendAPI != "" {
+			backendIngestURL := cfg.BackendAPI + "/studios/" + s.ID + "/listener-events"
+			s.StartAnalytics(backendIngestURL, cfg.BackendAPIKey, cfg.EventFlushInterval)
+		}
 	}
 
 	http.HandleFunc("/studio/", manager.RouteStudioRequest)
+	http.HandleFunc("/admin/metadata", manager.HandleAdminMetadata)
 
 	// optional monitoring
 	stopMon := make(chan struct{})
@@ -60,3 +88,168 @@ func main() {
 		log.Fatal("Server failed ", err)
 	}
 }
+
+// studioOptions maps a studio's config entry to stream options
+func studioOptions(cfg *config.Config, sc config.StudioConfig) []stream.StudioOption {
+	var accounts []stream.SourceAccount
+	for _, src := range sc.Sources {
+		accounts = append(accounts, stream.SourceAccount{User: src.User, PasswordHash: src.PasswordHash, Priority: src.Priority})
+	}
+	if cfg.DefaultSourceHash != "" {
+		accounts = append(accounts, stream.SourceAccount{User: cfg.DefaultSourceUser, PasswordHash: cfg.DefaultSourceHash})
+	}
+	if len(accounts) == 0 {
+		log.Printf("studio %s: no source accounts configured (stream keys only)", sc.ID)
+	}
+
+	metaInt := cfg.ICYMetaInt
+	if sc.ICYMetaInt > 0 {
+		metaInt = sc.ICYMetaInt
+	}
+	opts := []stream.StudioOption{
+		stream.WithSourceAccounts(accounts...),
+		stream.WithStationInfo(stream.StationInfo{Name: sc.Name, Genre: sc.Genre, Description: sc.Description, URL: sc.URL}),
+		stream.WithICYMetaInt(metaInt),
+		stream.WithRingBuffer(cfg.RingBufferBytes),
+	}
+	hlsSegment, hlsWindow := cfg.HLSSegmentDuration, cfg.HLSWindow
+	if sc.HLSSegmentSeconds > 0 {
+		hlsSegment = time.Duration(sc.HLSSegmentSeconds * float64(time.Second))
+	}
+	if sc.HLSWindow > 0 {
+		hlsWindow = sc.HLSWindow
+	}
+	opts = append(opts, stream.WithHLS(hlsSegment, hlsWindow))
+	fade := cfg.HandoffFade
+	if sc.HandoffFadeSeconds != nil {
+		fade = time.Duration(*sc.HandoffFadeSeconds * float64(time.Second))
+	}
+	opts = append(opts, stream.WithHandoffFade(fade))
+	stall := cfg.LiveStallTimeout
+	if sc.LiveStallSeconds > 0 {
+		stall = time.Duration(sc.LiveStallSeconds * float64(time.Second))
+	}
+	opts = append(opts, stream.WithLiveTimeouts(stall, cfg.LiveReadTimeout))
+	silenceDB, silenceAfter := cfg.SilenceThresholdDB, cfg.SilenceTimeout
+	if sc.SilenceThresholdDB != nil {
+		silenceDB = *sc.SilenceThresholdDB
+	}
+	if sc.SilenceSeconds != nil {
+		silenceAfter = time.Duration(*sc.SilenceSeconds * float64(time.Second))
+	}
+	opts = append(opts, stream.WithSilenceDetection(silenceDB, silenceAfter))
+	for _, rc := range sc.Relays {
+		if rc.Mode != "" && rc.Mode != "always" && rc.Mode != "fallback" {
+			log.Printf("studio %s: relay %s: unknown mode %q (using always)", sc.ID, rc.URL, rc.Mode)
+		}
+		opts = append(opts, stream.WithRelays(stream.RelayConfig{URL: rc.URL, Name: rc.Name, Priority: rc.Priority, Fallback: rc.Mode == "fallback"}))
+	}
+	if sc.ShoutcastAddr != "" {
+		opts = append(opts, stream.WithShoutcastSource(sc.ShoutcastAddr))
+	}
+	origins := sc.WebSocketOrigins
+	if len(origins) == 0 {
+		for _, o := range strings.Split(cfg.WebSocketOrigins, ",") {
+			if o = strings.TrimSpace(o); o != "" {
+				origins = append(origins, o)
+			}
+		}
+	}
+	opts = append(opts, stream.WithWebSocketOrigins(origins...))
+	for _, pc := range sc.Push {
+		if pc.Method != "" && !strings.EqualFold(pc.Method, "PUT") && !strings.EqualFold(pc.Method, "SOURCE") {
+			log.Printf("studio %s: push target %s: unknown method %q (using PUT)", sc.ID, pc.URL, pc.Method)
+		}
+		opts = append(opts, stream.WithPushTargets(stream.PushTarget{
+			URL: pc.URL, User: pc.User, Password: pc.Password, Method: pc.Method,
+			Name: pc.Name, Genre: pc.Genre, Description: pc.Description, StationURL: pc.StationURL, Public: pc.Public,
+		}))
+	}
+	limits := stream.ListenerLimits{
+		MaxListeners: cfg.StudioMaxListeners,
+		MaxPerIP:     cfg.MaxListenersPerIP,
+		MaxSession:   cfg.MaxListenerSession,
+		RetryAfter:   cfg.ListenerRetryAfter,
+		Overflow:     cfg.ListenerOverflow,
+	}
+	if sc.MaxListeners > 0 {
+		limits.MaxListeners = sc.MaxListeners
+	}
+	if sc.MaxListenersPerIP > 0 {
+		limits.MaxPerIP = sc.MaxListenersPerIP
+	}
+	if sc.MaxSessionSeconds > 0 {
+		limits.MaxSession = time.Duration(sc.MaxSessionSeconds * float64(time.Second))
+	}
+	if sc.Overflow != "" {
+		limits.Overflow = sc.Overflow
+	}
+	opts = append(opts, stream.WithListenerLimits(limits))
+	for _, mc := range sc.Mounts {
+		codec := stream.Codec(strings.ToLower(mc.Codec))
+		switch codec {
+		case "":
+			codec = stream.CodecMP3
+		case stream.CodecMP3, stream.CodecAAC, stream.CodecOgg:
+		default:
+			log.Printf("studio %s: mount %s: unknown codec %q (skipped)", sc.ID, mc.Name, mc.Codec)
+			continue
+		}
+		bitrate := mc.Bitrate
+		if bitrate <= 0 {
+			bitrate = 64
+		}
+		transcoder := stream.FFmpegTranscoder(codec, bitrate)
+		if len(mc.Command) > 0 {
+			transcoder = stream.ExecTranscoder(mc.Command[0], mc.Command[1:]...)
+		}
+		opts = append(opts, stream.WithMounts(stream.Mount{Name: mc.Name, Codec: codec, Bitrate: bitrate, Transcoder: transcoder}))
+	}
+	switch {
+	case sc.BurstSeconds > 0:
+		opts = append(opts, stream.WithBurst(0, time.Duration(sc.BurstSeconds*float64(time.Second))))
+	case sc.BurstBytes > 0:
+		opts = append(opts, stream.WithBurst(sc.BurstBytes, 0))
+	default:
+		opts = append(opts, stream.WithBurst(cfg.BurstBytes, cfg.BurstDuration))
+	}
+	if cfg.BackendAPI != "" {
+		opts = append(opts, stream.WithSourceBackend(cfg.BackendAPI+"/studios/"+sc.ID+"/sources", cfg.BackendAPIKey))
+	}
+	if cfg.StreamKeysDir != "" {
+		opts = append(opts, stream.WithStreamKeysFile(filepath.Join(cfg.StreamKeysDir, sc.ID+".json")))
+	}
+	return opts
+}
+
+// newAutoDJ builds the studio's AutoDJ from its playlist settings
+func newAutoDJ(cfg *config.Config, sc config.StudioConfig, dir string, bitrate int, push func([]byte)) stream.AutoDJ {
+	studioEndpoint := ""
+	if cfg.BackendAPI != "" {
+		studioEndpoint = cfg.BackendAPI + "/studios/" + sc.ID
+	}
+	source := cfg.PlaylistSource
+	if sc.Playlist != "" {
+		source = sc.Playlist
+	}
+	resume := cfg.AutoDJResume
+	if sc.AutoDJResume != "" {
+		resume = sc.AutoDJResume
+	}
+	djOpts := []stream.AutoDJOption{stream.WithResumePolicy(stream.ParseResumePolicy(resume))}
+	if source == "backend" && studioEndpoint != "" {
+		return stream.NewAutoDJ(dir, sc.ID, bitrate, push, studioEndpoint, cfg.BackendAPIKey, cfg.DefaultTrackFile, djOpts...)
+	}
+
+	mode := cfg.PlaylistMode
+	if sc.PlaylistMode != "" {
+		mode = sc.PlaylistMode
+	}
+	rescan := cfg.PlaylistRescan
+	if sc.PlaylistRescanSeconds > 0 {
+		rescan = time.Duration(sc.PlaylistRescanSeconds * float64(time.Second))
+	}
+	log.Printf("studio %s: filesystem playlist (dir=%s mode=%s)", sc.ID, dir, mode)
+	playlist := stream.NewFilesystemPlaylist(dir, stream.ParsePlaylistMode(mode), sc.PlaylistWeights, rescan)
+	return stream.NewAutoDJWithPlaylist(dir, bitrate, push, playlist, studioEndpoint, cfg.BackendAPIKey, cfg.DefaultTrackFile, djOpts...)
+}
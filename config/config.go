@@ -27,6 +27,59 @@ type Config struct {
 
 	// Fallback track
 	DefaultTrackFile string
+
+	// Studios and source authentication
+	StudiosFile         string
+	DefaultSourceUser   string
+	DefaultSourceHash   string
+	StreamKeysDir       string
+	AdminAPIKey         string
+	AuthMaxFailures     int
+	AuthFailureWindow   time.Duration
+	AuthLockoutDuration time.Duration
+	TrustedProxies      string // comma-separated CIDRs whose X-Forwarded-For is trusted
+	WebSocketOrigins    string // comma-separated page origins allowed to open browser sources ("*" = any)
+
+	// ICY metadata interval (bytes of audio between metadata blocks)
+	ICYMetaInt int
+
+	// Burst-on-connect defaults (BURST_DURATION wins when set)
+	BurstBytes    int
+	BurstDuration time.Duration
+
+	// Listener limits (0 = none); per studio settings in STUDIOS_FILE override the Studio* defaults
+	MaxListeners       int // server-wide
+	StudioMaxListeners int
+	MaxListenersPerIP  int
+	MaxListenerSession time.Duration
+	ListenerRetryAfter time.Duration
+	ListenerOverflow   string // mount name or URL for listeners of a full studio
+
+	// Shared output buffer per studio (0 = ~10s of audio)
+	RingBufferBytes int
+
+	// HLS defaults
+	HLSSegmentDuration time.Duration
+	HLSWindow          int
+
+	// Silence played when switching between AutoDJ and live
+	HandoffFade time.Duration
+
+	// Live source watchdog: off air after LiveStallTimeout without audio, dropped after LiveReadTimeout
+	LiveStallTimeout time.Duration
+	LiveReadTimeout  time.Duration
+
+	// Dead air: a live source below SilenceThresholdDB for SilenceTimeout goes off air (0 = never)
+	SilenceThresholdDB float64
+	SilenceTimeout     time.Duration
+
+	// AutoDJ playlist defaults
+	PlaylistSource string // backend | filesystem
+	PlaylistMode   string
+	PlaylistRescan time.Duration
+
+	// What the AutoDJ plays after a live show: restart | continue | next
+	AutoDJResume string
 }
 
 func LoadConfig() *Config {
@@ -51,7 +104,44 @@ func LoadConfig() *Config {
 		SnapshotInterval:   durationEnv("SNAPSHOT_INTERVAL", 5*time.Second),
 		DefaultBitrateKbps: intEnv("DEFAULT_BITRATE_KBPS", 128),
 		DefaultTrackFile:   get("DEFAULT_TRACK_FILE", ""),
+
+		StudiosFile:         get("STUDIOS_FILE", ""),
+		DefaultSourceUser:   get("LIVE_SOURCE_USER", "source"),
+		DefaultSourceHash:   get("LIVE_SOURCE_PASSWORD_HASH", ""),
+		StreamKeysDir:       get("STREAM_KEYS_DIR", ""),
+		AdminAPIKey:         get("ADMIN_API_KEY", ""),
+		AuthMaxFailures:     intEnv("AUTH_MAX_FAILURES", 5),
+		AuthFailureWindow:   durationEnv("AUTH_FAILURE_WINDOW", time.Minute),
+		AuthLockoutDuration: durationEnv("AUTH_LOCKOUT", 5*time.Minute),
+		TrustedProxies:      get("TRUSTED_PROXIES", ""),
+		WebSocketOrigins:    get("LIVE_WS_ORIGINS", ""),
+		ICYMetaInt:          intEnv("ICY_METAINT", 16000),
+		BurstBytes:          intEnv("BURST_BYTES", 64*1024),
+		BurstDuration:       durationEnv("BURST_DURATION", 0),
+		RingBufferBytes:     intEnv("RING_BUFFER_BYTES", 0),
+		MaxListeners:        intEnv("MAX_LISTENERS", 0),
+		StudioMaxListeners:  intEnv("STUDIO_MAX_LISTENERS", 0),
+		MaxListenersPerIP:   intEnv("MAX_LISTENERS_PER_IP", 0),
+		MaxListenerSession:  durationEnv("MAX_LISTENER_SESSION", 0),
+		ListenerRetryAfter:  durationEnv("LISTENER_RETRY_AFTER", 30*time.Second),
+		ListenerOverflow:    get("LISTENER_OVERFLOW", ""),
+		HLSSegmentDuration:  durationEnv("HLS_SEGMENT_DURATION", 6*time.Second),
+		HLSWindow:           intEnv("HLS_WINDOW", 5),
+		HandoffFade:         durationEnv("HANDOFF_FADE", 200*time.Millisecond),
+		LiveStallTimeout:    durationEnv("LIVE_STALL_TIMEOUT", 5*time.Second),
+		LiveReadTimeout:     durationEnv("LIVE_READ_TIMEOUT", time.Minute),
+		SilenceThresholdDB:  floatEnv("SILENCE_THRESHOLD_DB", -50),
+		SilenceTimeout:      durationEnv("SILENCE_TIMEOUT", 15*time.Second),
+		PlaylistMode:        get("PLAYLIST_MODE", "shuffle"),
+		PlaylistRescan:      durationEnv("PLAYLIST_RESCAN", time.Minute),
+		AutoDJResume:        get("AUTODJ_RESUME", "next"),
+	}
+	// Backend playlist when a backend is configured, otherwise play files from AUDIO_DIR
+	dfltSource := "filesystem"
+	if cfg.BackendAPI != "" {
+		dfltSource = "backend"
 	}
+	cfg.PlaylistSource = get("PLAYLIST_SOURCE", dfltSource)
 
 	return cfg
 }
@@ -59,7 +149,7 @@ func LoadConfig() *Config {
 func durationEnv(key string, def time.Duration) time.Duration {
 	if v := os.Getenv(key); v != "" {
 		d, err := time.ParseDuration(v)
-		if err != nil {
+		if err == nil {
 			return d
 		}
 		log.Printf("config: invalid duration in %s=%s (using default)", key, v)
@@ -76,3 +166,13 @@ func intEnv(key string, def int) int {
 	}
 	return def
 }
+
+func floatEnv(key string, def float64) float64 {
+	if v := os.Getenv(key); v != "" {
+		var f float64
+		if _, err := fmt.Sscanf(v, "%g", &f); err == nil {
+			return f
+		}
+	}
+	return def
+}
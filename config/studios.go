@@ -0,0 +1,115 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// SourceAccountConfig is an encoder login; PasswordHash comes from `go run ./cmd/hashpw`
+type SourceAccountConfig struct {
+	User         string `json:"user"`
+	PasswordHash string `json:"password_hash"`
+	Priority     int    `json:"priority"` // higher takes over from lower
+}
+
+// RelayConfig is an upstream HTTP/Icecast stream the studio rebroadcasts
+type RelayConfig struct {
+	URL      string `json:"url"`
+	Name     string `json:"name"`
+	Priority int    `json:"priority"`
+	Mode     string `json:"mode"` // always (default) | fallback: only while no local source is live
+}
+
+// PushConfig is a remote Icecast/SHOUTcast server the studio's output is sent to
+type PushConfig struct {
+	URL         string `json:"url"` // http(s)://host:port/mount
+	User        string `json:"user"`
+	Password    string `json:"password"`
+	Method      string `json:"method"` // PUT (default) | SOURCE
+	Name        string `json:"name"`   // Ice-* headers (default to the studio's station info)
+	Genre       string `json:"genre"`
+	Description string `json:"description"`
+	StationURL  string `json:"station_url"`
+	Public      bool   `json:"public"`
+}
+
+// MountConfig is an extra listener stream of the studio, transcoded from its output
+type MountConfig struct {
+	Name    string   `json:"name"`    // /studio/{id}/listen/{name}
+	Codec   string   `json:"codec"`   // mp3 (default) | aac | ogg
+	Bitrate int      `json:"bitrate"` // kbps
+	Command []string `json:"command"` // encoder reading stdin, writing stdout (default: ffmpeg)
+}
+
+// StudioConfig holds per-studio settings loaded from STUDIOS_FILE
+type StudioConfig struct {
+	ID      string                `json:"id"`
+	Sources []SourceAccountConfig `json:"sources"`
+	Relays  []RelayConfig         `json:"relays"`
+	Push    []PushConfig          `json:"push"`
+	Mounts  []MountConfig         `json:"mounts"`
+
+	// SHOUTcast v1 sources: host:port for /admin.cgi and listeners; sources connect to port+1
+	ShoutcastAddr string `json:"shoutcast_addr"`
+
+	// Page origins allowed to open browser (WebSocket) sources (defaults to LIVE_WS_ORIGINS)
+	WebSocketOrigins []string `json:"ws_origins"`
+
+	// Station info sent to listeners as icy-* headers
+	Name        string `json:"name"`
+	Genre       string `json:"genre"`
+	Description string `json:"description"`
+	URL         string `json:"url"`
+	ICYMetaInt  int    `json:"icy_metaint"`
+
+	// Burst-on-connect size; burst_seconds takes precedence over burst_bytes
+	BurstBytes   int     `json:"burst_bytes"`
+	BurstSeconds float64 `json:"burst_seconds"`
+
+	// Listener limits (default to STUDIO_MAX_LISTENERS, MAX_LISTENERS_PER_IP, MAX_LISTENER_SESSION
+	// and LISTENER_OVERFLOW); overflow is a mount name or a URL
+	MaxListeners      int     `json:"max_listeners"`
+	MaxListenersPerIP int     `json:"max_listeners_per_ip"`
+	MaxSessionSeconds float64 `json:"max_session_seconds"`
+	Overflow          string  `json:"overflow"`
+
+	// HLS segment length and playlist window
+	HLSSegmentSeconds float64 `json:"hls_segment_seconds"`
+	HLSWindow         int     `json:"hls_window"`
+
+	// Silence between sources on AutoDJ/live switches (defaults to HANDOFF_FADE)
+	HandoffFadeSeconds *float64 `json:"handoff_fade_seconds"`
+
+	// Seconds without audio before a live source goes off air (defaults to LIVE_STALL_TIMEOUT)
+	LiveStallSeconds float64 `json:"live_stall_seconds"`
+
+	// Dead-air detection (defaults to SILENCE_THRESHOLD_DB / SILENCE_TIMEOUT; silence_seconds 0 disables)
+	SilenceThresholdDB *float64 `json:"silence_threshold_db"`
+	SilenceSeconds     *float64 `json:"silence_seconds"`
+
+	// AutoDJ playlist: "backend" or "filesystem" (defaults to PLAYLIST_SOURCE)
+	Playlist              string             `json:"playlist"`
+	PlaylistMode          string             `json:"playlist_mode"`    // ordered | shuffle | weighted
+	PlaylistWeights       map[string]float64 `json:"playlist_weights"` // top-level folder => weight
+	PlaylistRescanSeconds float64            `json:"playlist_rescan_seconds"`
+	AutoDJResume          string             `json:"autodj_resume"` // restart | continue | next
+}
+
+// LoadStudios reads a JSON array of StudioConfig
+func LoadStudios(path string) ([]StudioConfig, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var studios []StudioConfig
+	if err := json.Unmarshal(b, &studios); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	for i, st := range studios {
+		if st.ID == "" {
+			return nil, fmt.Errorf("parse %s: studio #%d has no id", path, i)
+		}
+	}
+	return studios, nil
+}
@@ -3,9 +3,12 @@ module github.com/ivugurura/radio-studio
 go 1.25.1
 
 require (
-	github.com/google/uuid v1.6.0 // indirect
-	github.com/joho/godotenv v1.5.1 // indirect
-	github.com/oschwald/geoip2-golang v1.13.0 // indirect
+	github.com/google/uuid v1.6.0
+	github.com/joho/godotenv v1.5.1
+	github.com/oschwald/geoip2-golang v1.13.0
+)
+
+require (
 	github.com/oschwald/maxminddb-golang v1.13.0 // indirect
 	golang.org/x/sys v0.20.0 // indirect
 )
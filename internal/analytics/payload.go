@@ -4,6 +4,7 @@ import "time"
 
 type ListenerSession struct {
 	ID         string     `json:"id"`
+	Mount      string     `json:"mount"` // "main", "hls" or a transcoded mount
 	StartedAt  time.Time  `json:"started_at"`
 	EndedAt    *time.Time `json:"ended_at"`
 	IPHash     string     `json:"ip_hash"`
@@ -23,6 +24,7 @@ type ListenerBucket struct {
 	ActivePeak      int            `json:"active_peak"`
 	ListenerMinutes int            `json:"listener_minutes"`
 	Countries       map[string]int `json:"countries"`
+	MountPeaks      map[string]int `json:"mount_peaks"` // peak active listeners per mount
 }
 
 type IngestListenerBatch struct {
@@ -38,4 +40,12 @@ type IngestPlayBatch struct {
 	Source    string `json:"source,omitempty"`
 	StartedAt string `json:"started_at,omitempty"`
 	EndedAt   string `json:"ended_at,omitempty"`
+
+	// Audio of the track actually sent on air, and whether a pause, skip or live show cut it short
+	PlayedSec   float64 `json:"played_sec,omitempty"`
+	Interrupted bool    `json:"interrupted,omitempty"`
+
+	// Non-audio bytes dropped from the file while playing (corrupt data between frames)
+	SkippedBytes   int64 `json:"skipped_bytes,omitempty"`
+	CorruptRegions int   `json:"corrupt_regions,omitempty"`
 }
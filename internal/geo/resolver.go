@@ -4,6 +4,7 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"log"
+	"net"
 
 	"github.com/ivugurura/radio-studio/internal/listeners"
 	"github.com/oschwald/geoip2-golang"
@@ -36,11 +37,16 @@ func (r *Resolver) hash(l *listeners.Listener) {
 	if l.RemoteIP == nil {
 		return
 	}
-	sum := sha256.Sum256(append(r.salt, []byte(l.RemoteIP.String())...))
-	l.IPHash = hex.EncodeToString(sum[:])
+	l.IPHash = r.HashIP(l.RemoteIP)
 	l.RemoteIP = nil // drop raw IP
 }
 
+// HashIP is the salted hash listeners are identified by instead of their address
+func (r *Resolver) HashIP(ip net.IP) string {
+	sum := sha256.Sum256(append(append([]byte{}, r.salt...), []byte(ip.String())...))
+	return hex.EncodeToString(sum[:])
+}
+
 func (r *Resolver) Close() {
 	if r.db != nil {
 		r.db.Close()
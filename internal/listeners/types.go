@@ -9,6 +9,7 @@ import (
 type Listener struct {
 	ID       string
 	StudioId string
+	Mount    string // the stream listened to: "main", "hls" or a transcoded mount
 
 	// Connection metadata
 	ConnectedAt    time.Time
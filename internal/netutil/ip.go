@@ -26,6 +26,70 @@ func ExtractClientIp(r *http.Request) net.IP {
 	return nil
 }
 
+// RemoteIP is the address of the peer that opened the connection (ignores forwarding headers)
+func RemoteIP(r *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return net.ParseIP(host)
+}
+
+// TrustedClientIP returns the client address for security decisions. X-Forwarded-For is only
+// honoured when the peer is one of the trusted proxies, and is read right to left so a client
+// can't pick its own address: the first hop that isn't a trusted proxy is the client.
+func TrustedClientIP(r *http.Request, trusted []*net.IPNet) net.IP {
+	ip := RemoteIP(r)
+	if ip == nil || !ipInNets(ip, trusted) {
+		return ip
+	}
+	hops := strings.Split(r.Header.Get("X-Forwarded-For"), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := net.ParseIP(strings.TrimSpace(hops[i]))
+		if hop == nil {
+			break
+		}
+		ip = hop
+		if !ipInNets(hop, trusted) {
+			break
+		}
+	}
+	return ip
+}
+
+// ParseCIDRs parses a comma-separated list of CIDRs or single addresses
+func ParseCIDRs(list string) ([]*net.IPNet, error) {
+	var out []*net.IPNet
+	for _, p := range strings.Split(list, ",") {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		if !strings.Contains(p, "/") {
+			if ip := net.ParseIP(p); ip != nil && ip.To4() != nil {
+				p += "/32"
+			} else {
+				p += "/128"
+			}
+		}
+		_, n, err := net.ParseCIDR(p)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, n)
+	}
+	return out, nil
+}
+
+func ipInNets(ip net.IP, nets []*net.IPNet) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
 func ClassifyUserAgent(ua string) string {
 	l := strings.ToLower(ua)
 	switch {
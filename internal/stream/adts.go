@@ -0,0 +1,225 @@
+package stream
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"time"
+)
+
+// AAC in ADTS framing (the format of .aac files and of AAC/HE-AAC Icecast and SHOUTcast streams).
+// Every frame starts with a 7-byte header (9 with a CRC) giving its length and sample rate, which
+// is all the streaming code needs to align and pace it; the audio itself is not decoded.
+
+const adtsHeaderSize = 7
+
+var adtsSampleRates = [16]int{96000, 88200, 64000, 48000, 44100, 32000, 24000, 22050, 16000, 12000, 11025, 8000, 7350}
+
+// adtsHeader is a decoded ADTS frame header
+type adtsHeader struct {
+	MPEG2      bool // ID bit: MPEG-2 rather than MPEG-4 AAC
+	Profile    int  // audio object type - 1 (1 = AAC LC; HE-AAC is signalled as LC)
+	SampleRate int  // of the core AAC stream (half the output rate with SBR)
+	Channels   int  // channel configuration (0: given in the stream)
+	FrameSize  int  // bytes, including header
+	Samples    int  // samples per channel at SampleRate
+}
+
+// parseADTSHeader decodes b[0:7]; ok is false for anything that is not a usable frame header
+func parseADTSHeader(b []byte) (adtsHeader, bool) {
+	// syncword 0xFFF, layer 00; MPEG audio frames never have layer 00
+	if len(b) < adtsHeaderSize || b[0] != 0xFF || b[1]&0xF6 != 0xF0 {
+		return adtsHeader{}, false
+	}
+	rate := adtsSampleRates[(b[2]>>2)&0x0F]
+	size := int(b[3]&0x03)<<11 | int(b[4])<<3 | int(b[5])>>5
+	headerSize := adtsHeaderSize
+	if b[1]&0x01 == 0 {
+		headerSize += 2 // CRC
+	}
+	if rate == 0 || size <= headerSize {
+		return adtsHeader{}, false
+	}
+	return adtsHeader{
+		MPEG2:      b[1]&0x08 != 0,
+		Profile:    int(b[2] >> 6),
+		SampleRate: rate,
+		Channels:   int(b[2]&0x01)<<2 | int(b[3]>>6),
+		FrameSize:  size,
+		Samples:    1024 * (int(b[6]&0x03) + 1),
+	}, true
+}
+
+// compatible reports whether two headers plausibly belong to the same stream
+func (h adtsHeader) compatible(o adtsHeader) bool {
+	return h.MPEG2 == o.MPEG2 && h.Profile == o.Profile && h.SampleRate == o.SampleRate && h.Channels == o.Channels
+}
+
+func (h adtsHeader) Duration() time.Duration {
+	return time.Duration(h.Samples) * time.Second / time.Duration(h.SampleRate)
+}
+
+// adtsSync returns the offset of the first frame in b at or after from, or -1; a candidate is
+// confirmed by the header of the frame after it, as in mp3Sync
+func adtsSync(b []byte, from int) int {
+	for i := from; i+adtsHeaderSize <= len(b); i++ {
+		if b[i] != 0xFF {
+			continue
+		}
+		h, ok := parseADTSHeader(b[i:])
+		if !ok {
+			continue
+		}
+		next := i + h.FrameSize
+		if next > len(b) {
+			continue
+		}
+		if next+adtsHeaderSize > len(b) {
+			return i
+		}
+		if h2, ok := parseADTSHeader(b[next:]); ok && h.compatible(h2) {
+			return i
+		}
+	}
+	return -1
+}
+
+// adtsFramer re-chunks an arbitrary byte stream into whole ADTS frames; bytes that are not part
+// of a frame are dropped while resyncing
+type adtsFramer struct {
+	pending []byte
+	synced  bool
+	last    adtsHeader
+
+	junk int64 // bytes dropped while resyncing
+}
+
+// push appends data and returns the whole frames now available (possibly none)
+func (f *adtsFramer) push(data []byte) []byte {
+	f.pending = append(f.pending, data...)
+	var out []byte
+	i := 0
+	for len(f.pending)-i >= adtsHeaderSize {
+		if f.synced {
+			if h, ok := parseADTSHeader(f.pending[i:]); ok && h.compatible(f.last) {
+				if i+h.FrameSize > len(f.pending) {
+					break
+				}
+				out = append(out, f.pending[i:i+h.FrameSize]...)
+				i += h.FrameSize
+				continue
+			}
+			f.synced = false
+		}
+		j := adtsSync(f.pending, i)
+		if j < 0 {
+			// keep what could be the start of a frame
+			if len(f.pending)-i > mp3PassthroughWindow {
+				end := len(f.pending) - adtsHeaderSize
+				f.junk += int64(end - i)
+				i = end
+			}
+			break
+		}
+		f.junk += int64(j - i)
+		f.last, _ = parseADTSHeader(f.pending[j:])
+		f.synced = true
+		i = j
+	}
+	f.pending = append(f.pending[:0:0], f.pending[i:]...)
+	return out
+}
+
+// adtsFileReader yields the valid ADTS frames of a file, without its ID3 / APE tags
+type adtsFileReader struct {
+	r      *bufio.Reader
+	synced bool
+	last   adtsHeader
+
+	fileStats
+}
+
+func newADTSFileReader(f *os.File) (*adtsFileReader, error) {
+	r, err := audioSection(f)
+	if err != nil {
+		return nil, err
+	}
+	return &adtsFileReader{r: r}, nil
+}
+
+// next returns the next frame; io.EOF at the end of the audio region
+func (a *adtsFileReader) next() ([]byte, adtsHeader, error) {
+	inJunk := false
+	for {
+		hb, err := a.r.Peek(adtsHeaderSize)
+		if len(hb) < adtsHeaderSize {
+			if len(hb) > 0 {
+				a.skip(a.r, len(hb), &inJunk)
+			}
+			if err == nil {
+				err = io.EOF
+			}
+			return nil, adtsHeader{}, err
+		}
+		h, ok := parseADTSHeader(hb)
+		if ok && a.synced && !h.compatible(a.last) {
+			ok = false
+		}
+		if ok && !a.synced {
+			// not in sync yet: the following frame must confirm this header (unless the file ends here)
+			look, _ := a.r.Peek(h.FrameSize + adtsHeaderSize)
+			switch {
+			case len(look) == h.FrameSize:
+			case len(look) == h.FrameSize+adtsHeaderSize:
+				h2, ok2 := parseADTSHeader(look[h.FrameSize:])
+				ok = ok2 && h.compatible(h2)
+			default:
+				ok = false
+			}
+		}
+		if !ok {
+			a.synced = false
+			a.skip(a.r, 1, &inJunk)
+			continue
+		}
+		frame := make([]byte, h.FrameSize)
+		if n, err := io.ReadFull(a.r, frame); err != nil {
+			a.skip(a.r, 0, &inJunk)
+			a.junk += int64(n)
+			return nil, adtsHeader{}, io.EOF // truncated last frame
+		}
+		a.synced = true
+		a.last = h
+		a.frames++
+		return frame, h, nil
+	}
+}
+
+func (a *adtsFileReader) nextFrame() ([]byte, int, int, error) {
+	frame, h, err := a.next()
+	return frame, h.SampleRate, h.Samples, err
+}
+
+// adtsDuration returns the playback length of an ADTS file by walking its frame headers
+func adtsDuration(path string) (time.Duration, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	frames, err := newADTSFileReader(f)
+	if err != nil {
+		return 0, err
+	}
+	var clock mp3Clock
+	for {
+		_, h, err := frames.next()
+		if err == io.EOF {
+			return clock.elapsed(), nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		clock.addSamples(h.SampleRate, h.Samples)
+	}
+}
@@ -0,0 +1,153 @@
+package stream
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testADTSFrames returns n AAC LC frames (stereo, rate index ri, no CRC) of size bytes each;
+// the payload has no 0xFF bytes
+func testADTSFrames(ri byte, size, n int) []byte {
+	var out []byte
+	for i := 0; i < n; i++ {
+		f := make([]byte, size)
+		f[0], f[1] = 0xFF, 0xF1
+		f[2] = 1<<6 | ri<<2 // AAC LC
+		f[3] = 2<<6 | byte(size>>11)&0x03
+		f[4] = byte(size >> 3)
+		f[5] = byte(size)<<5 | 0x1F
+		f[6] = 0xFC
+		f[size-1] = byte(i) // tell frames apart
+		out = append(out, f...)
+	}
+	return out
+}
+
+func TestParseADTSHeader(t *testing.T) {
+	h, ok := parseADTSHeader(testADTSFrames(3, 200, 1))
+	if !ok {
+		t.Fatal("header not parsed")
+	}
+	if h.SampleRate != 48000 || h.FrameSize != 200 || h.Samples != 1024 || h.Channels != 2 || h.Profile != 1 {
+		t.Fatalf("header = %+v", h)
+	}
+	if h.Duration() != 21333333*time.Nanosecond {
+		t.Fatalf("duration = %v", h.Duration())
+	}
+	if _, ok := parseADTSHeader(testMP3Header); ok {
+		t.Fatal("an MP3 header parsed as ADTS")
+	}
+	if _, ok := parseMP3Header(testADTSFrames(3, 200, 1)); ok {
+		t.Fatal("an ADTS header parsed as MP3")
+	}
+}
+
+func TestADTSFramer(t *testing.T) {
+	frames := testADTSFrames(3, 200, 5)
+	var f adtsFramer
+	// junk, then the frames split mid-frame
+	out := f.push(append([]byte("junk"), frames[:450]...))
+	if !bytes.Equal(out, frames[:400]) {
+		t.Fatalf("got %d bytes, want the first two frames", len(out))
+	}
+	out = f.push(frames[450:])
+	if !bytes.Equal(out, frames[400:]) {
+		t.Fatalf("got %d bytes, want the rest", len(out))
+	}
+	if f.junk != 4 || f.last.SampleRate != 48000 {
+		t.Fatalf("junk = %d, last = %+v", f.junk, f.last)
+	}
+}
+
+func TestSniffCodec(t *testing.T) {
+	for name, c := range map[string]struct {
+		data []byte
+		want Codec
+	}{
+		"mp3":         {testMP3Frames(testMP3Header, 2), CodecMP3},
+		"aac":         {testADTSFrames(4, 300, 2), CodecAAC},
+		"aac at junk": {append([]byte{0xFF, 0x00, 'x'}, testADTSFrames(4, 300, 2)...), CodecAAC},
+		"ogg":         {[]byte("OggS\x00\x02"), CodecOgg},
+		"unknown":     {[]byte("hello"), CodecMP3},
+	} {
+		if got := sniffCodec(c.data); got != c.want {
+			t.Errorf("%s: %q, want %q", name, got, c.want)
+		}
+	}
+	if got, _ := codecFromContentType("audio/aacp"); got != CodecAAC {
+		t.Errorf("audio/aacp: %q", got)
+	}
+}
+
+func TestADTSFileReader(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "track.aac")
+	frames := testADTSFrames(6, 150, 47) // 24 kHz core: HE-AAC at 48 kHz
+	id3 := []byte{'I', 'D', '3', 3, 0, 0, 0, 0, 0, 10, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10}
+	if err := os.WriteFile(path, append(id3, frames...), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	r, err := newAudioFileReader(f, fileCodec(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []byte
+	for {
+		frame, rate, samples, err := r.nextFrame()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rate != 24000 || samples != 1024 {
+			t.Fatalf("rate %d, samples %d", rate, samples)
+		}
+		got = append(got, frame...)
+	}
+	if !bytes.Equal(got, frames) || r.stats().frames != 47 || r.stats().junk != 0 {
+		t.Fatalf("got %d bytes in %d frames, %d junk", len(got), r.stats().frames, r.stats().junk)
+	}
+	d, err := audioDuration(path)
+	if err != nil || d != 47*1024*time.Second/24000 {
+		t.Fatalf("duration %v, %v", d, err)
+	}
+}
+
+func TestAACListenerAndCodecHandoff(t *testing.T) {
+	s := newTestStudio(t)
+	s.push(sourceAutoDJ, testMP3Frames(testMP3Header, 3))
+
+	src := newTestSource(s, 1, nil)
+	s.addLiveSource(src)
+	aac := testADTSFrames(3, 200, 4)
+	s.liveData(src, aac)
+	if src.codec != CodecAAC {
+		t.Fatalf("source codec = %q", src.codec)
+	}
+	waitFor(t, "the AAC output", func() bool { return s.ring.currentFormat().codec == CodecAAC })
+
+	info := s.lastHandoff.Load()
+	if info == nil || !info.CodecMismatch || info.FromCodec != CodecMP3 || info.ToCodec != CodecAAC || info.ToSampleRate != 48000 {
+		t.Fatalf("handoff = %+v", info)
+	}
+	resp, _ := listen(t, s)
+	if ct := resp.Header.Get("Content-Type"); ct != "audio/aac" {
+		t.Fatalf("Content-Type = %q", ct)
+	}
+	got := make([]byte, len(aac))
+	if _, err := io.ReadFull(resp.Body, got); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, aac) {
+		t.Fatal("listener did not get the AAC frames")
+	}
+}
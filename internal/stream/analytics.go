@@ -28,7 +28,7 @@ func trunc(t time.Time, d time.Duration) time.Time {
 	return t.Truncate(d).UTC()
 }
 
-func (b *bucketState) addSample(now time.Time, active int, countries map[string]int) {
+func (b *bucketState) addSample(now time.Time, active int, countries, mounts map[string]int) {
 	b.mu.Lock()
 	defer b.mu.Unlock()
 
@@ -54,6 +54,7 @@ func (b *bucketState) addSample(now time.Time, active int, countries map[string]
 				Interval:    v.key,
 				BucketStart: start,
 				Countries:   map[string]int{},
+				MountPeaks:  map[string]int{},
 			}
 			m[start] = bkt
 		}
@@ -67,6 +68,9 @@ func (b *bucketState) addSample(now time.Time, active int, countries map[string]
 		for c, n := range countries {
 			bkt.Countries[c] += n
 		}
+		for name, n := range mounts {
+			bkt.MountPeaks[name] = max(bkt.MountPeaks[name], n)
+		}
 	}
 }
 
@@ -141,9 +145,9 @@ func (s *Studio) StartAnalytics(ingestURL, apiKey string, flushEvery time.Durati
 			}
 
 			now := time.Now().UTC()
-			active, countries, sessions := s.collectSessions()
-			// add a sample to peak/countries, and accrue listener-minutes since last flush
-			bk.addSample(now, active, countries)
+			active, countries, mounts, sessions := s.collectSessions()
+			// add a sample to peak/countries/mounts, and accrue listener-minutes since last flush
+			bk.addSample(now, active, countries, mounts)
 			bk.accrueListenerMinutes(now.Sub(last), active)
 			last = now
 
@@ -163,17 +167,15 @@ func (s *Studio) StartAnalytics(ingestURL, apiKey string, flushEvery time.Durati
 }
 
 // collectSessions reads current and recently disconnected listeners into DTOs and aggregates counts
-func (s *Studio) collectSessions() (active int, countries map[string]int, sessions []analytics.ListenerSession) {
+func (s *Studio) collectSessions() (active int, countries, mounts map[string]int, sessions []analytics.ListenerSession) {
 	countries = map[string]int{}
+	mounts = map[string]int{}
 
-	s.listenersMu.RLock()
-	defer s.listenersMu.RUnlock()
-
-	for sl := range s.streamListeners {
-		l := sl.l
+	for _, l := range s.listenersStore.Active() {
 		// aggregate
 		if l.DisconnectedAt.Load() == nil {
 			active++
+			mounts[l.Mount]++
 		}
 		if l.Country != "" {
 			countries[l.Country]++
@@ -181,6 +183,7 @@ func (s *Studio) collectSessions() (active int, countries map[string]int, sessio
 
 		session := analytics.ListenerSession{
 			ID:         l.ID,
+			Mount:      l.Mount,
 			StartedAt:  l.ConnectedAt,
 			IPHash:     l.IPHash,
 			UserAgent:  l.UserAgent,
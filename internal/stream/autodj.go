@@ -6,7 +6,8 @@ import (
 	"io"
 	"log"
 	"os"
-	"path/filepath"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/ivugurura/radio-studio/internal/analytics"
@@ -26,16 +27,47 @@ type AutoDJ interface {
 	Skip()
 	ForceReload()
 	Stop()
+	Pause()                                      // stop sending audio (e.g. a live show started)
+	Resume()                                     // continue according to the resume policy
 	NowPlaying() (Track, Track, time.Time, bool) // current, next, startedAt, ok
 }
 
+// ResumePolicy decides what the AutoDJ plays when it resumes after a pause
+type ResumePolicy string
+
+const (
+	ResumeRestart  ResumePolicy = "restart"  // play the interrupted track again from the top
+	ResumeContinue ResumePolicy = "continue" // pick up where the track was paused
+	ResumeNext     ResumePolicy = "next"     // start the next track
+)
+
+// ParseResumePolicy maps a config string to a ResumePolicy (default next)
+func ParseResumePolicy(s string) ResumePolicy {
+	switch ResumePolicy(strings.ToLower(s)) {
+	case ResumeRestart:
+		return ResumeRestart
+	case ResumeContinue, "resume":
+		return ResumeContinue
+	default:
+		return ResumeNext
+	}
+}
+
+// AutoDJOption customizes an AutoDJ
+type AutoDJOption func(*autoDJ)
+
+// WithResumePolicy sets what happens to an interrupted track when the AutoDJ resumes
+func WithResumePolicy(p ResumePolicy) AutoDJOption {
+	return func(a *autoDJ) { a.resumePolicy = p }
+}
+
 // default factory (filesystem)
 type AutoDJFactory func(dir string, studioID string, bitrate int, push func([]byte)) AutoDJ
 
 type autoDJ struct {
 	dir         string
 	push        func([]byte)
-	bitrateKbps int // configure (e.g. 128)
+	bitrateKbps int // studio bitrate (e.g. 128); pacing follows each file's own frame headers
 
 	ctrl chan djCommand
 
@@ -50,9 +82,18 @@ type autoDJ struct {
 
 	fallbackPath string
 
+	// pause state: while paused nothing is read or pushed; resumed is closed by Resume
+	pauseMu      sync.Mutex
+	paused       bool
+	resumed      chan struct{}
+	resumePolicy ResumePolicy
+
 	client *analytics.Client
 }
 
+// errTrackRestart asks Play to start the current track again instead of advancing
+var errTrackRestart = errors.New("restart track")
+
 func (a *autoDJ) lock() {
 	a.nowMu <- struct{}{}
 }
@@ -82,6 +123,56 @@ func (a *autoDJ) Stop() {
 	}
 }
 
+func (a *autoDJ) Pause() {
+	a.pauseMu.Lock()
+	defer a.pauseMu.Unlock()
+	if !a.paused {
+		a.paused = true
+		a.resumed = make(chan struct{})
+	}
+}
+
+func (a *autoDJ) Resume() {
+	a.pauseMu.Lock()
+	defer a.pauseMu.Unlock()
+	if a.paused {
+		a.paused = false
+		close(a.resumed)
+	}
+}
+
+// pauseState returns whether the AutoDJ is paused and the channel closed on resume
+func (a *autoDJ) pauseState() (bool, <-chan struct{}) {
+	a.pauseMu.Lock()
+	defer a.pauseMu.Unlock()
+	return a.paused, a.resumed
+}
+
+// waitResume blocks while paused. Skip and reload requests are remembered; it returns
+// context.Canceled if the AutoDJ is stopped meanwhile.
+func (a *autoDJ) waitResume(ctx context.Context) (skipped bool, err error) {
+	for {
+		paused, resumed := a.pauseState()
+		if !paused {
+			return skipped, nil
+		}
+		select {
+		case <-resumed:
+		case <-ctx.Done():
+			return skipped, context.Canceled
+		case cmd := <-a.ctrl:
+			switch cmd {
+			case cmdSkip:
+				skipped = true
+			case cmdForceReload:
+				a.playlist.forceReload()
+			case cmdStop:
+				return skipped, context.Canceled
+			}
+		}
+	}
+}
+
 func (a *autoDJ) NowPlaying() (Track, Track, time.Time, bool) {
 	a.lock()
 	defer a.unlock()
@@ -91,34 +182,117 @@ func (a *autoDJ) NowPlaying() (Track, Track, time.Time, bool) {
 	return a.current, a.next, a.startedAt, true
 }
 
-// NewAutoDJWithBackend selects backend-driven playlist if endpoint provided; falls back to filesystem otherwise.
-func NewAutoDJ(audioDir string, studioID string, bitrateKbps int, push func([]byte), studioEndpoint string, apiKey string, fallbackFile string) AutoDJ {
+// NewAutoDJ creates an AutoDJ driven by the backend playlist at studioEndpoint + "/playlist".
+func NewAutoDJ(audioDir string, studioID string, bitrateKbps int, push func([]byte), studioEndpoint string, apiKey string, fallbackFile string, opts ...AutoDJOption) AutoDJ {
 	playlistEndpoint := studioEndpoint + "/playlist"
-	ingestEndpoint := studioEndpoint + "/play-events"
-	return &autoDJ{
+	return NewAutoDJWithPlaylist(audioDir, bitrateKbps, push, newBackendPlaylist(audioDir, studioID, playlistEndpoint, apiKey), studioEndpoint, apiKey, fallbackFile, opts...)
+}
+
+// NewAutoDJWithPlaylist creates an AutoDJ over any PlaylistSource (e.g. NewFilesystemPlaylist).
+// Play events go to studioEndpoint + "/play-events" when studioEndpoint is set.
+func NewAutoDJWithPlaylist(audioDir string, bitrateKbps int, push func([]byte), playlist PlaylistSource, studioEndpoint string, apiKey string, fallbackFile string, opts ...AutoDJOption) AutoDJ {
+	ingestEndpoint := ""
+	if studioEndpoint != "" {
+		ingestEndpoint = studioEndpoint + "/play-events"
+	}
+	a := &autoDJ{
 		dir:          audioDir,
 		bitrateKbps:  bitrateKbps,
 		push:         push,
 		ctrl:         make(chan djCommand, 8),
-		playlist:     newBackendPlaylist(audioDir, studioID, playlistEndpoint, apiKey),
+		playlist:     playlist,
 		nowMu:        make(chan struct{}, 1),
 		fallbackPath: fallbackFile,
+		resumePolicy: ResumeNext,
 		client:       analytics.NewClient(ingestEndpoint, apiKey),
 	}
+	for _, o := range opts {
+		o(a)
+	}
+	return a
+}
+
+// trackStarted records that the current track's first audio went on air
+func (a *autoDJ) trackStarted(ctx context.Context) {
+	a.lock()
+	a.startedAt = time.Now()
+	cur := a.current
+	a.unlock()
+	a.client.SendPlayerBatch(ctx, []analytics.IngestPlayBatch{{
+		Type:      "track_started",
+		TrackID:   cur.ID,
+		File:      cur.File,
+		Source:    "AUTO",
+		StartedAt: time.Now().UTC().Format(time.RFC3339),
+	}})
+}
+
+// trackEnded reports the end of a track that went on air, after played of audio
+func (a *autoDJ) trackEnded(ctx context.Context, frames *fileStats, played time.Duration, interrupted bool) {
+	a.lock()
+	cur := a.current
+	a.unlock()
+	a.client.SendPlayerBatch(ctx, []analytics.IngestPlayBatch{{
+		Type:           "track_ended",
+		TrackID:        cur.ID,
+		File:           cur.File,
+		Source:         "AUTO",
+		EndedAt:        time.Now().UTC().Format(time.RFC3339),
+		PlayedSec:      played.Seconds(),
+		Interrupted:    interrupted,
+		SkippedBytes:   frames.junk,
+		CorruptRegions: frames.regions,
+	}})
 }
 
-func (a *autoDJ) streamFile(ctx context.Context, path string, bytesPerSec, chunkSize int) error {
+// streamFile sends the frames of path (MP3, or AAC in ADTS for .aac files) in chunks of about
+// chunkSize bytes, paced in real time by the duration of the frames sent (so CBR and VBR files of
+// any bitrate play at the right speed).
+// Play events are only sent once audio of the track has gone on air; a pause mid-track is
+// resolved by the resume policy.
+func (a *autoDJ) streamFile(ctx context.Context, path string, chunkSize int) error {
 	f, err := os.Open(path)
 	if err != nil {
 		return &TrackError{Path: path, Kind: "open", Err: err}
 	}
 	defer f.Close()
+	reader, err := newAudioFileReader(f, fileCodec(path))
+	if err != nil {
+		return &TrackError{Path: path, Kind: "open", Err: err}
+	}
+	frames := reader.stats()
 
 	start := time.Now()
-	var sent int64
-	buf := make([]byte, chunkSize)
+	var clock mp3Clock
+	onAir := false
 
 	for {
+		if paused, _ := a.pauseState(); paused {
+			skipped, err := a.waitResume(ctx)
+			if err != nil {
+				return err
+			}
+			switch {
+			case !onAir && !skipped:
+				// nothing of this track was heard yet: play it from the top
+				start = time.Now()
+			case !onAir:
+				return &TrackError{Path: path, Kind: "skipped", Err: io.EOF}
+			case skipped || a.resumePolicy == ResumeNext:
+				a.trackEnded(ctx, frames, clock.elapsed(), true)
+				return &TrackError{Path: path, Kind: "interrupted", Err: io.EOF}
+			case a.resumePolicy == ResumeRestart:
+				a.trackEnded(ctx, frames, clock.elapsed(), true)
+				return errTrackRestart
+			default:
+				// continue: re-base pacing so the rest of the track plays in real time from now
+				start = time.Now().Add(-clock.elapsed())
+				a.lock()
+				a.startedAt = start
+				a.unlock()
+			}
+		}
+
 		select {
 		case <-ctx.Done():
 			return context.Canceled
@@ -130,6 +304,9 @@ func (a *autoDJ) streamFile(ctx context.Context, path string, bytesPerSec, chunk
 				same := a.activeFile == path
 				a.unlock()
 				if same {
+					if onAir {
+						a.trackEnded(ctx, frames, clock.elapsed(), true)
+					}
 					return &TrackError{Path: path, Kind: "skipped", Err: io.EOF}
 				}
 			case cmdForceReload:
@@ -140,14 +317,26 @@ func (a *autoDJ) streamFile(ctx context.Context, path string, bytesPerSec, chunk
 		default:
 		}
 
-		n, rerr := f.Read(buf)
-		if n > 0 {
-			chunk := make([]byte, n)
-			copy(chunk, buf[:n])
+		// Only whole frames go on air: tags, cover art and corrupt bytes are dropped
+		var chunk []byte
+		var rerr error
+		for len(chunk) < chunkSize {
+			frame, rate, samples, err := reader.nextFrame()
+			if err != nil {
+				rerr = err
+				break
+			}
+			chunk = append(chunk, frame...)
+			clock.addSamples(rate, samples)
+		}
+		if len(chunk) > 0 {
+			if !onAir {
+				onAir = true
+				a.trackStarted(ctx)
+			}
 			a.push(chunk)
-			sent += int64(n)
-			// pacing
-			expected := time.Duration(float64(sent) / float64(bytesPerSec) * float64(time.Second))
+			// pacing: stay in step with the playback time of the frames sent
+			expected := clock.elapsed()
 			elapsed := time.Since(start)
 			if expected > elapsed {
 				time.Sleep(expected - elapsed)
@@ -155,13 +344,13 @@ func (a *autoDJ) streamFile(ctx context.Context, path string, bytesPerSec, chunk
 		}
 		if rerr != nil {
 			if rerr == io.EOF {
-				a.client.SendPlayerBatch(ctx, []analytics.IngestPlayBatch{{
-					Type:    "track_ended",
-					TrackID: a.current.ID,
-					File:    a.current.File,
-					Source:  "AUTO",
-					EndedAt: time.Now().UTC().Format(time.RFC3339),
-				}})
+				if frames.junk > 0 {
+					log.Printf("AudioDJ: %s: skipped %d corrupt bytes in %d region(s)", path, frames.junk, frames.regions)
+				}
+				if frames.frames == 0 {
+					return &TrackError{Path: path, Kind: "no audio frames", Err: io.EOF}
+				}
+				a.trackEnded(ctx, frames, clock.elapsed(), false)
 				return nil // normal end
 			}
 			return &TrackError{Path: path, Kind: "read", Err: rerr}
@@ -171,33 +360,25 @@ func (a *autoDJ) streamFile(ctx context.Context, path string, bytesPerSec, chunk
 
 // attempt to stream the fallback track if configured and present.
 // returns true if it started streaming fallback, false otherwise.
-func (a *autoDJ) tryFallback(ctx context.Context, bytesPerSec, chunkSize int) bool {
+func (a *autoDJ) tryFallback(ctx context.Context, chunkSize int) bool {
 	if a.fallbackPath == "" {
 		return false
 	}
 	if _, err := os.Stat(a.fallbackPath); err != nil {
 		return false
 	}
-	_, name := filepath.Split(a.fallbackPath)
-
 	a.lock()
-	a.current = Track{Title: name, File: a.fallbackPath}
+	a.current = Track{File: a.fallbackPath, Fallback: true}.withTags(true)
 	a.next = Track{}
 	a.startedAt = time.Now()
 	a.activeFile = a.fallbackPath
-
-	a.client.SendPlayerBatch(ctx, []analytics.IngestPlayBatch{{
-		Type:      "track_started",
-		TrackID:   a.current.ID,
-		File:      a.current.File,
-		Source:    "AUTO",
-		StartedAt: time.Now().UTC().Format(time.RFC3339),
-	}})
-
 	a.unlock()
 
-	err := a.streamFile(ctx, a.fallbackPath, bytesPerSec, chunkSize)
-	if err != nil && !errors.Is(err, io.EOF) {
+	err := a.streamFile(ctx, a.fallbackPath, chunkSize)
+	for errors.Is(err, errTrackRestart) {
+		err = a.streamFile(ctx, a.fallbackPath, chunkSize)
+	}
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, context.Canceled) {
 		log.Printf("autoDJ: error streaming fallback %s: %v", a.fallbackPath, err)
 	}
 	a.lock()
@@ -207,8 +388,6 @@ func (a *autoDJ) tryFallback(ctx context.Context, bytesPerSec, chunkSize int) bo
 }
 
 func (a *autoDJ) Play(ctx context.Context) {
-	// 128 kbps => 16 KB/s
-	bytesPerSec := int(float64(a.bitrateKbps) * 1000.0 / 8.0)
 	chunkSize := 4096
 
 	for {
@@ -218,6 +397,16 @@ func (a *autoDJ) Play(ctx context.Context) {
 			return
 		default:
 		}
+		// While paused (live show) don't pick a track; the next one is chosen on resume.
+		// A skip requested meanwhile drops the track that was due.
+		skipped, err := a.waitResume(ctx)
+		if err != nil {
+			return
+		}
+		if skipped {
+			a.playlist.ensure()
+			a.playlist.advance()
+		}
 
 		// ensure we have a playlist
 		a.playlist.ensure()
@@ -227,7 +416,7 @@ func (a *autoDJ) Play(ctx context.Context) {
 				cur = c2
 				ok = true
 			} else {
-				if a.tryFallback(ctx, bytesPerSec, chunkSize) {
+				if a.tryFallback(ctx, chunkSize) {
 					continue
 				}
 				time.Sleep(3 * time.Second)
@@ -235,6 +424,8 @@ func (a *autoDJ) Play(ctx context.Context) {
 			}
 		}
 		next, _ := a.playlist.nextTrack()
+		cur = cur.withTags(true)
+		next = next.withTags(false)
 
 		// Update now playing
 		a.lock()
@@ -242,22 +433,17 @@ func (a *autoDJ) Play(ctx context.Context) {
 		a.next = next
 		a.startedAt = time.Now()
 		a.activeFile = cur.File
-
-		a.client.SendPlayerBatch(ctx, []analytics.IngestPlayBatch{{
-			Type:      "track_started",
-			TrackID:   a.current.ID,
-			File:      a.current.File,
-			Source:    "AUTO",
-			StartedAt: time.Now().UTC().Format(time.RFC3339),
-		}})
-
 		a.unlock()
 
 		log.Printf("AudioDJ: playing %s", cur.Title)
-		if err := a.streamFile(ctx, cur.File, bytesPerSec, chunkSize); err != nil {
+		if err := a.streamFile(ctx, cur.File, chunkSize); err != nil {
 			if errors.Is(err, context.Canceled) {
 				return
 			}
+			if errors.Is(err, errTrackRestart) {
+				log.Printf("AudioDJ: restarting %s after pause", cur.Title)
+				continue
+			}
 			// log * continue to the enxt track
 			log.Printf("AudioDJ: file ended (%s): %v", cur.Title, err)
 		}
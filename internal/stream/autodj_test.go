@@ -0,0 +1,183 @@
+package stream
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakePlaylist cycles through fixed tracks, starting on the first
+type fakePlaylist struct {
+	mu      sync.Mutex
+	tracks  []Track
+	idx     int
+	reloads atomic.Int32
+}
+
+func (p *fakePlaylist) ensure() {}
+
+func (p *fakePlaylist) current() (Track, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.tracks[p.idx], true
+}
+
+func (p *fakePlaylist) nextTrack() (Track, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.tracks[(p.idx+1)%len(p.tracks)], true
+}
+
+func (p *fakePlaylist) advance() (Track, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.idx = (p.idx + 1) % len(p.tracks)
+	return p.tracks[p.idx], true
+}
+
+func (p *fakePlaylist) forceReload() { p.reloads.Add(1) }
+
+// djChunk is one push of the AutoDJ: the playing track and the index of its first frame
+type djChunk struct {
+	track string
+	frame byte
+}
+
+type djHarness struct {
+	dj       *autoDJ
+	playlist *fakePlaylist
+	chunks   chan djChunk
+}
+
+// newDJHarness plays a.mp3 and b.mp3, twelve frames each (a chunk of ten, then two).
+// With pauseOnAir the AutoDJ pauses itself as the first chunk goes on air. play starts it.
+func newDJHarness(t *testing.T, policy ResumePolicy, pauseOnAir bool) *djHarness {
+	t.Helper()
+	dir := t.TempDir()
+	pl := &fakePlaylist{}
+	for _, name := range []string{"a.mp3", "b.mp3"} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, testMP3Frames(testMP3Header, 12), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		pl.tracks = append(pl.tracks, Track{ID: name, File: path})
+	}
+	h := &djHarness{playlist: pl, chunks: make(chan djChunk, 64)}
+	frameSize := len(testMP3Frames(testMP3Header, 1))
+	var once sync.Once
+	push := func(b []byte) {
+		cur, _, _, _ := h.dj.NowPlaying()
+		h.chunks <- djChunk{track: filepath.Base(cur.File), frame: b[frameSize-1]}
+		if pauseOnAir {
+			once.Do(h.dj.Pause)
+		}
+	}
+	h.dj = NewAutoDJWithPlaylist(dir, 128, push, pl, "", "", "", WithResumePolicy(policy)).(*autoDJ)
+	return h
+}
+
+func (h *djHarness) play(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		h.dj.Play(ctx)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		h.dj.Resume()
+		cancel()
+		<-done
+	})
+}
+
+func (h *djHarness) next(t *testing.T) djChunk {
+	t.Helper()
+	select {
+	case c := <-h.chunks:
+		return c
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for audio")
+		return djChunk{}
+	}
+}
+
+// waitPaused returns once the AutoDJ is parked in waitResume: a command sent while paused is
+// only taken from the queue there
+func (h *djHarness) waitPaused(t *testing.T, cmd func()) {
+	t.Helper()
+	cmd()
+	waitFor(t, "the paused AutoDJ to take the command", func() bool { return len(h.dj.ctrl) == 0 })
+}
+
+func TestAutoDJResumePolicies(t *testing.T) {
+	tests := []struct {
+		policy ResumePolicy
+		want   djChunk // the first chunk after resuming
+	}{
+		{ResumeNext, djChunk{"b.mp3", 0}},
+		{ResumeRestart, djChunk{"a.mp3", 0}},
+		{ResumeContinue, djChunk{"a.mp3", 10}},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.policy), func(t *testing.T) {
+			h := newDJHarness(t, tt.policy, true)
+			h.play(t)
+			if c := h.next(t); c != (djChunk{"a.mp3", 0}) {
+				t.Fatalf("first chunk = %+v", c)
+			}
+			h.waitPaused(t, h.dj.ForceReload)
+			if h.playlist.reloads.Load() != 1 {
+				t.Fatal("reload requested while paused was dropped")
+			}
+			select {
+			case c := <-h.chunks:
+				t.Fatalf("pushed %+v while paused", c)
+			default:
+			}
+			h.dj.Resume()
+			if c := h.next(t); c != tt.want {
+				t.Fatalf("after resuming = %+v, want %+v", c, tt.want)
+			}
+		})
+	}
+}
+
+func TestAutoDJSkipWhilePaused(t *testing.T) {
+	// mid-track, a skip overrides the continue policy
+	h := newDJHarness(t, ResumeContinue, true)
+	h.play(t)
+	h.next(t)
+	h.waitPaused(t, h.dj.Skip)
+	h.dj.Resume()
+	if c := h.next(t); c != (djChunk{"b.mp3", 0}) {
+		t.Fatalf("after a skip while paused = %+v", c)
+	}
+
+	// between tracks, it drops the track that was due
+	h = newDJHarness(t, ResumeContinue, false)
+	h.dj.Pause()
+	h.play(t)
+	h.waitPaused(t, h.dj.Skip)
+	h.dj.Resume()
+	if c := h.next(t); c != (djChunk{"b.mp3", 0}) {
+		t.Fatalf("after a skip while paused between tracks = %+v", c)
+	}
+}
+
+func TestParseResumePolicy(t *testing.T) {
+	for in, want := range map[string]ResumePolicy{
+		"restart":  ResumeRestart,
+		"Continue": ResumeContinue,
+		"resume":   ResumeContinue,
+		"next":     ResumeNext,
+		"":         ResumeNext,
+	} {
+		if got := ParseResumePolicy(in); got != want {
+			t.Errorf("%q: %q, want %q", in, got, want)
+		}
+	}
+}
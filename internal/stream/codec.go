@@ -0,0 +1,110 @@
+package stream
+
+import (
+	"bytes"
+	"mime"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Codec is the container/codec of a stream. MP3 is re-framed, levelled and segmented for HLS;
+// AAC is re-framed on ADTS frames; other codecs are passed through on their own packet boundaries.
+type Codec string
+
+const (
+	CodecMP3 Codec = "mp3"
+	CodecAAC Codec = "aac" // AAC or HE-AAC in ADTS frames
+	CodecOgg Codec = "ogg" // Vorbis, Opus or FLAC in Ogg
+)
+
+// ContentType is the Content-Type listeners get for the codec
+func (c Codec) ContentType() string {
+	switch c {
+	case CodecAAC:
+		return "audio/aac"
+	case CodecOgg:
+		return "audio/ogg"
+	default:
+		return "audio/mpeg"
+	}
+}
+
+// codecFromContentType maps a source's Content-Type to a codec; ok is false when it doesn't say
+// (missing, application/octet-stream, ...) and the codec has to be sniffed from the data
+func codecFromContentType(ct string) (Codec, bool) {
+	mt, _, err := mime.ParseMediaType(ct)
+	if err != nil {
+		mt = strings.ToLower(strings.TrimSpace(ct))
+	}
+	switch mt {
+	case "audio/mpeg", "audio/mp3", "audio/mpeg3", "audio/x-mpeg":
+		return CodecMP3, true
+	case "audio/aac", "audio/aacp", "audio/x-aac", "audio/x-hx-aac-adts":
+		return CodecAAC, true
+	case "application/ogg", "audio/ogg", "audio/opus", "audio/vorbis", "audio/x-vorbis", "audio/flac+ogg":
+		return CodecOgg, true
+	}
+	return "", false
+}
+
+// sniffCodec recognises a codec from the first bytes of a stream (MP3 if nothing else matches):
+// whichever of an ADTS or an MPEG audio frame is found first
+func sniffCodec(b []byte) Codec {
+	if bytes.HasPrefix(b, oggCapture) {
+		return CodecOgg
+	}
+	if a := adtsSync(b, 0); a >= 0 {
+		if m := mp3Sync(b, 0); m < 0 || a < m {
+			return CodecAAC
+		}
+	}
+	return CodecMP3
+}
+
+// fileCodec is the codec of an AutoDJ audio file, by extension
+func fileCodec(path string) Codec {
+	if strings.EqualFold(filepath.Ext(path), ".aac") {
+		return CodecAAC
+	}
+	return CodecMP3
+}
+
+// audioFileReader yields the frames of an audio file with their length in samples at rate,
+// io.EOF at the end of the audio
+type audioFileReader interface {
+	nextFrame() (frame []byte, rate, samples int, err error)
+	stats() *fileStats
+}
+
+func newAudioFileReader(f *os.File, codec Codec) (audioFileReader, error) {
+	if codec == CodecAAC {
+		return newADTSFileReader(f)
+	}
+	return newMP3FileReader(f)
+}
+
+// audioDuration returns the playback length of an AutoDJ audio file
+func audioDuration(path string) (time.Duration, error) {
+	if fileCodec(path) == CodecAAC {
+		return adtsDuration(path)
+	}
+	return mp3Duration(path)
+}
+
+// streamFormat describes the chunks of the ring written after it was set: their codec and, for
+// Ogg, the header pages a listener joining mid-stream needs before any audio page
+type streamFormat struct {
+	codec   Codec
+	headers []byte
+	start   uint64 // ring sequence of the first chunk in this format (which starts with the headers)
+}
+
+// preamble is what a reader entering the format at cursor must be sent first
+func (f *streamFormat) preamble(cursor uint64) []byte {
+	if f.codec == CodecOgg && cursor > f.start {
+		return f.headers
+	}
+	return nil
+}
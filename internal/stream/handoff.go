@@ -0,0 +1,117 @@
+package stream
+
+import (
+	"bytes"
+	"log"
+	"strconv"
+	"time"
+)
+
+// Source handoff: the feed carries chunks tagged with the source that produced them. When the
+// source changes, the distributor finishes the outgoing source on a frame boundary (its partial
+// frame is dropped), plays a short run of silent frames and starts framing the new source afresh,
+// so listeners never receive a frame spliced from two streams. The silence is made from the
+// outgoing MP3 header; after an AAC or Ogg source there is none, and the switch is a plain cut.
+
+const (
+	sourceAutoDJ = "autodj"
+
+	defaultHandoffFade = 200 * time.Millisecond
+)
+
+// feedChunk is a piece of upstream audio and the source it came from
+type feedChunk struct {
+	source string
+	codec  Codec
+	data   []byte
+}
+
+// handoffInfo describes the most recent switch between sources
+type handoffInfo struct {
+	From           string    `json:"from"`
+	To             string    `json:"to"`
+	At             time.Time `json:"at"`
+	FromCodec      Codec     `json:"from_codec,omitempty"`
+	ToCodec        Codec     `json:"to_codec,omitempty"`
+	CodecMismatch  bool      `json:"codec_mismatch"` // listeners were reconnected in the new codec
+	FromSampleRate int       `json:"from_sample_rate,omitempty"`
+	ToSampleRate   int       `json:"to_sample_rate,omitempty"`
+	RateMismatch   bool      `json:"rate_mismatch"`
+	DroppedBytes   int       `json:"dropped_bytes"` // partial frame left by the outgoing source
+	SilentFrames   int       `json:"silent_frames"`
+}
+
+// WithHandoffFade sets how much silence is played between two sources (0 switches directly)
+func WithHandoffFade(d time.Duration) StudioOption {
+	return func(s *Studio) {
+		if d >= 0 {
+			s.handoffFade = d
+		}
+	}
+}
+
+// nextLiveSource names a new live connection; each connection is a distinct feed source
+func (s *Studio) nextLiveSource() string {
+	return sourceLivePrefix + strconv.FormatUint(s.liveSessions.Add(1), 10)
+}
+
+// handoff switches the distributor from one source to the next on a frame boundary
+func (s *Studio) handoff(from, to string) {
+	prev, prevAAC := s.framer, s.adts
+	s.framer = mp3Framer{}
+	s.adts = adtsFramer{}
+	s.pager = oggPager{}
+	info := &handoffInfo{
+		From:           from,
+		To:             to,
+		At:             time.Now().UTC(),
+		FromCodec:      s.ring.currentFormat().codec,
+		FromSampleRate: prev.last.SampleRate,
+		DroppedBytes:   len(prev.pending),
+	}
+	if info.FromCodec == CodecAAC {
+		info.FromSampleRate, info.DroppedBytes = prevAAC.last.SampleRate, len(prevAAC.pending)
+	}
+	if prev.last.SampleRate > 0 && s.handoffFade > 0 {
+		if silence := silentMP3Frame(prev.lastRaw[:]); silence != nil {
+			frameDur := prev.last.Duration()
+			info.SilentFrames = int((s.handoffFade + frameDur - 1) / frameDur)
+			out := bytes.Repeat(silence, info.SilentFrames)
+			s.ring.write(out)
+			s.hls.add(CodecMP3, out, s.hlsTitle)
+		}
+	}
+	log.Printf("Studio %s: handoff %s -> %s (dropped %d bytes, %d silent frames)", s.ID, from, to, info.DroppedBytes, info.SilentFrames)
+	s.pendingHandoff = info
+}
+
+// checkHandoff completes the pending handoff once the incoming source's format is known.
+// Sources in different codecs can't share a listener connection; sample rates are compared
+// between MP3 or AAC sources.
+func (s *Studio) checkHandoff(codec Codec) {
+	info := s.pendingHandoff
+	if info == nil {
+		return
+	}
+	rate := 0
+	switch codec {
+	case CodecMP3:
+		rate = s.framer.last.SampleRate
+	case CodecAAC:
+		rate = s.adts.last.SampleRate
+	}
+	if rate == 0 && codec != CodecOgg {
+		return
+	}
+	s.pendingHandoff = nil
+	info.ToCodec, info.ToSampleRate = codec, rate
+	switch {
+	case info.FromCodec != codec:
+		info.CodecMismatch = true
+		log.Printf("Studio %s: codec mismatch on handoff %s -> %s (%s -> %s); listeners are reconnected", s.ID, info.From, info.To, info.FromCodec, codec)
+	case info.FromSampleRate > 0 && rate > 0 && info.FromSampleRate != rate:
+		info.RateMismatch = true
+		log.Printf("Studio %s: sample rate mismatch on handoff %s -> %s (%d Hz -> %d Hz); players may glitch", s.ID, info.From, info.To, info.FromSampleRate, info.ToSampleRate)
+	}
+	s.lastHandoff.Store(info)
+}
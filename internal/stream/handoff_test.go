@@ -0,0 +1,69 @@
+package stream
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+// ringContents returns everything the studio's ring holds, across formats
+func ringContents(s *Studio) []byte {
+	var out []byte
+	for cursor := uint64(0); ; {
+		res := s.ring.read(cursor, nil, 1<<20)
+		if len(res.data) == 0 {
+			return out
+		}
+		out = append(out, res.data...)
+		cursor = res.next
+	}
+}
+
+func TestHandoffOnFrameBoundary(t *testing.T) {
+	s := newTestStudio(t, WithHandoffFade(100*time.Millisecond))
+	autodj := testMP3Frames(testMP3Header, 3)
+	half := testMP3Frames(testMP3Header, 1)[:200]
+	live := testMP3Frames([]byte{0xFF, 0xFB, 0x94, 0x64}, 2) // 48 kHz
+	s.push(sourceAutoDJ, append(append([]byte{}, autodj...), half...))
+	s.push(sourceLivePrefix+"1", live)
+
+	silence := silentMP3Frame(testMP3Header)
+	// 100ms of 26ms frames, rounded up
+	want := append(append(append([]byte{}, autodj...), bytes.Repeat(silence, 4)...), live...)
+	waitFor(t, "the live frames", func() bool { return s.ring.buffered() == len(want) })
+	if !bytes.Equal(ringContents(s), want) {
+		t.Fatal("output is not the AutoDJ frames, the silence and the live frames")
+	}
+	info := s.lastHandoff.Load()
+	if info == nil || info.From != sourceAutoDJ || info.To != sourceLivePrefix+"1" || info.DroppedBytes != len(half) ||
+		info.SilentFrames != 4 || !info.RateMismatch || info.FromSampleRate != 44100 || info.ToSampleRate != 48000 {
+		t.Fatalf("handoff = %+v", info)
+	}
+}
+
+func TestHandoffWithoutFade(t *testing.T) {
+	s := newTestStudio(t, WithHandoffFade(0))
+	a, b := testMP3Frames(testMP3Header, 2), testMP3Frames(testMP3Header, 3)
+	s.push(sourceAutoDJ, a)
+	s.push(sourceLivePrefix+"1", b)
+	waitFor(t, "the live frames", func() bool { return s.ring.buffered() == len(a)+len(b) })
+	if info := s.lastHandoff.Load(); info == nil || info.SilentFrames != 0 || info.RateMismatch || info.CodecMismatch {
+		t.Fatalf("handoff = %+v", info)
+	}
+}
+
+func TestHandoffFromAACIsACut(t *testing.T) {
+	s := newTestStudio(t, WithHandoffFade(100*time.Millisecond))
+	aac, mp3 := testADTSFrames(3, 200, 3), testMP3Frames(testMP3Header, 2)
+	s.pushCodec(sourceAutoDJ, CodecAAC, aac)
+	s.push(sourceLivePrefix+"1", mp3)
+	waitFor(t, "the MP3 frames", func() bool { return s.ring.buffered() >= len(aac)+len(mp3) })
+	// there is no silence to play after AAC: the MP3 starts right after the last AAC frame
+	if got := ringContents(s); !bytes.Equal(got, append(append([]byte{}, aac...), mp3...)) {
+		t.Fatalf("output is %d bytes, want the AAC and MP3 frames only", len(got))
+	}
+	info := s.lastHandoff.Load()
+	if info == nil || info.SilentFrames != 0 || !info.CodecMismatch || info.FromCodec != CodecAAC || info.FromSampleRate != 48000 {
+		t.Fatalf("handoff = %+v", info)
+	}
+}
@@ -0,0 +1,398 @@
+package stream
+
+import (
+	"encoding/binary"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/ivugurura/radio-studio/internal/listeners"
+	"github.com/ivugurura/radio-studio/internal/netutil"
+)
+
+// HLS output: the studio feed is cut into packed-audio segments (ID3 timestamp tag + MP3 or ADTS
+// frames) with a rolling media playlist. A change between MP3 and AAC starts a new segment marked
+// as a discontinuity. Ogg can't be packed: while it is on air the playlist is ended, and a fresh one
+// starts when MP3 or AAC resumes. Listeners are tracked per session from their segment fetches.
+//
+//	/studio/{id}/hls/index.m3u8             master playlist, assigns a session
+//	/studio/{id}/hls/live.m3u8?sid=...      media playlist
+//	/studio/{id}/hls/{seq}.mp3?sid=...      segment (.aac for AAC)
+
+const (
+	defaultHLSSegment = 6 * time.Second
+	defaultHLSWindow  = 5
+
+	hlsMount = "hls" // the mount HLS sessions are counted under
+)
+
+// WithHLS sets the target segment duration and how many segments the playlist advertises
+func WithHLS(segment time.Duration, window int) StudioOption {
+	return func(s *Studio) {
+		if segment > 0 {
+			s.hls.target = segment
+		}
+		if window > 0 {
+			s.hls.window = window
+		}
+	}
+}
+
+type hlsSegment struct {
+	seq           uint64
+	codec         Codec
+	discontinuity bool // the codec changed from the previous segment
+	duration      time.Duration
+	started       time.Time
+	data          []byte
+}
+
+type hlsSession struct {
+	l        *listeners.Listener
+	lastSeen time.Time
+}
+
+type hlsOutput struct {
+	mu       sync.RWMutex
+	target   time.Duration
+	window   int
+	segments []*hlsSegment
+	nextSeq  uint64
+	codec    Codec  // of the latest audio
+	ended    bool   // Ogg on air: the playlist carries EXT-X-ENDLIST
+	discSeq  uint64 // discontinuities dropped from the segment list
+
+	// segment being built
+	cur      []byte
+	curCodec Codec
+	curDisc  bool
+	curDur   time.Duration
+	curStart time.Time
+	curTitle string
+	// the ID3 timestamps count the samples segmented at rate, on top of the 90 kHz ticks
+	// segmented before the rate last changed
+	samples uint64
+	rate    int
+	ptsBase uint64
+
+	sessMu   sync.Mutex
+	sessions map[string]*hlsSession
+}
+
+func newHLSOutput() *hlsOutput {
+	return &hlsOutput{
+		target:   defaultHLSSegment,
+		window:   defaultHLSWindow,
+		sessions: make(map[string]*hlsSession),
+	}
+}
+
+// add consumes whole MP3 or ADTS frames from the distributor; title is the now-playing text at
+// this point
+func (h *hlsOutput) add(codec Codec, frames []byte, title func() string) {
+	h.mu.Lock()
+	prev, ended := h.codec, h.ended
+	h.codec, h.ended = codec, false
+	if ended {
+		// the last playlist was ended: start a fresh one
+		h.discSeq += uint64(countDiscontinuities(h.segments))
+		h.segments = nil
+	}
+	h.mu.Unlock()
+	if codec != prev && prev != "" && !ended {
+		if len(h.cur) > 0 {
+			h.cut()
+		}
+		h.curDisc = true
+	}
+	for len(frames) >= 4 {
+		size, rate, samples, ok := hlsFrame(codec, frames)
+		if !ok || size > len(frames) {
+			return // nothing to segment
+		}
+		if rate != h.rate {
+			h.ptsBase, h.samples, h.rate = h.pts(), 0, rate
+		}
+		if len(h.cur) == 0 {
+			h.curCodec = codec
+			h.curStart = time.Now()
+			h.curTitle = title()
+			h.cur = append(h.cur, hlsID3(h.pts(), h.curTitle)...)
+		}
+		h.cur = append(h.cur, frames[:size]...)
+		h.curDur += time.Duration(samples) * time.Second / time.Duration(rate)
+		h.samples += uint64(samples)
+		frames = frames[size:]
+		if h.curDur >= h.target {
+			h.cut()
+		}
+	}
+}
+
+// pts is the 90 kHz timestamp of the next frame
+func (h *hlsOutput) pts() uint64 {
+	if h.rate == 0 {
+		return h.ptsBase
+	}
+	return h.ptsBase + h.samples*90000/uint64(h.rate)
+}
+
+// end closes the playlist while audio HLS can't carry (Ogg) is on air
+func (h *hlsOutput) end() {
+	if len(h.cur) > 0 {
+		h.cut()
+	}
+	h.mu.Lock()
+	h.ended = true
+	h.mu.Unlock()
+}
+
+// hlsFrame parses the frame at the start of b: its size in bytes, sample rate and samples
+func hlsFrame(codec Codec, b []byte) (size, rate, samples int, ok bool) {
+	switch codec {
+	case CodecMP3:
+		h, ok := parseMP3Header(b)
+		return h.FrameSize, h.SampleRate, h.Samples, ok
+	case CodecAAC:
+		h, ok := parseADTSHeader(b)
+		return h.FrameSize, h.SampleRate, h.Samples, ok
+	}
+	return 0, 0, 0, false
+}
+
+// hlsExt is the segment file extension for codec
+func hlsExt(codec Codec) string {
+	if codec == CodecAAC {
+		return "aac"
+	}
+	return "mp3"
+}
+
+func countDiscontinuities(segs []*hlsSegment) int {
+	n := 0
+	for _, seg := range segs {
+		if seg.discontinuity {
+			n++
+		}
+	}
+	return n
+}
+
+func (h *hlsOutput) cut() {
+	seg := &hlsSegment{codec: h.curCodec, discontinuity: h.curDisc, duration: h.curDur, started: h.curStart, data: h.cur}
+	h.mu.Lock()
+	seg.seq = h.nextSeq
+	h.nextSeq++
+	h.segments = append(h.segments, seg)
+	// keep a couple of segments past the window for clients that are slightly behind
+	if extra := len(h.segments) - (h.window + 2); extra > 0 {
+		h.discSeq += uint64(countDiscontinuities(h.segments[:extra]))
+		h.segments = append([]*hlsSegment(nil), h.segments[extra:]...)
+	}
+	h.mu.Unlock()
+	h.cur = nil
+	h.curDur = 0
+	h.curDisc = false
+}
+
+// currentCodec is the codec of the latest segmented audio
+func (h *hlsOutput) currentCodec() Codec {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	if h.codec == CodecAAC {
+		return CodecAAC
+	}
+	return CodecMP3
+}
+
+func (h *hlsOutput) segment(seq uint64) (*hlsSegment, bool) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for _, seg := range h.segments {
+		if seg.seq == seq {
+			return seg, true
+		}
+	}
+	return nil, false
+}
+
+func (h *hlsOutput) playlist(sid string) (string, bool) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	segs := h.segments
+	discSeq := h.discSeq
+	if len(segs) > h.window {
+		discSeq += uint64(countDiscontinuities(segs[:len(segs)-h.window]))
+		segs = segs[len(segs)-h.window:]
+	}
+	if len(segs) == 0 {
+		return "", false
+	}
+	target := h.target
+	for _, seg := range segs {
+		if seg.duration > target {
+			target = seg.duration
+		}
+	}
+	var b strings.Builder
+	b.WriteString("#EXTM3U\n#EXT-X-VERSION:3\n")
+	fmt.Fprintf(&b, "#EXT-X-TARGETDURATION:%d\n", int(math.Ceil(target.Seconds())))
+	fmt.Fprintf(&b, "#EXT-X-MEDIA-SEQUENCE:%d\n", segs[0].seq)
+	if discSeq > 0 {
+		fmt.Fprintf(&b, "#EXT-X-DISCONTINUITY-SEQUENCE:%d\n", discSeq)
+	}
+	for i, seg := range segs {
+		if seg.discontinuity && i > 0 {
+			b.WriteString("#EXT-X-DISCONTINUITY\n")
+		}
+		fmt.Fprintf(&b, "#EXT-X-PROGRAM-DATE-TIME:%s\n", seg.started.UTC().Format("2006-01-02T15:04:05.000Z"))
+		fmt.Fprintf(&b, "#EXTINF:%.3f,\n", seg.duration.Seconds())
+		fmt.Fprintf(&b, "%d.%s?sid=%s\n", seg.seq, hlsExt(seg.codec), sid)
+	}
+	if h.ended {
+		b.WriteString("#EXT-X-ENDLIST\n")
+	}
+	return b.String(), true
+}
+
+// touch records a segment fetch for sid, creating the listener on first fetch
+func (h *hlsOutput) touch(s *Studio, sid string, r *http.Request, bytes int) {
+	now := time.Now()
+	h.sessMu.Lock()
+	sess, ok := h.sessions[sid]
+	if !ok {
+		userAgent := r.Header.Get("User-Agent")
+		l := &listeners.Listener{
+			ID:          sid,
+			StudioId:    s.ID,
+			Mount:       hlsMount,
+			RemoteIP:    netutil.ExtractClientIp(r),
+			UserAgent:   userAgent,
+			ClientType:  netutil.ClassifyUserAgent(userAgent),
+			ConnectedAt: now,
+		}
+		l.LastHeartbeat.Store(&now)
+		sess = &hlsSession{l: l}
+		h.sessions[sid] = sess
+		s.listenersStore.Add(l)
+		go s.geoResolver.Enrich(l)
+		log.Printf("Studio %s: new HLS listener %s", s.ID, sid)
+	}
+	sess.lastSeen = now
+	h.sessMu.Unlock()
+	sess.l.ByteSent.Add(int64(bytes))
+	sess.l.LastHeartbeat.Store(&now)
+}
+
+// reap ends sessions that stopped fetching segments
+func (h *hlsOutput) reap(s *Studio) {
+	idle := 3 * h.target
+	now := time.Now()
+	h.sessMu.Lock()
+	defer h.sessMu.Unlock()
+	for sid, sess := range h.sessions {
+		if now.Sub(sess.lastSeen) > idle {
+			sess.l.MarkDisconnected()
+			s.listenersStore.Remove(sid)
+			delete(h.sessions, sid)
+			log.Printf("Studio %s: HLS listener %s ended", s.ID, sid)
+		}
+	}
+}
+
+func (s *Studio) hlsReapLoop() {
+	t := time.NewTicker(s.hls.target)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			s.hls.reap(s)
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// HandleHLS serves the master playlist, media playlist and segments
+func (s *Studio) HandleHLS(w http.ResponseWriter, r *http.Request, rest []string) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	if len(rest) != 1 {
+		netutil.ServerResponse(w, 404, "Unknown HLS resource", nil)
+		return
+	}
+	// sid ends up in playlists and as the listener ID: only accept the UUIDs we hand out
+	sid := uuid.NewString()
+	if u, err := uuid.Parse(r.URL.Query().Get("sid")); err == nil {
+		sid = u.String()
+	}
+	switch name := rest[0]; {
+	case name == "index.m3u8":
+		w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+		w.Header().Set("Cache-Control", "no-cache")
+		codecs := "mp4a.40.34"
+		if s.hls.currentCodec() == CodecAAC {
+			codecs = "mp4a.40.2"
+		}
+		fmt.Fprintf(w, "#EXTM3U\n#EXT-X-STREAM-INF:BANDWIDTH=%d,CODECS=\"%s\"\nlive.m3u8?sid=%s\n", s.bitrateKbps*1000, codecs, sid)
+	case name == "live.m3u8":
+		pl, ok := s.hls.playlist(sid)
+		if !ok {
+			w.Header().Set("Retry-After", "2")
+			netutil.ServerResponse(w, 503, "Stream not ready", nil)
+			return
+		}
+		w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+		w.Header().Set("Cache-Control", "no-cache")
+		_, _ = w.Write([]byte(pl))
+	case strings.HasSuffix(name, ".mp3") || strings.HasSuffix(name, ".aac"):
+		num, ext, _ := strings.Cut(name, ".")
+		seq, err := strconv.ParseUint(num, 10, 64)
+		if err != nil {
+			netutil.ServerResponse(w, 404, "Unknown segment", nil)
+			return
+		}
+		seg, ok := s.hls.segment(seq)
+		if !ok || hlsExt(seg.codec) != ext {
+			netutil.ServerResponse(w, 404, "Segment expired", nil)
+			return
+		}
+		w.Header().Set("Content-Type", seg.codec.ContentType())
+		w.Header().Set("Content-Length", strconv.Itoa(len(seg.data)))
+		w.Header().Set("Cache-Control", "max-age=60")
+		if _, err := w.Write(seg.data); err == nil {
+			s.hls.touch(s, sid, r, len(seg.data))
+		}
+	default:
+		netutil.ServerResponse(w, 404, "Unknown HLS resource", nil)
+	}
+}
+
+// hlsID3 builds the ID3v2.4 tag that starts every packed-audio segment: the
+// transport stream timestamp required by HLS plus the now-playing title (timed metadata).
+func hlsID3(pts uint64, title string) []byte {
+	ts := make([]byte, 8)
+	binary.BigEndian.PutUint64(ts, pts&(1<<33-1))
+	frames := id3v24Frame("PRIV", append([]byte("com.apple.streaming.transportStreamTimestamp\x00"), ts...))
+	if title != "" {
+		frames = append(frames, id3v24Frame("TIT2", append([]byte{3}, title...))...)
+	}
+	hdr := []byte{'I', 'D', '3', 4, 0, 0}
+	hdr = append(hdr, syncsafe(len(frames))...)
+	return append(hdr, frames...)
+}
+
+func id3v24Frame(id string, body []byte) []byte {
+	out := append([]byte(id), syncsafe(len(body))...)
+	out = append(out, 0, 0)
+	return append(out, body...)
+}
+
+func syncsafe(n int) []byte {
+	return []byte{byte(n>>21) & 0x7F, byte(n>>14) & 0x7F, byte(n>>7) & 0x7F, byte(n) & 0x7F}
+}
@@ -0,0 +1,179 @@
+package stream
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+const hlsTestSegment = 100 * time.Millisecond // four 44.1 kHz MP3 frames
+
+func testHLS(window int) *hlsOutput {
+	h := newHLSOutput()
+	h.target, h.window = hlsTestSegment, window
+	return h
+}
+
+func constTitle(s string) func() string { return func() string { return s } }
+
+// hlsPTS reads the transport stream timestamp from a segment's ID3 tag
+func hlsPTS(t *testing.T, seg []byte) uint64 {
+	t.Helper()
+	owner := []byte("com.apple.streaming.transportStreamTimestamp\x00")
+	i := bytes.Index(seg, owner)
+	if !bytes.HasPrefix(seg, []byte("ID3\x04")) || i < 0 {
+		t.Fatal("segment does not start with a timestamp tag")
+	}
+	return binary.BigEndian.Uint64(seg[i+len(owner):])
+}
+
+func TestHLSSegmentCuts(t *testing.T) {
+	h := testHLS(3)
+	frames := testMP3Frames(testMP3Header, 9)
+	fs := len(frames) / 9
+	h.add(CodecMP3, frames, constTitle("Artist - Song"))
+	if len(h.segments) != 2 {
+		t.Fatalf("%d segments after 9 frames, want 2", len(h.segments))
+	}
+	seg := h.segments[1]
+	if seg.seq != 1 || seg.codec != CodecMP3 || seg.duration != 4*(1152*time.Second/44100) {
+		t.Fatalf("segment = seq %d, %s, %v", seg.seq, seg.codec, seg.duration)
+	}
+	tag, ok := bytes.CutSuffix(seg.data, frames[4*fs:8*fs])
+	if !ok {
+		t.Fatal("segment does not end with frames 4 to 7")
+	}
+	if !bytes.Contains(tag, []byte("TIT2\x00\x00\x00\x0e\x00\x00\x03Artist - Song")) {
+		t.Fatal("segment tag has no title")
+	}
+	// timestamps follow the samples segmented, at 90 kHz
+	if got := hlsPTS(t, h.segments[0].data); got != 0 {
+		t.Fatalf("first PTS = %d", got)
+	}
+	if got := hlsPTS(t, seg.data); got != 4*1152*90000/44100 {
+		t.Fatalf("second PTS = %d", got)
+	}
+	// the ninth frame waits for the next cut
+	if h.curDur != 1152*time.Second/44100 {
+		t.Fatalf("pending %v", h.curDur)
+	}
+}
+
+func TestHLSPlaylistWindow(t *testing.T) {
+	h := testHLS(3)
+	if _, ok := h.playlist("sid"); ok {
+		t.Fatal("playlist before the first segment")
+	}
+	h.add(CodecMP3, testMP3Frames(testMP3Header, 40), constTitle(""))
+	if len(h.segments) != 5 {
+		t.Fatalf("%d segments kept, want the window and two more", len(h.segments))
+	}
+	pl, _ := h.playlist("sid")
+	for _, want := range []string{"#EXT-X-TARGETDURATION:1\n", "#EXT-X-MEDIA-SEQUENCE:7\n", "#EXTINF:0.104,\n7.mp3?sid=sid\n", "9.mp3?sid=sid\n"} {
+		if !strings.Contains(pl, want) {
+			t.Fatalf("playlist lacks %q:\n%s", want, pl)
+		}
+	}
+	if n := strings.Count(pl, "#EXTINF"); n != 3 || strings.Contains(pl, "ENDLIST") {
+		t.Fatalf("playlist has %d segments:\n%s", n, pl)
+	}
+	// the segments behind the window can still be fetched
+	if _, ok := h.segment(5); !ok {
+		t.Fatal("segment 5 dropped")
+	}
+	if _, ok := h.segment(4); ok {
+		t.Fatal("segment 4 kept")
+	}
+}
+
+func TestHLSCodecChanges(t *testing.T) {
+	h := testHLS(5)
+	h.add(CodecMP3, testMP3Frames(testMP3Header, 6), constTitle(""))
+	// AAC cuts the pending MP3 and starts a discontinuity
+	h.add(CodecAAC, testADTSFrames(3, 200, 5), constTitle(""))
+	pl, _ := h.playlist("sid")
+	want := "0.mp3?sid=sid\n#EXT-X-PROGRAM-DATE-TIME:"
+	if !strings.Contains(pl, want) || !regexp.MustCompile(`1\.mp3\?sid=sid\n#EXT-X-DISCONTINUITY\n.*\n#EXTINF:0\.107,\n2\.aac\?sid=sid\n`).MatchString(pl) {
+		t.Fatalf("playlist:\n%s", pl)
+	}
+	if got := hlsPTS(t, h.segments[2].data); got != 6*1152*90000/44100 {
+		t.Fatalf("AAC PTS = %d", got)
+	}
+	if h.currentCodec() != CodecAAC {
+		t.Fatalf("codec = %s", h.currentCodec())
+	}
+
+	// Ogg ends the playlist
+	h.add(CodecAAC, testADTSFrames(3, 200, 2), constTitle(""))
+	h.end()
+	pl, _ = h.playlist("sid")
+	if !strings.HasSuffix(pl, "3.aac?sid=sid\n#EXT-X-ENDLIST\n") {
+		t.Fatalf("ended playlist:\n%s", pl)
+	}
+	// and MP3 starts a fresh one
+	h.add(CodecMP3, testMP3Frames(testMP3Header, 4), constTitle(""))
+	pl, _ = h.playlist("sid")
+	if strings.Contains(pl, "ENDLIST") || strings.Contains(pl, "DISCONTINUITY\n") || !strings.Contains(pl, "#EXT-X-MEDIA-SEQUENCE:4\n") ||
+		!strings.Contains(pl, "#EXT-X-DISCONTINUITY-SEQUENCE:1\n") {
+		t.Fatalf("resumed playlist:\n%s", pl)
+	}
+}
+
+func hlsGet(s *Studio, path string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/studio/test/hls/"+path, nil)
+	name, _, _ := strings.Cut(path, "?")
+	s.HandleHLS(rec, r, []string{name})
+	return rec
+}
+
+func TestHLSSessions(t *testing.T) {
+	s := newTestStudio(t, WithHLS(hlsTestSegment, 3))
+	rec := hlsGet(s, "index.m3u8")
+	sid := regexp.MustCompile(`live\.m3u8\?sid=([0-9a-f-]{36})`).FindStringSubmatch(rec.Body.String())
+	if sid == nil || !strings.Contains(rec.Body.String(), `CODECS="mp4a.40.34"`) {
+		t.Fatalf("master playlist:\n%s", rec.Body)
+	}
+	if rec := hlsGet(s, "live.m3u8?sid="+sid[1]); rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("playlist without audio answered %d", rec.Code)
+	}
+
+	s.push(sourceAutoDJ, testMP3Frames(testMP3Header, 8))
+	waitFor(t, "two segments", func() bool {
+		_, ok := s.hls.segment(1)
+		return ok
+	})
+	if rec := hlsGet(s, "1.aac?sid="+sid[1]); rec.Code != http.StatusNotFound {
+		t.Fatalf("segment with the wrong extension answered %d", rec.Code)
+	}
+	rec = hlsGet(s, "1.mp3?sid="+sid[1])
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "audio/mpeg" {
+		t.Fatalf("segment answered %d, %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	l, ok := s.listenersStore.Get(sid[1])
+	if !ok || l.Mount != hlsMount || l.ByteSent.Load() != int64(rec.Body.Len()) {
+		t.Fatalf("HLS listener = %+v", l)
+	}
+	s.buildSnapshot()
+	if got := s.Snapshot().Mounts; got[hlsMount] != 1 || got[""] != 0 {
+		t.Fatalf("mounts = %v", got)
+	}
+
+	// sessions that stop fetching segments end
+	s.hls.reap(s)
+	if _, ok := s.listenersStore.Get(sid[1]); !ok {
+		t.Fatal("active session reaped")
+	}
+	s.hls.sessMu.Lock()
+	s.hls.sessions[sid[1]].lastSeen = time.Now().Add(-4 * hlsTestSegment)
+	s.hls.sessMu.Unlock()
+	s.hls.reap(s)
+	if _, ok := s.listenersStore.Get(sid[1]); ok || l.DisconnectedAt.Load() == nil {
+		t.Fatal("idle session not reaped")
+	}
+}
@@ -0,0 +1,255 @@
+package stream
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	defaultICYMetaInt = 16000
+	maxICYMetaLen     = 255 * 16
+)
+
+// StationInfo is advertised to listeners through icy-* response headers
+type StationInfo struct {
+	Name        string
+	Genre       string
+	Description string
+	URL         string
+}
+
+// WithStationInfo sets the icy-name/genre/description/url headers for the studio
+func WithStationInfo(info StationInfo) StudioOption {
+	return func(s *Studio) { s.station = info }
+}
+
+// WithICYMetaInt sets how many audio bytes are sent between ICY metadata blocks
+func WithICYMetaInt(n int) StudioOption {
+	return func(s *Studio) {
+		if n > 0 {
+			s.icyMetaInt = n
+		}
+	}
+}
+
+// icyMeta is the title/url currently announced to ICY listeners
+type icyMeta struct {
+	Title string
+	URL   string
+}
+
+// streamMeta returns what ICY listeners should see: the live song/show or the AutoDJ track
+func (s *Studio) streamMeta() icyMeta {
+	m := icyMeta{URL: s.station.URL}
+	np := s.nowPlaying()
+	m.Title = trackDisplayTitle(Track{Title: np.Current, Artist: np.Artist})
+	if OnAirState(np.Source) == OnAirLive {
+		if lm := s.LiveMeta(); lm != nil && lm.URL != "" {
+			m.URL = lm.URL
+		}
+	}
+	return m
+}
+
+func trackDisplayTitle(t Track) string {
+	if t.Artist != "" && t.Title != "" {
+		return t.Artist + " - " + t.Title
+	}
+	return t.Title
+}
+
+// setICYHeaders writes the icy-* response headers for a listener
+func (s *Studio) setICYHeaders(h http.Header) {
+	name, genre, desc, url := s.station.Name, s.station.Genre, s.station.Description, s.station.URL
+	br := strconv.Itoa(s.bitrateKbps)
+	state, _, _ := s.OnAir()
+	if lm := s.LiveMeta(); lm != nil && state == OnAirLive {
+		if lm.Name != "" {
+			name = lm.Name
+		}
+		if lm.Genre != "" {
+			genre = lm.Genre
+		}
+		if lm.Description != "" {
+			desc = lm.Description
+		}
+		if lm.URL != "" {
+			url = lm.URL
+		}
+		if lm.Bitrate != "" {
+			br = lm.Bitrate
+		}
+	}
+	if name == "" {
+		name = s.ID
+	}
+	h.Set("icy-name", name)
+	h.Set("icy-br", br)
+	if genre != "" {
+		h.Set("icy-genre", genre)
+	}
+	if desc != "" {
+		h.Set("icy-description", desc)
+	}
+	if url != "" {
+		h.Set("icy-url", url)
+	}
+}
+
+// icyWriter inserts a metadata block after every metaInt bytes of audio.
+// The full block is only sent when the metadata changes; otherwise a zero length byte is written.
+type icyWriter struct {
+	w        io.Writer
+	metaInt  int
+	untilMet int
+	meta     func() icyMeta
+	last     string
+	sentOnce bool
+}
+
+func newICYWriter(w io.Writer, metaInt int, meta func() icyMeta) *icyWriter {
+	return &icyWriter{w: w, metaInt: metaInt, untilMet: metaInt, meta: meta}
+}
+
+func (iw *icyWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := len(p)
+		if n > iw.untilMet {
+			n = iw.untilMet
+		}
+		m, err := iw.w.Write(p[:n])
+		written += m
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+		iw.untilMet -= n
+		if iw.untilMet == 0 {
+			if _, err := iw.w.Write(iw.block()); err != nil {
+				return written, err
+			}
+			iw.untilMet = iw.metaInt
+		}
+	}
+	return written, nil
+}
+
+func (iw *icyWriter) block() []byte {
+	text := icyMetaText(iw.meta())
+	if iw.sentOnce && text == iw.last {
+		return []byte{0}
+	}
+	iw.sentOnce = true
+	iw.last = text
+	blocks := (len(text) + 15) / 16
+	out := make([]byte, 1+blocks*16)
+	out[0] = byte(blocks)
+	copy(out[1:], text)
+	return out
+}
+
+// icyMetaText formats a metadata block's text. Values, not the whole string, are cut to fit
+// maxICYMetaLen so the closing quotes survive; the title has precedence over the URL.
+func icyMetaText(m icyMeta) string {
+	title, url := icyEscape(m.Title), icyEscape(m.URL)
+	room := maxICYMetaLen - len("StreamTitle='';")
+	if url != "" {
+		room -= len("StreamUrl='';")
+	}
+	title = truncateUTF8(title, room)
+	url = truncateUTF8(url, room-len(title))
+	text := "StreamTitle='" + title + "';"
+	if url != "" {
+		text += "StreamUrl='" + url + "';"
+	}
+	return text
+}
+
+// truncateUTF8 shortens s to at most n bytes without splitting a character
+func truncateUTF8(s string, n int) string {
+	if n <= 0 {
+		return ""
+	}
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
+
+// icyEscape drops characters that would terminate the quoted value early in most parsers
+func icyEscape(s string) string {
+	return strings.NewReplacer("';", "'", "\x00", "").Replace(s)
+}
+
+// icyReader strips the metadata blocks out of an ICY stream (as received with Icy-MetaData: 1),
+// passing each block's StreamTitle to onTitle when it changes
+type icyReader struct {
+	r        io.Reader
+	metaInt  int
+	untilMet int
+	title    string
+	onTitle  func(title string)
+}
+
+func newICYReader(r io.Reader, metaInt int, onTitle func(string)) *icyReader {
+	return &icyReader{r: r, metaInt: metaInt, untilMet: metaInt, onTitle: onTitle}
+}
+
+func (ir *icyReader) Read(p []byte) (int, error) {
+	if ir.untilMet == 0 {
+		if err := ir.readBlock(); err != nil {
+			return 0, err
+		}
+		ir.untilMet = ir.metaInt
+	}
+	if len(p) > ir.untilMet {
+		p = p[:ir.untilMet]
+	}
+	n, err := ir.r.Read(p)
+	ir.untilMet -= n
+	return n, err
+}
+
+func (ir *icyReader) readBlock() error {
+	var size [1]byte
+	if _, err := io.ReadFull(ir.r, size[:]); err != nil {
+		return err
+	}
+	if size[0] == 0 {
+		return nil
+	}
+	block := make([]byte, int(size[0])*16)
+	if _, err := io.ReadFull(ir.r, block); err != nil {
+		return err
+	}
+	title, ok := icyField(strings.TrimRight(string(block), "\x00"), "StreamTitle")
+	if ok && title != ir.title {
+		ir.title = title
+		if ir.onTitle != nil {
+			ir.onTitle(title)
+		}
+	}
+	return nil
+}
+
+// icyField extracts key='value' from a metadata block's text
+func icyField(text, key string) (string, bool) {
+	i := strings.Index(text, key+"='")
+	if i < 0 {
+		return "", false
+	}
+	v := text[i+len(key)+2:]
+	if j := strings.Index(v, "';"); j >= 0 {
+		v = v[:j]
+	} else {
+		v = strings.TrimSuffix(v, "'")
+	}
+	return strings.ToValidUTF8(v, ""), true
+}
@@ -0,0 +1,82 @@
+package stream
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestICYMetaTextFits(t *testing.T) {
+	long := strings.Repeat("é", maxICYMetaLen) // 2 bytes per rune
+	tests := []struct {
+		name    string
+		meta    icyMeta
+		wantURL bool
+	}{
+		{"short", icyMeta{Title: "Artist - Song", URL: "https://example.com"}, true},
+		{"long title", icyMeta{Title: long}, false},
+		{"long title with url", icyMeta{Title: long, URL: "https://example.com"}, false},
+		{"long url", icyMeta{Title: "Song", URL: "https://example.com/" + long}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			text := icyMetaText(tt.meta)
+			if len(text) > maxICYMetaLen {
+				t.Fatalf("len = %d, max %d", len(text), maxICYMetaLen)
+			}
+			if !utf8.ValidString(text) {
+				t.Fatalf("text is not valid UTF-8")
+			}
+			if !strings.HasPrefix(text, "StreamTitle='") || !strings.HasSuffix(text, "';") {
+				t.Fatalf("malformed block %q", text[:min(len(text), 40)])
+			}
+			if got := strings.Contains(text, "StreamUrl='"); got != tt.wantURL {
+				t.Fatalf("StreamUrl present = %v, want %v", got, tt.wantURL)
+			}
+		})
+	}
+}
+
+func TestICYWriterInterval(t *testing.T) {
+	var out strings.Builder
+	w := newICYWriter(&out, 4, func() icyMeta { return icyMeta{Title: "A"} })
+	if _, err := w.Write([]byte("abcdefgh")); err != nil {
+		t.Fatal(err)
+	}
+	meta := "StreamTitle='A';"
+	block := string([]byte{1}) + meta + strings.Repeat("\x00", 16-len(meta))
+	// the title is only repeated when it changes: the second block is empty
+	want := "abcd" + block + "efgh" + "\x00"
+	if out.String() != want {
+		t.Fatalf("got %q, want %q", out.String(), want)
+	}
+}
+
+func TestICYReaderRoundTrip(t *testing.T) {
+	titles := []string{"First", "First", "Second", "Ünïcode 'quoted'"}
+	i := 0
+	var stream bytes.Buffer
+	w := newICYWriter(&stream, 7, func() icyMeta { return icyMeta{Title: titles[min(i, len(titles)-1)], URL: "https://example.com"} })
+	audio := []byte(strings.Repeat("0123456789", 5))
+	for ; i < len(titles); i++ {
+		if _, err := w.Write(audio[:14]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var got []string
+	r := newICYReader(&stream, 7, func(title string) { got = append(got, title) })
+	out, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := bytes.Repeat(audio[:14], len(titles)); !bytes.Equal(out, want) {
+		t.Fatalf("audio = %q, want %q", out, want)
+	}
+	want := []string{"First", "Second", "Ünïcode 'quoted'"}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Fatalf("titles = %q, want %q", got, want)
+	}
+}
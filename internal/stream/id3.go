@@ -0,0 +1,337 @@
+package stream
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+// ID3 tag reading (ID3v2.2/2.3/2.4 and ID3v1), enough to fill Track metadata and cover art.
+
+// CoverArt is an embedded picture (APIC / PIC frame)
+type CoverArt struct {
+	MIME        string
+	Type        byte // ID3 picture type, 3 = front cover
+	Description string
+	Data        []byte
+}
+
+// ID3Tags is the metadata found in a file
+type ID3Tags struct {
+	Title    string
+	Artist   string
+	Album    string
+	LengthMs int // TLEN, if present
+	Cover    *CoverArt
+
+	V2Size int  // bytes occupied by the ID3v2 tag at the start of the file (0 if none)
+	HasV1  bool // a 128-byte ID3v1 tag ends the file
+}
+
+var errNoID3 = errors.New("no ID3 tag")
+
+// maxID3v2Size caps how much of a tag is read into memory (cover art included)
+const maxID3v2Size = 16 << 20
+
+// ReadID3 reads ID3v2 and ID3v1 tags from path. v2 values win over v1.
+func ReadID3(path string, withCover bool) (ID3Tags, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return ID3Tags{}, err
+	}
+	defer f.Close()
+
+	var tags ID3Tags
+	v2err := readID3v2(f, &tags, withCover)
+	v1err := errNoID3
+	if st, err := f.Stat(); err == nil && st.Size() >= 128 {
+		v1err = readID3v1(f, st.Size(), &tags)
+	}
+	if v2err != nil && v1err != nil {
+		return tags, errNoID3
+	}
+	return tags, nil
+}
+
+// id3v2Size returns the total size of an ID3v2 tag starting at b (header, body and footer), or 0
+func id3v2Size(b []byte) int {
+	if len(b) < 10 || string(b[:3]) != "ID3" || b[3] == 0xFF || b[4] == 0xFF {
+		return 0
+	}
+	for _, c := range b[6:10] {
+		if c&0x80 != 0 {
+			return 0
+		}
+	}
+	size := 10 + unsyncsafe(b[6:10])
+	if b[3] == 4 && b[5]&0x10 != 0 {
+		size += 10 // footer
+	}
+	return size
+}
+
+func readID3v2(r io.ReaderAt, tags *ID3Tags, withCover bool) error {
+	hdr := make([]byte, 10)
+	if _, err := r.ReadAt(hdr, 0); err != nil {
+		return err
+	}
+	total := id3v2Size(hdr)
+	if total == 0 {
+		return errNoID3
+	}
+	tags.V2Size = total
+	bodySize := unsyncsafe(hdr[6:10])
+	if bodySize > maxID3v2Size {
+		return errors.New("ID3v2 tag too large")
+	}
+	body := make([]byte, bodySize)
+	if _, err := r.ReadAt(body, 10); err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	parseID3v2Body(hdr[3], hdr[5], body, tags, withCover)
+	return nil
+}
+
+func parseID3v2Body(version, flags byte, body []byte, tags *ID3Tags, withCover bool) {
+	// v2.2/2.3: unsynchronisation applies to the whole tag
+	if flags&0x80 != 0 && version < 4 {
+		body = removeUnsync(body)
+	}
+	if flags&0x40 != 0 && version >= 3 && len(body) >= 4 {
+		// extended header
+		var ext int
+		if version == 3 {
+			ext = int(binary.BigEndian.Uint32(body[:4])) + 4
+		} else {
+			ext = unsyncsafe(body[:4])
+		}
+		if ext > len(body) {
+			return
+		}
+		body = body[ext:]
+	}
+
+	idLen, hdrLen := 4, 10
+	if version == 2 {
+		idLen, hdrLen = 3, 6
+	}
+	for len(body) >= hdrLen {
+		id := string(body[:idLen])
+		if body[0] == 0 {
+			break // padding
+		}
+		var size int
+		var fflags uint16
+		switch version {
+		case 2:
+			size = int(body[3])<<16 | int(body[4])<<8 | int(body[5])
+		case 3:
+			size = int(binary.BigEndian.Uint32(body[4:8]))
+			fflags = binary.BigEndian.Uint16(body[8:10])
+		default:
+			size = unsyncsafe(body[4:8])
+			fflags = binary.BigEndian.Uint16(body[8:10])
+		}
+		if size <= 0 || hdrLen+size > len(body) {
+			break
+		}
+		data := body[hdrLen : hdrLen+size]
+		body = body[hdrLen+size:]
+
+		if version == 4 {
+			if fflags&0x0002 != 0 {
+				data = removeUnsync(data)
+			}
+			if fflags&0x0001 != 0 && len(data) >= 4 {
+				data = data[4:] // data length indicator
+			}
+			if fflags&0x000C != 0 {
+				continue // compressed or encrypted
+			}
+		} else if version == 3 && fflags&0x00C0 != 0 {
+			continue
+		}
+
+		switch id {
+		case "TIT2", "TT2":
+			setIfEmpty(&tags.Title, decodeID3Text(data))
+		case "TPE1", "TP1":
+			setIfEmpty(&tags.Artist, decodeID3Text(data))
+		case "TALB", "TAL":
+			setIfEmpty(&tags.Album, decodeID3Text(data))
+		case "TLEN", "TLE":
+			if ms, err := strconv.Atoi(strings.TrimSpace(decodeID3Text(data))); err == nil && tags.LengthMs == 0 {
+				tags.LengthMs = ms
+			}
+		case "APIC", "PIC":
+			if withCover {
+				if c := parseID3Picture(id, data); c != nil && (tags.Cover == nil || (tags.Cover.Type != 3 && c.Type == 3)) {
+					tags.Cover = c
+				}
+			}
+		}
+	}
+}
+
+func readID3v1(r io.ReaderAt, size int64, tags *ID3Tags) error {
+	b := make([]byte, 128)
+	if _, err := r.ReadAt(b, size-128); err != nil {
+		return err
+	}
+	if string(b[:3]) != "TAG" {
+		return errNoID3
+	}
+	tags.HasV1 = true
+	setIfEmpty(&tags.Title, latin1Field(b[3:33]))
+	setIfEmpty(&tags.Artist, latin1Field(b[33:63]))
+	setIfEmpty(&tags.Album, latin1Field(b[63:93]))
+	return nil
+}
+
+func parseID3Picture(id string, data []byte) *CoverArt {
+	if len(data) < 2 {
+		return nil
+	}
+	enc := data[0]
+	c := &CoverArt{}
+	rest := data[1:]
+	if id == "PIC" {
+		if len(rest) < 4 {
+			return nil
+		}
+		switch strings.ToUpper(string(rest[:3])) {
+		case "PNG":
+			c.MIME = "image/png"
+		default:
+			c.MIME = "image/jpeg"
+		}
+		rest = rest[3:]
+	} else {
+		i := bytes.IndexByte(rest, 0)
+		if i < 0 {
+			return nil
+		}
+		c.MIME = string(rest[:i])
+		if c.MIME == "" || !strings.Contains(c.MIME, "/") {
+			c.MIME = "image/" + strings.ToLower(strings.TrimPrefix(c.MIME, "image/"))
+		}
+		rest = rest[i+1:]
+	}
+	if len(rest) < 1 {
+		return nil
+	}
+	c.Type = rest[0]
+	rest = rest[1:]
+	desc, n := splitID3String(enc, rest)
+	c.Description = desc
+	c.Data = append([]byte(nil), rest[n:]...)
+	if len(c.Data) == 0 {
+		return nil
+	}
+	return c
+}
+
+// splitID3String decodes one terminated string and returns it with the bytes consumed
+func splitID3String(enc byte, b []byte) (string, int) {
+	if enc == 1 || enc == 2 {
+		for i := 0; i+1 < len(b); i += 2 {
+			if b[i] == 0 && b[i+1] == 0 {
+				return decodeID3Text(append([]byte{enc}, b[:i]...)), i + 2
+			}
+		}
+		return decodeID3Text(append([]byte{enc}, b...)), len(b)
+	}
+	i := bytes.IndexByte(b, 0)
+	if i < 0 {
+		return decodeID3Text(append([]byte{enc}, b...)), len(b)
+	}
+	return decodeID3Text(append([]byte{enc}, b[:i]...)), i + 1
+}
+
+// decodeID3Text decodes a text frame (first byte = encoding); multiple values are joined with "/"
+func decodeID3Text(data []byte) string {
+	if len(data) == 0 {
+		return ""
+	}
+	enc, b := data[0], data[1:]
+	var s string
+	switch enc {
+	case 0:
+		s = latin1(b)
+	case 1, 2:
+		s = decodeUTF16(b, enc == 2)
+	default:
+		s = string(b)
+	}
+	parts := strings.Split(strings.TrimRight(s, "\x00"), "\x00")
+	for i := range parts {
+		parts[i] = strings.TrimSpace(parts[i])
+	}
+	return strings.Join(parts, "/")
+}
+
+// decodeUTF16 handles UTF-16 with BOM (encoding 1) and UTF-16BE (encoding 2);
+// each null-separated value may carry its own BOM.
+func decodeUTF16(b []byte, bigEndian bool) string {
+	var out []uint16
+	be := bigEndian
+	for i := 0; i+1 < len(b); i += 2 {
+		u := uint16(b[i])<<8 | uint16(b[i+1])
+		if !be {
+			u = uint16(b[i+1])<<8 | uint16(b[i])
+		}
+		switch {
+		case u == 0xFEFF:
+			continue
+		case u == 0xFFFE:
+			be = !be
+			continue
+		}
+		out = append(out, u)
+		if u == 0 && !bigEndian {
+			be = false // next value starts with its own BOM
+		}
+	}
+	return string(utf16.Decode(out))
+}
+
+func latin1(b []byte) string {
+	runes := make([]rune, len(b))
+	for i, c := range b {
+		runes[i] = rune(c)
+	}
+	return string(runes)
+}
+
+func latin1Field(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	return strings.TrimSpace(latin1(b))
+}
+
+func removeUnsync(b []byte) []byte {
+	out := make([]byte, 0, len(b))
+	for i := 0; i < len(b); i++ {
+		out = append(out, b[i])
+		if b[i] == 0xFF && i+1 < len(b) && b[i+1] == 0x00 {
+			i++
+		}
+	}
+	return out
+}
+
+func unsyncsafe(b []byte) int {
+	return int(b[0]&0x7F)<<21 | int(b[1]&0x7F)<<14 | int(b[2]&0x7F)<<7 | int(b[3]&0x7F)
+}
+
+func setIfEmpty(dst *string, v string) {
+	if *dst == "" && v != "" {
+		*dst = v
+	}
+}
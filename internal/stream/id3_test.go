@@ -0,0 +1,212 @@
+package stream
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// id3Frame builds one ID3v2 frame in the layout of the given major version
+func id3Frame(version byte, id string, flags uint16, data []byte) []byte {
+	var b []byte
+	switch version {
+	case 2:
+		n := len(data)
+		b = append([]byte(id), byte(n>>16), byte(n>>8), byte(n))
+	case 3:
+		b = binary.BigEndian.AppendUint32([]byte(id), uint32(len(data)))
+		b = binary.BigEndian.AppendUint16(b, flags)
+	default:
+		b = append([]byte(id), syncsafe(len(data))...)
+		b = binary.BigEndian.AppendUint16(b, flags)
+	}
+	return append(b, data...)
+}
+
+func id3Tag(version, flags byte, frames ...[]byte) []byte {
+	body := bytes.Join(frames, nil)
+	body = append(body, make([]byte, 16)...) // padding
+	tag := append([]byte{'I', 'D', '3', version, 0, flags}, syncsafe(len(body))...)
+	return append(tag, body...)
+}
+
+func id3v1Tag(title, artist, album string) []byte {
+	b := make([]byte, 128)
+	copy(b, "TAG")
+	copy(b[3:33], title)
+	copy(b[33:63], artist)
+	copy(b[63:93], album)
+	return b
+}
+
+func latin1Text(s string) []byte { return append([]byte{0}, s...) }
+func utf8Text(s string) []byte   { return append([]byte{3}, s...) }
+
+// utf16Text encodes values as UTF-16 with a BOM per value (encoding 1) or UTF-16BE (encoding 2)
+func utf16Text(enc byte, littleEndian bool, values ...string) []byte {
+	out := []byte{enc}
+	for i, v := range values {
+		if i > 0 {
+			out = append(out, 0, 0)
+		}
+		if enc == 1 {
+			if littleEndian {
+				out = append(out, 0xFF, 0xFE)
+			} else {
+				out = append(out, 0xFE, 0xFF)
+			}
+		}
+		for _, r := range v {
+			if littleEndian {
+				out = append(out, byte(r), byte(r>>8))
+			} else {
+				out = append(out, byte(r>>8), byte(r))
+			}
+		}
+	}
+	return out
+}
+
+func writeTagFile(t *testing.T, parts ...[]byte) string {
+	t.Helper()
+	p := filepath.Join(t.TempDir(), "track.mp3")
+	if err := os.WriteFile(p, bytes.Join(parts, nil), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+var testCover = []byte{0x89, 'P', 'N', 'G', 0xFF, 0xE0, 0xFF, 0x00, 1, 2, 3}
+
+func TestReadID3(t *testing.T) {
+	audio := testMP3Frames(testMP3Header, 2)
+	apic := append(append([]byte{0}, "image/png\x00"...), append([]byte{3}, append([]byte("front\x00"), testCover...)...)...)
+
+	// v2.3 extended header: size (excluding itself) + flags + padding size
+	extV3 := []byte{0, 0, 0, 6, 0, 0, 0, 0, 0, 0}
+	// v2.4 extended header: syncsafe size including itself, 1 flag byte count, flags
+	extV4 := []byte{0, 0, 0, 6, 1, 0}
+
+	// v2.4 frame with unsynchronisation and a data length indicator
+	v4Title := utf8Text("Ünsync\xff")
+	v4Data := append(syncsafe(len(v4Title)), applyUnsync(v4Title)...)
+
+	tests := []struct {
+		name      string
+		file      [][]byte
+		withCover bool
+		want      ID3Tags
+		wantCover bool
+	}{
+		{
+			name: "v2.2 latin1 with PIC",
+			file: [][]byte{id3Tag(2, 0,
+				id3Frame(2, "TT2", 0, latin1Text("Caf\xe9")),
+				id3Frame(2, "TP1", 0, latin1Text("Artist")),
+				id3Frame(2, "TAL", 0, latin1Text("Album")),
+				id3Frame(2, "PIC", 0, append([]byte{0, 'P', 'N', 'G', 3, 0}, testCover...)),
+			), audio},
+			withCover: true,
+			want:      ID3Tags{Title: "Café", Artist: "Artist", Album: "Album"},
+			wantCover: true,
+		},
+		{
+			name: "v2.3 UTF-16 with BOMs, TLEN and extended header",
+			file: [][]byte{id3Tag(3, 0x40, extV3,
+				id3Frame(3, "TIT2", 0, utf16Text(1, true, "Héllo")),
+				id3Frame(3, "TPE1", 0, utf16Text(1, false, "Big", "Endian")),
+				id3Frame(3, "TALB", 0, utf16Text(2, false, "No BOM")),
+				id3Frame(3, "TLEN", 0, latin1Text("215000")),
+			), audio},
+			want: ID3Tags{Title: "Héllo", Artist: "Big/Endian", Album: "No BOM", LengthMs: 215000},
+		},
+		{
+			name: "v2.3 whole-tag unsync with APIC",
+			file: [][]byte{applyUnsync(id3Tag(3, 0x80,
+				id3Frame(3, "TIT2", 0, latin1Text("Synced")),
+				id3Frame(3, "APIC", 0, apic),
+			)), audio},
+			withCover: true,
+			want:      ID3Tags{Title: "Synced"},
+			wantCover: true,
+		},
+		{
+			name: "v2.3 cover skipped unless asked",
+			file: [][]byte{id3Tag(3, 0,
+				id3Frame(3, "TIT2", 0, latin1Text("No art")),
+				id3Frame(3, "APIC", 0, apic),
+			), audio},
+			want: ID3Tags{Title: "No art"},
+		},
+		{
+			name: "v2.4 UTF-8 multi-value, frame unsync and data length indicator",
+			file: [][]byte{id3Tag(4, 0x40, extV4,
+				id3Frame(4, "TIT2", 0x0003, v4Data),
+				id3Frame(4, "TPE1", 0, utf8Text("One\x00Two")),
+				id3Frame(4, "TALB", 0x0008, utf8Text("compressed: ignored")),
+			), audio},
+			want: ID3Tags{Title: "Ünsync\xff", Artist: "One/Two"},
+		},
+		{
+			name: "v2 wins over v1, v1 fills the gaps",
+			file: [][]byte{id3Tag(3, 0,
+				id3Frame(3, "TIT2", 0, latin1Text("From v2")),
+			), audio, id3v1Tag("From v1", "V1 Artist", "V1 Album")},
+			want: ID3Tags{Title: "From v2", Artist: "V1 Artist", Album: "V1 Album", HasV1: true},
+		},
+		{
+			name: "v1 only",
+			file: [][]byte{audio, id3v1Tag("Old", "School", "")},
+			want: ID3Tags{Title: "Old", Artist: "School", HasV1: true},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ReadID3(writeTagFile(t, tt.file...), tt.withCover)
+			if err != nil {
+				t.Fatalf("ReadID3: %v", err)
+			}
+			if got.Title != tt.want.Title || got.Artist != tt.want.Artist || got.Album != tt.want.Album ||
+				got.LengthMs != tt.want.LengthMs || got.HasV1 != tt.want.HasV1 {
+				t.Fatalf("got %+v\nwant %+v", got, tt.want)
+			}
+			if (got.V2Size > 0) != bytes.HasPrefix(tt.file[0], []byte("ID3")) {
+				t.Fatalf("V2Size = %d", got.V2Size)
+			}
+			if (got.Cover != nil) != tt.wantCover {
+				t.Fatalf("cover present = %v, want %v", got.Cover != nil, tt.wantCover)
+			}
+			if got.Cover != nil && (got.Cover.MIME != "image/png" || got.Cover.Type != 3 || !bytes.Equal(got.Cover.Data, testCover)) {
+				t.Fatalf("cover = %q type %d, %d bytes", got.Cover.MIME, got.Cover.Type, len(got.Cover.Data))
+			}
+		})
+	}
+}
+
+func TestReadID3NoTags(t *testing.T) {
+	if _, err := ReadID3(writeTagFile(t, testMP3Frames(testMP3Header, 2)), false); err != errNoID3 {
+		t.Fatalf("err = %v, want errNoID3", err)
+	}
+}
+
+// applyUnsync applies ID3 unsynchronisation (a 0x00 after every 0xFF) to the tag body,
+// leaving a 10-byte tag header untouched when present
+func applyUnsync(b []byte) []byte {
+	start := 0
+	if bytes.HasPrefix(b, []byte("ID3")) {
+		start = 10
+	}
+	out := append([]byte{}, b[:start]...)
+	for _, c := range b[start:] {
+		out = append(out, c)
+		if c == 0xFF {
+			out = append(out, 0)
+		}
+	}
+	if start == 10 {
+		copy(out[6:10], syncsafe(len(out)-10))
+	}
+	return out
+}
@@ -0,0 +1,113 @@
+package stream
+
+import (
+	"encoding/binary"
+	"log"
+	"time"
+)
+
+// Keepalive: when nothing has produced audio for keepaliveAfter (no live source, and an AutoDJ
+// with nothing to play), the studio plays silent frames in real time so that listeners' players
+// don't time out. The studio is off air (OnAirSilence) for as long as it does.
+// The silence is MP3: when the output is AAC or Ogg nothing is sent (listeners' players time out)
+// and the studio is off air as OnAirOff.
+
+const (
+	sourceKeepalive = "silence"
+	sourceOffAir    = "off-air"
+
+	keepaliveAfter = 2 * time.Second
+	keepaliveTick  = 100 * time.Millisecond
+)
+
+// default keepalive format: MPEG-1 layer III, 44.1 kHz, joint stereo (bitrate index set per studio)
+var keepaliveHeader = [4]byte{0xFF, 0xFB, 0x00, 0x64}
+
+// keepaliveState is owned by the keepalive goroutine
+type keepaliveState struct {
+	active bool
+	start  time.Time
+	sent   int // frames since start
+	frame  []byte
+}
+
+// keepaliveFrame builds the silent frame: the format of the last audio played (so players don't
+// have to reinitialise) at the studio bitrate, when that format has it
+func keepaliveFrame(last [4]byte, kbps int) []byte {
+	hdr := last
+	if _, ok := parseMP3Header(hdr[:]); !ok {
+		hdr = keepaliveHeader
+	}
+	table := 0
+	if (hdr[1]>>3)&3 != mpeg1 {
+		table = 1
+	}
+	layerBits := (hdr[1] >> 1) & 3
+	for i, br := range mp3Bitrates[table][layerBits] {
+		if br == kbps && br > 0 {
+			hdr[2] = hdr[2]&0x0F | byte(i)<<4
+		}
+	}
+	if hdr[2]>>4 == 0 {
+		hdr[2] |= 9 << 4 // 128 kbps for MPEG-1 layer III
+	}
+	return silentMP3Frame(hdr[:])
+}
+
+func (s *Studio) keepaliveLoop() {
+	t := time.NewTicker(keepaliveTick)
+	defer t.Stop()
+	var k keepaliveState
+	for {
+		select {
+		case now := <-t.C:
+			s.keepaliveStep(&k, now)
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// keepaliveStep sends the silent frames due at now, starting and stopping with the other sources
+func (s *Studio) keepaliveStep(k *keepaliveState, now time.Time) {
+	idle := now.Sub(time.Unix(0, s.lastAudio.Load())) >= keepaliveAfter
+	if !idle {
+		if k.active {
+			k.active = false
+			log.Printf("Studio %s: audio back after %s of silence", s.ID, now.Sub(k.start).Round(time.Second))
+		}
+		return
+	}
+	if !k.active {
+		k.active, k.start, k.sent = true, now, 0
+		// lastFormat is the last MP3 played, so silence is only sent while the output is MP3
+		if codec := s.ring.currentFormat().codec; codec != CodecMP3 {
+			k.frame = nil
+			s.updateOnAir(sourceOffAir)
+			log.Printf("Studio %s: off air, nothing is producing audio; no silence to send in %s", s.ID, codec)
+			return
+		}
+		k.frame = keepaliveFrame(s.lastFormat(), s.bitrateKbps)
+		log.Printf("Studio %s: off air, nothing is producing audio; sending silence", s.ID)
+	}
+	if k.frame == nil {
+		return
+	}
+	h, _ := parseMP3Header(k.frame)
+	due := int(now.Sub(k.start)/h.Duration()) + 1
+	if due <= k.sent {
+		return
+	}
+	out := make([]byte, 0, (due-k.sent)*len(k.frame))
+	for ; k.sent < due; k.sent++ {
+		out = append(out, k.frame...)
+	}
+	s.push(sourceKeepalive, out)
+}
+
+// lastFormat is the raw header of the last frame played (zero before any)
+func (s *Studio) lastFormat() [4]byte {
+	var hdr [4]byte
+	binary.BigEndian.PutUint32(hdr[:], s.lastHeader.Load())
+	return hdr
+}
@@ -0,0 +1,118 @@
+package stream
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/ivugurura/radio-studio/internal/geo"
+)
+
+func TestKeepaliveFrame(t *testing.T) {
+	tests := []struct {
+		name    string
+		last    [4]byte
+		kbps    int
+		wantHdr []byte
+	}{
+		{"default format", [4]byte{}, 128, []byte{0xFF, 0xFB, 0x90, 0x64}},
+		{"default format, studio bitrate", [4]byte{}, 64, []byte{0xFF, 0xFB, 0x50, 0x64}},
+		{"unknown bitrate falls back to 128", [4]byte{}, 100, []byte{0xFF, 0xFB, 0x90, 0x64}},
+		{"keeps the last format", [4]byte{0xFF, 0xFB, 0x94, 0xC4}, 192, []byte{0xFF, 0xFB, 0xB4, 0xC4}},
+		{"last bitrate when the studio's doesn't exist", [4]byte{0xFF, 0xFB, 0x94, 0xC4}, 100, []byte{0xFF, 0xFB, 0x94, 0xC4}},
+		{"mpeg2 table", [4]byte{0xFF, 0xF3, 0x90, 0x64}, 64, []byte{0xFF, 0xF3, 0x80, 0x64}},
+		{"crc and padding cleared", [4]byte{0xFF, 0xFA, 0x92, 0x64}, 128, []byte{0xFF, 0xFB, 0x90, 0x64}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := keepaliveFrame(tt.last, tt.kbps)
+			if !bytes.Equal(f[:4], tt.wantHdr) {
+				t.Fatalf("header = % X, want % X", f[:4], tt.wantHdr)
+			}
+			if h, ok := parseMP3Header(f); !ok || h.FrameSize != len(f) {
+				t.Fatalf("invalid frame of %d bytes", len(f))
+			}
+		})
+	}
+}
+
+func TestKeepaliveStep(t *testing.T) {
+	s := newTestStudio(t)
+	// an hour ahead, so the studio's own keepalive loop sees recent audio and stays out of the way
+	now := time.Now().Add(time.Hour)
+	var k keepaliveState
+
+	s.lastAudio.Store(now.Add(-time.Second).UnixNano())
+	s.keepaliveStep(&k, now)
+	if k.active {
+		t.Fatalf("keepalive started while audio is recent")
+	}
+
+	s.lastAudio.Store(now.Add(-keepaliveAfter).UnixNano())
+	s.keepaliveStep(&k, now)
+	s.keepaliveStep(&k, now.Add(time.Second)) // 38 frames of 26.1ms later
+	if !k.active {
+		t.Fatalf("keepalive not active after %s without audio", keepaliveAfter)
+	}
+	want := 39 * 417
+	deadline := time.Now().Add(time.Second)
+	for s.ring.buffered() < want && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if got := s.ring.buffered(); got != want {
+		t.Fatalf("ring holds %d bytes of silence, want %d", got, want)
+	}
+	if state, source, _ := s.OnAir(); state != OnAirSilence || source != sourceKeepalive {
+		t.Fatalf("on air %s from %q, want silence", state, source)
+	}
+
+	// the silence itself doesn't count as audio; another source does
+	if got := time.Unix(0, s.lastAudio.Load()); !got.Equal(now.Add(-keepaliveAfter)) {
+		t.Fatalf("keepalive frames updated the last audio time")
+	}
+	s.lastAudio.Store(now.Add(time.Second).UnixNano())
+	s.keepaliveStep(&k, now.Add(1100*time.Millisecond))
+	if k.active {
+		t.Fatalf("keepalive still active once audio is back")
+	}
+}
+
+func TestKeepaliveFollowsOutputCodec(t *testing.T) {
+	s := newTestStudio(t)
+	now := time.Now().Add(time.Hour)
+	var k keepaliveState
+
+	aac := testADTSFrames(3, 200, 3)
+	s.pushCodec(sourceAutoDJ, CodecAAC, aac)
+	waitFor(t, "the AAC frames", func() bool { return s.ring.buffered() == len(aac) })
+	s.lastAudio.Store(now.Add(-keepaliveAfter).UnixNano())
+	s.keepaliveStep(&k, now)
+	s.keepaliveStep(&k, now.Add(time.Second))
+	if !k.active || k.frame != nil {
+		t.Fatalf("keepalive active=%v with a %d byte frame, want active without frames", k.active, len(k.frame))
+	}
+	if state, source, _ := s.OnAir(); state != OnAirOff || source != sourceOffAir {
+		t.Fatalf("on air %s from %q, want off", state, source)
+	}
+	s.buildSnapshot()
+	if !s.Snapshot().OffAir {
+		t.Fatal("snapshot is not off air")
+	}
+	// MP3 silence would start a new format and disconnect the AAC listeners
+	time.Sleep(50 * time.Millisecond)
+	if got := s.ring.buffered(); got != len(aac) {
+		t.Fatalf("ring holds %d bytes, want the AAC frames only", got)
+	}
+}
+
+func TestPushAfterClose(t *testing.T) {
+	s := NewStudio("test", t.TempDir(), 128, geo.NewResolver("", "test", false), nil, time.Hour)
+	s.Close()
+	// sources and the keepalive may still push while the studio closes
+	s.push(sourceKeepalive, testMP3Frames(testMP3Header, 1))
+	select {
+	case <-s.feed:
+		t.Fatal("audio queued after Close")
+	default:
+	}
+}
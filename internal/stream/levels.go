@@ -0,0 +1,228 @@
+package stream
+
+import (
+	"math"
+	"time"
+)
+
+// Audio levels are read straight from the MP3 bitstream: the side information of each layer III
+// granule carries its global gain and the Huffman tables coding its spectrum, which bound the
+// largest spectral value. That is an estimate rather than a PCM meter, but it is cheap enough to run
+// on every source and tells programme from dead air: digital silence carries no spectral data at
+// all, and a muted mixer's noise floor sits tens of dB below any programme.
+
+const (
+	levelFloorDB = -120.0
+	levelWindow  = time.Second
+
+	defaultSilenceThresholdDB = -50.0
+	defaultSilenceTimeout     = 15 * time.Second
+)
+
+// huffMax is the largest magnitude each big_values Huffman table codes, linbits included
+// (tables 4 and 14 do not exist)
+var huffMax = [32]int{
+	0, 1, 2, 2, 0, 3, 3, 5, 5, 5, 7, 7, 7, 15, 0, 15,
+	16, 18, 22, 30, 78, 270, 1038, 8206,
+	30, 46, 78, 142, 270, 526, 2062, 8206,
+}
+
+// AudioLevels estimates the level of the last second of MP3 audio, in dBFS. No PCM is decoded:
+// both figures are worked out from each granule's gain and Huffman tables, and read a few dB above
+// a meter on the decoded audio. Other codecs are not metered.
+type AudioLevels struct {
+	PeakEstimateDB float64 `json:"peak_estimate_db"`
+	RMSEstimateDB  float64 `json:"rms_estimate_db"`
+	SilentSec      float64 `json:"silent_sec,omitempty"` // how long the peak has been below the silence threshold
+}
+
+// WithSilenceDetection takes a live source off air (to a standby or the AutoDJ) once its level
+// stays below thresholdDB for the given time, and back on air when it rises again; 0 disables it.
+// Only MP3 sources are metered: AAC and Ogg sources are never taken off air for silence.
+func WithSilenceDetection(thresholdDB float64, after time.Duration) StudioOption {
+	return func(s *Studio) {
+		s.silenceThresholdDB = thresholdDB
+		s.silenceTimeout = after
+	}
+}
+
+type sideInfoReader struct {
+	b   []byte
+	pos int
+}
+
+func (r *sideInfoReader) read(n int) int {
+	v := 0
+	for i := 0; i < n; i++ {
+		bit := 0
+		if i := r.pos >> 3; i < len(r.b) {
+			bit = int(r.b[i]>>(7-uint(r.pos&7))) & 1
+		}
+		v = v<<1 | bit
+		r.pos++
+	}
+	return v
+}
+
+// mp3FrameLevel estimates the peak amplitude (1 = full scale) and mean square of a layer III frame.
+// Each granule is bounded by its global gain and the largest value its Huffman tables can code,
+// requantized as a decoder would; scalefactors only attenuate and are ignored.
+// ok is false for frames it can't read (other layers, truncated frames).
+func mp3FrameLevel(frame []byte) (peak, meanSquare float64, ok bool) {
+	h, ok := parseMP3Header(frame)
+	if !ok || h.Layer != 3 {
+		return 0, 0, false
+	}
+	off := 4
+	if h.CRC {
+		off += 2
+	}
+	if off+h.sideInfoSize() > len(frame) {
+		return 0, 0, false
+	}
+	r := sideInfoReader{b: frame[off : off+h.sideInfoSize()]}
+	channels, granules := 2, 1
+	if h.Mono {
+		channels = 1
+	}
+	if h.Version == mpeg1 {
+		granules = 2
+		r.read(9) // main_data_begin
+		if h.Mono {
+			r.read(5)
+		} else {
+			r.read(3)
+		}
+		r.read(4 * channels) // scfsi
+	} else {
+		r.read(8)
+		r.read(channels)
+	}
+
+	n := 0
+	for gr := 0; gr < granules; gr++ {
+		for ch := 0; ch < channels; ch++ {
+			n++
+			part23 := r.read(12)
+			bigValues := min(r.read(9), 288)
+			gain := r.read(8)
+			if h.Version == mpeg1 {
+				r.read(4) // scalefac_compress
+			} else {
+				r.read(9)
+			}
+			maxVal, subblockGain := 0, 0
+			if r.read(1) == 1 { // window switching
+				blockType := r.read(2)
+				r.read(1) // mixed_block_flag
+				for i := 0; i < 2; i++ {
+					maxVal = max(maxVal, huffMax[r.read(5)])
+				}
+				sbg := 7
+				for i := 0; i < 3; i++ {
+					sbg = min(sbg, r.read(3))
+				}
+				if blockType == 2 {
+					subblockGain = sbg
+				}
+			} else {
+				for i := 0; i < 3; i++ {
+					maxVal = max(maxVal, huffMax[r.read(5)])
+				}
+				r.read(7) // region0_count, region1_count
+			}
+			if h.Version == mpeg1 {
+				r.read(1) // preflag
+			}
+			r.read(2) // scalefac_scale, count1table_select
+
+			if part23 == 0 {
+				continue // no spectral data: digital silence
+			}
+			// the count1 region codes values up to 1
+			lines := 2 * bigValues
+			if bigValues == 0 {
+				maxVal, lines = 1, min(part23, 576)
+			}
+			amp := math.Pow(float64(max(maxVal, 1)), 4.0/3) * math.Exp2(float64(gain-210)/4-2*float64(subblockGain))
+			amp = min(amp, 1)
+			peak = max(peak, amp)
+			meanSquare += amp * amp * float64(lines) / 576
+		}
+	}
+	return peak, meanSquare / float64(n), true
+}
+
+func levelDB(amp float64) float64 {
+	if amp <= 0 {
+		return levelFloorDB
+	}
+	return max(20*math.Log10(amp), levelFloorDB)
+}
+
+// levelMeter follows the level of a frame-aligned MP3 stream over windows of levelWindow of audio
+type levelMeter struct {
+	// window being measured
+	samples    int
+	frames     int
+	peak       float64
+	meanSquare float64
+
+	last       *AudioLevels
+	quietSince time.Time // zero unless the last window was below the threshold
+}
+
+// add meters frames; it returns true when a window completed and the levels were updated
+func (m *levelMeter) add(frames []byte, thresholdDB float64, now time.Time) bool {
+	done := false
+	for len(frames) >= 4 {
+		h, ok := parseMP3Header(frames)
+		if !ok || h.FrameSize > len(frames) {
+			break
+		}
+		if peak, ms, ok := mp3FrameLevel(frames[:h.FrameSize]); ok {
+			m.peak = max(m.peak, peak)
+			m.meanSquare += ms
+			m.frames++
+			m.samples += h.Samples
+			if m.samples >= int(int64(h.SampleRate)*int64(levelWindow)/int64(time.Second)) {
+				m.finish(thresholdDB, now)
+				done = true
+			}
+		}
+		frames = frames[h.FrameSize:]
+	}
+	return done
+}
+
+func (m *levelMeter) finish(thresholdDB float64, now time.Time) {
+	m.last = &AudioLevels{
+		PeakEstimateDB: math.Round(levelDB(m.peak)*10) / 10,
+		RMSEstimateDB:  math.Round(levelDB(math.Sqrt(m.meanSquare/float64(m.frames)))*10) / 10,
+	}
+	switch {
+	case m.last.PeakEstimateDB >= thresholdDB:
+		m.quietSince = time.Time{}
+	case m.quietSince.IsZero():
+		m.quietSince = now.Add(-levelWindow)
+	}
+	m.samples, m.frames, m.peak, m.meanSquare = 0, 0, 0, 0
+}
+
+// quiet reports how long the level has been below the threshold (0 while above it)
+func (m *levelMeter) quiet(now time.Time) time.Duration {
+	if m.quietSince.IsZero() {
+		return 0
+	}
+	return now.Sub(m.quietSince)
+}
+
+// levels returns the last measured levels, or nil before the first full window
+func (m *levelMeter) levels(now time.Time) *AudioLevels {
+	if m.last == nil {
+		return nil
+	}
+	l := *m.last
+	l.SilentSec = math.Round(m.quiet(now).Seconds()*10) / 10
+	return &l
+}
@@ -0,0 +1,148 @@
+package stream
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+// sideInfoWriter packs side information fields MSB first
+type sideInfoWriter struct {
+	b   []byte
+	pos int
+}
+
+func (w *sideInfoWriter) write(n, v int) {
+	for i := n - 1; i >= 0; i-- {
+		if w.pos>>3 >= len(w.b) {
+			w.b = append(w.b, 0)
+		}
+		if v>>i&1 == 1 {
+			w.b[w.pos>>3] |= 0x80 >> (w.pos & 7)
+		}
+		w.pos++
+	}
+}
+
+type testGranule struct {
+	part23, bigValues, gain int
+	tables                  [3]int
+	short                   bool
+	subblockGain            int
+}
+
+// testLevelFrame builds an MPEG-1 stereo frame (testMP3Header) whose four granule/channels all
+// carry g in their side information
+func testLevelFrame(g testGranule) []byte {
+	var w sideInfoWriter
+	w.write(9, 0) // main_data_begin
+	w.write(3, 0)
+	w.write(8, 0) // scfsi
+	for i := 0; i < 4; i++ {
+		w.write(12, g.part23)
+		w.write(9, g.bigValues)
+		w.write(8, g.gain)
+		w.write(4, 0)
+		if g.short {
+			w.write(1, 1)
+			w.write(2, 2) // short blocks
+			w.write(1, 0)
+			w.write(5, g.tables[0])
+			w.write(5, g.tables[1])
+			for j := 0; j < 3; j++ {
+				w.write(3, g.subblockGain)
+			}
+		} else {
+			w.write(1, 0)
+			for _, t := range g.tables {
+				w.write(5, t)
+			}
+			w.write(7, 0)
+		}
+		w.write(3, 0)
+	}
+	f := testMP3Frames(testMP3Header, 1)
+	copy(f[4:], w.b)
+	return f
+}
+
+func TestMP3FrameLevel(t *testing.T) {
+	tests := []struct {
+		name     string
+		frame    []byte
+		ok       bool
+		wantPeak float64 // dBFS
+		wantRMS  float64
+	}{
+		{"digital silence", testMP3Frames(testMP3Header, 1), true, levelFloorDB, levelFloorDB},
+		// 15^(4/3) * 2^((170-210)/4), all 576 lines in big_values
+		{"programme", testLevelFrame(testGranule{part23: 2000, bigValues: 288, gain: 170, tables: [3]int{13, 7, 1}}), true, -28.86, -28.86},
+		// half the lines: RMS 3 dB under the peak
+		{"half spectrum", testLevelFrame(testGranule{part23: 2000, bigValues: 144, gain: 170, tables: [3]int{13, 0, 0}}), true, -28.86, -31.87},
+		// count1 region only: values up to 1
+		{"count1 only", testLevelFrame(testGranule{part23: 576, gain: 90}), true, -180.6, -180.6},
+		// short blocks: each subblock_gain step is -12 dB
+		{"short blocks", testLevelFrame(testGranule{part23: 2000, bigValues: 288, gain: 170, tables: [3]int{13, 13}, short: true, subblockGain: 1}), true, -40.9, -40.9},
+		{"too loud is clamped", testLevelFrame(testGranule{part23: 2000, bigValues: 288, gain: 250, tables: [3]int{31, 31, 31}}), true, 0, 0},
+		{"layer II", []byte{0xFF, 0xFD, 0x90, 0x64, 0, 0, 0}, false, 0, 0},
+		{"truncated", testMP3Frames(testMP3Header, 1)[:20], false, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			peak, ms, ok := mp3FrameLevel(tt.frame)
+			if ok != tt.ok {
+				t.Fatalf("ok = %v, want %v", ok, tt.ok)
+			}
+			if !ok {
+				return
+			}
+			// levels below the floor clamp to it
+			want := func(db float64) float64 { return max(db, levelFloorDB) }
+			if got := levelDB(peak); math.Abs(got-want(tt.wantPeak)) > 0.05 {
+				t.Fatalf("peak = %.2f dB, want %.2f", got, want(tt.wantPeak))
+			}
+			if got := levelDB(math.Sqrt(ms)); math.Abs(got-want(tt.wantRMS)) > 0.05 {
+				t.Fatalf("rms = %.2f dB, want %.2f", got, want(tt.wantRMS))
+			}
+		})
+	}
+}
+
+func TestLevelMeterWindows(t *testing.T) {
+	loud := testLevelFrame(testGranule{part23: 2000, bigValues: 288, gain: 170, tables: [3]int{13, 0, 0}})
+	silent := testMP3Frames(testMP3Header, 1)
+	now := time.Now()
+
+	var m levelMeter
+	// 38 frames of 1152 samples are just under a second at 44.1 kHz
+	for i := 0; i < 38; i++ {
+		if m.add(loud, -50, now) {
+			t.Fatalf("window completed after %d frames", i+1)
+		}
+	}
+	if m.levels(now) != nil {
+		t.Fatalf("levels reported before the first window")
+	}
+	if !m.add(append(append([]byte{}, loud...), silent...), -50, now) {
+		t.Fatalf("window did not complete")
+	}
+	if l := m.levels(now); l == nil || l.PeakEstimateDB != -28.8 || l.SilentSec != 0 {
+		t.Fatalf("levels = %+v", l)
+	}
+
+	for i := 0; i < 39; i++ {
+		m.add(silent, -50, now)
+	}
+	if m.quiet(now) != levelWindow {
+		t.Fatalf("quiet = %v after one silent window", m.quiet(now))
+	}
+	if l := m.levels(now.Add(time.Second)); l.PeakEstimateDB != levelFloorDB || l.SilentSec != 2 {
+		t.Fatalf("levels = %+v", l)
+	}
+	for i := 0; i < 39; i++ {
+		m.add(loud, -50, now)
+	}
+	if m.quiet(now) != 0 {
+		t.Fatalf("still quiet after a loud window")
+	}
+}
@@ -0,0 +1,177 @@
+package stream
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ivugurura/radio-studio/internal/netutil"
+)
+
+// Listener limits: a studio can cap its listeners (all mounts together), the connections from one
+// address and the length of a session; a server-wide cap is shared between studios. A listener
+// over a cap gets a 503 with Retry-After, or is redirected to the studio's overflow stream when it
+// has one. Refusals are counted in the snapshot. HLS players are not capped.
+
+const (
+	refusedServerFull = "server_full"
+	refusedStudioFull = "studio_full"
+	refusedPerIP      = "per_ip"
+
+	defaultListenerRetryAfter = 30 * time.Second
+)
+
+// ListenerLimits caps a studio's listeners; zero values mean no limit
+type ListenerLimits struct {
+	MaxListeners int           // across all mounts, except the overflow mount
+	MaxPerIP     int           // connections from one address (by its salted hash)
+	MaxSession   time.Duration // listeners are disconnected after this long; players reconnect
+	RetryAfter   time.Duration // sent with 503s (default 30s)
+
+	// Overflow takes listeners refused for a full studio or server instead of a 503: a mount of
+	// the studio (e.g. a low bitrate one) or a URL
+	Overflow string
+}
+
+// WithListenerLimits caps the studio's listeners
+func WithListenerLimits(l ListenerLimits) StudioOption {
+	return func(s *Studio) { s.limits = l }
+}
+
+// ListenerQuota is a listener cap shared by several studios, e.g. the server-wide maximum
+type ListenerQuota struct {
+	max int64
+	n   atomic.Int64
+}
+
+// NewListenerQuota allows max listeners (0: no limit)
+func NewListenerQuota(max int) *ListenerQuota {
+	return &ListenerQuota{max: int64(max)}
+}
+
+// WithListenerQuota counts the studio's listeners against q as well as its own limits
+func WithListenerQuota(q *ListenerQuota) StudioOption {
+	return func(s *Studio) { s.quota = q }
+}
+
+func (q *ListenerQuota) acquire() bool {
+	if q == nil {
+		return true
+	}
+	if q.n.Add(1) > q.max && q.max > 0 {
+		q.n.Add(-1)
+		return false
+	}
+	return true
+}
+
+func (q *ListenerQuota) release() {
+	if q != nil {
+		q.n.Add(-1)
+	}
+}
+
+// ListenerRejections counts listeners turned away by the limits since the studio started
+type ListenerRejections struct {
+	ServerFull int64 `json:"server_full"`
+	StudioFull int64 `json:"studio_full"`
+	PerIP      int64 `json:"per_ip"`
+	Redirected int64 `json:"redirected"`       // of the above, sent to the overflow stream
+	Expired    int64 `json:"sessions_expired"` // listeners disconnected at the session limit
+}
+
+// listenerGate tracks the listeners admitted under the studio's limits
+type listenerGate struct {
+	mu    sync.Mutex
+	total int
+	perIP map[string]int
+
+	serverFull, studioFull, perIPFull, redirected, expired atomic.Int64
+}
+
+func (g *listenerGate) rejections() ListenerRejections {
+	return ListenerRejections{
+		ServerFull: g.serverFull.Load(),
+		StudioFull: g.studioFull.Load(),
+		PerIP:      g.perIPFull.Load(),
+		Redirected: g.redirected.Load(),
+		Expired:    g.expired.Load(),
+	}
+}
+
+// admitListener reserves a place for a listener of mount from ipHash; release gives it back.
+// reason says which limit refused it.
+func (s *Studio) admitListener(mount, ipHash string) (release func(), reason string) {
+	g := &s.gate
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if max := s.limits.MaxPerIP; max > 0 && g.perIP[ipHash] >= max {
+		g.perIPFull.Add(1)
+		return nil, refusedPerIP
+	}
+	counted := mount != s.limits.Overflow
+	if max := s.limits.MaxListeners; max > 0 && counted && g.total >= max {
+		g.studioFull.Add(1)
+		return nil, refusedStudioFull
+	}
+	if !s.quota.acquire() {
+		g.serverFull.Add(1)
+		return nil, refusedServerFull
+	}
+	if g.perIP == nil {
+		g.perIP = map[string]int{}
+	}
+	g.perIP[ipHash]++
+	if counted {
+		g.total++
+	}
+	return func() {
+		s.quota.release()
+		g.mu.Lock()
+		defer g.mu.Unlock()
+		if g.perIP[ipHash]--; g.perIP[ipHash] <= 0 {
+			delete(g.perIP, ipHash)
+		}
+		if counted {
+			g.total--
+		}
+	}, ""
+}
+
+// refuseListener answers a listener the limits refused: a redirect to the overflow stream when
+// the studio or server is full, a 503 otherwise
+func (s *Studio) refuseListener(w http.ResponseWriter, r *http.Request, mount, reason string) {
+	if target := s.overflowURL(mount); target != "" && reason != refusedPerIP {
+		s.gate.redirected.Add(1)
+		log.Printf("Studio %s: listener refused on %s (%s), redirected to %s", s.ID, mount, reason, target)
+		http.Redirect(w, r, target, http.StatusFound)
+		return
+	}
+	log.Printf("Studio %s: listener refused on %s (%s)", s.ID, mount, reason)
+	w.Header().Set("Retry-After", strconv.Itoa(int(s.limits.RetryAfter.Seconds())))
+	msg := "Too many listeners"
+	if reason == refusedPerIP {
+		msg = "Too many connections from your address"
+	}
+	netutil.ServerResponse(w, http.StatusServiceUnavailable, msg, nil)
+}
+
+// overflowURL is where listeners of mount go when it is full ("" for a 503): the overflow mount
+// (not from itself) or URL
+func (s *Studio) overflowURL(mount string) string {
+	o := s.limits.Overflow
+	switch {
+	case o == "" || o == mount:
+		return ""
+	case o == mainMount:
+		return "/studio/" + s.ID + "/listen"
+	case strings.Contains(o, "/"):
+		return o
+	default:
+		return "/studio/" + s.ID + "/listen/" + o
+	}
+}
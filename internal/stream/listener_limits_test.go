@@ -0,0 +1,88 @@
+package stream
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// refusal requests the main stream from addr; the limits must answer without streaming
+func refusal(s *Studio, addr string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/studio/test/listen", nil)
+	req.RemoteAddr = addr
+	s.HandleListen(rec, req)
+	return rec
+}
+
+func TestListenerLimits(t *testing.T) {
+	quota := NewListenerQuota(3)
+	s := newTestStudio(t, WithListenerQuota(quota), WithListenerLimits(ListenerLimits{MaxListeners: 2, MaxPerIP: 1, RetryAfter: 5 * time.Second}))
+	other := newTestStudio(t, WithListenerQuota(quota))
+	hash := func(ip string) string { return s.geoResolver.HashIP(net.ParseIP(ip)) }
+
+	release, _ := s.admitListener(mainMount, hash("192.0.2.1"))
+	rec := refusal(s, "192.0.2.1:1234")
+	if rec.Code != http.StatusServiceUnavailable || rec.Header().Get("Retry-After") != "5" {
+		t.Fatalf("second connection from one address: %d, Retry-After %q", rec.Code, rec.Header().Get("Retry-After"))
+	}
+	s.admitListener(mainMount, hash("192.0.2.2"))
+	if rec := refusal(s, "192.0.2.3:1234"); rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("full studio answered %d", rec.Code)
+	}
+	// the server-wide quota is shared with the other studio
+	other.admitListener(mainMount, hash("192.0.2.4"))
+	if _, reason := other.admitListener(mainMount, hash("192.0.2.5")); reason != refusedServerFull {
+		t.Fatalf("full server: %q", reason)
+	}
+	// a place frees up in the studio, but the other studio takes it on the server
+	release()
+	other.admitListener(mainMount, hash("192.0.2.5"))
+	if rec := refusal(s, "192.0.2.1:1234"); rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("full server answered %d", rec.Code)
+	}
+	want := ListenerRejections{PerIP: 1, StudioFull: 1, ServerFull: 1}
+	s.buildSnapshot()
+	if got := s.Snapshot().Rejected; got != want {
+		t.Fatalf("rejections = %+v, want %+v", got, want)
+	}
+}
+
+func TestListenerOverflow(t *testing.T) {
+	s := newTestStudio(t, WithListenerLimits(ListenerLimits{MaxListeners: 1, Overflow: "low"}))
+	s.admitListener(mainMount, "a")
+	rec := refusal(s, "192.0.2.1:1234")
+	if rec.Code != http.StatusFound || rec.Header().Get("Location") != "/studio/test/listen/low" {
+		t.Fatalf("full studio answered %d, Location %q", rec.Code, rec.Header().Get("Location"))
+	}
+	// the overflow mount is not counted against the studio's maximum
+	if _, reason := s.admitListener("low", "b"); reason != "" {
+		t.Fatalf("overflow mount refused: %s", reason)
+	}
+	if got := s.gate.rejections(); got.StudioFull != 1 || got.Redirected != 1 {
+		t.Fatalf("rejections = %+v", got)
+	}
+}
+
+func TestListenerSessionLimit(t *testing.T) {
+	s := newTestStudio(t, WithListenerLimits(ListenerLimits{MaxSession: 200 * time.Millisecond}))
+	s.push(sourceAutoDJ, testMP3Frames(testMP3Header, 3))
+	resp, _ := listen(t, s)
+	done := make(chan error, 1)
+	go func() {
+		_, err := io.Copy(io.Discard, resp.Body)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("session not ended cleanly: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("session not ended at the limit")
+	}
+	waitFor(t, "the expiry counted", func() bool { return s.gate.rejections().Expired == 1 })
+}
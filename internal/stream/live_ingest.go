@@ -1,13 +1,16 @@
 package stream
 
 import (
-	"encoding/base64"
 	"errors"
 	"io"
 	"log"
 	"net/http"
+	"os"
+	"strconv"
 	"strings"
 	"time"
+
+	"github.com/ivugurura/radio-studio/internal/netutil"
 )
 
 type LiveMeta struct {
@@ -17,12 +20,14 @@ type LiveMeta struct {
 	URL         string
 	Bitrate     string
 	Public      string
+	Source      string // authenticated principal (user:<name> or key:<id>)
 	RawHeaders  map[string]string
 	UpdatedAt   time.Time
-}
 
-// Configure per studio if you want different passwords later
-var liveSourcePassword = "Test123" // TODO: load from config / env
+	// Current song, updated through /admin/metadata
+	Title          string
+	TitleUpdatedAt time.Time
+}
 
 // Tunables for handling fragile encoders that briefly close right after connect
 var (
@@ -31,32 +36,65 @@ var (
 	liveEarlyEOFSleep     = 200 * time.Millisecond
 )
 
-// BasicAuth check for Icecast-like request
-func checkIcecastAuth(r *http.Request) error {
-	auth := r.Header.Get("Authorization")
-	if auth == "" {
-		return errors.New("missing auth")
-	}
-	parts := strings.SplitN(auth, " ", 2)
-	if len(parts) != 2 || !strings.EqualFold(parts[0], "Basic") {
-		return errors.New("invalid auth scheme")
+// sourceAuthFailure is a rejected source login; callers render it in their own protocol
+type sourceAuthFailure struct {
+	Status  int
+	Message string
+	Header  http.Header // WWW-Authenticate or Retry-After
+}
+
+// checkSource checks a source login against the studio credentials,
+// applying the per-IP failure limit.
+func (s *Studio) checkSource(r *http.Request) (string, *sourceAuthFailure) {
+	ip := s.sourceIP(r)
+	if ok, wait := s.authLimiter.allow(ip); !ok {
+		log.Printf("[live %s] auth blocked ip=%s retry_in=%s", s.ID, ip, wait.Round(time.Second))
+		h := http.Header{}
+		h.Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+		return "", &sourceAuthFailure{Status: http.StatusTooManyRequests, Message: "too many failed attempts", Header: h}
 	}
-	decoded, err := base64.StdEncoding.DecodeString(parts[1])
+	principal, err := s.auth.check(r)
 	if err != nil {
-		return errors.New("bad base64")
+		blocked := s.authLimiter.fail(ip)
+		user, _, _ := r.BasicAuth()
+		log.Printf("[live %s] auth failed ip=%s user=%q err=%v blocked=%v", s.ID, ip, user, err, blocked)
+		h := http.Header{}
+		h.Set("WWW-Authenticate", `Basic realm="source"`)
+		return "", &sourceAuthFailure{Status: http.StatusUnauthorized, Message: "unauthorized", Header: h}
 	}
-	creds := strings.SplitN(string(decoded), ":", 2)
-	if len(creds) != 2 {
-		return errors.New("invalid credential format")
+	s.authLimiter.reset(ip)
+	return principal, nil
+}
+
+// authorizeSource is checkSource for plain HTTP handlers. On failure the response has already been written.
+func (s *Studio) authorizeSource(w http.ResponseWriter, r *http.Request) (string, bool) {
+	principal, fail := s.checkSource(r)
+	if fail != nil {
+		for k, v := range fail.Header {
+			w.Header()[k] = v
+		}
+		http.Error(w, fail.Message, fail.Status)
+		return "", false
 	}
-	user, pass := creds[0], creds[1]
-	if user != "ubugorozi" {
-		return errors.New("invalid user")
+	return principal, true
+}
+
+// sourceIP keys the auth limiter. X-Forwarded-For is client-controlled, so it only counts when
+// the request came through one of the studio's trusted proxies.
+func (s *Studio) sourceIP(r *http.Request) string {
+	if ip := netutil.TrustedClientIP(r, s.trustedProxies); ip != nil {
+		return ip.String()
 	}
-	if pass != liveSourcePassword {
-		return errors.New("invalid password")
+	return r.RemoteAddr
+}
+
+// redactHeader hides credentials when headers are logged
+func redactHeader(k string, v []string) string {
+	switch strings.ToLower(k) {
+	case "authorization", "proxy-authorization", "cookie":
+		return "[redacted]"
 	}
-	return nil
+	return strings.Join(v, ", ")
 }
 
 func (s *Studio) HandleLiveIngest(w http.ResponseWriter, r *http.Request) {
@@ -70,29 +108,22 @@ func (s *Studio) HandleLiveIngest(w http.ResponseWriter, r *http.Request) {
 	log.Printf("[live %s] incoming method=%s remote=%s contentLength=%d", s.ID, r.Method, r.RemoteAddr, r.ContentLength)
 	// Debug: dump headers (could gate behind env flag later)
 	for k, v := range r.Header {
-		log.Printf("[live %s] hdr %s=%q", s.ID, k, strings.Join(v, ", "))
+		log.Printf("[live %s] hdr %s=%q", s.ID, k, redactHeader(k, v))
 	}
 
 	// Auth
-	if err := checkIcecastAuth(r); err != nil {
-		log.Printf("[live %s] auth failed: %v", s.ID, err)
-		w.Header().Set("WWW-Authenticate", `Basic realm="source"`)
-		http.Error(w, "unauthorized", http.StatusUnauthorized)
-		return
-	}
-
-	// Reject if one already active
-	if s.liveActive.Load() {
-		http.Error(w, "live source already active", http.StatusConflict)
+	principal, ok := s.authorizeSource(w, r)
+	if !ok {
 		return
 	}
 
 	// Capture metadata
 	meta := extractLiveMeta(r)
-	s.setLiveMeta(meta)
+	meta.Source = principal
 
 	var reader io.ReadCloser
 	var hijackedConn io.Closer
+	var setReadDeadline func(time.Time) error
 
 	// Some clients send Expect: 100-continue before sending body on PUT/POST
 	if r.Method != "SOURCE" && strings.EqualFold(r.Header.Get("Expect"), "100-continue") {
@@ -122,6 +153,7 @@ func (s *Studio) HandleLiveIngest(w http.ResponseWriter, r *http.Request) {
 		_ = bufRW.Flush()
 		reader = conn
 		hijackedConn = conn
+		setReadDeadline = conn.SetReadDeadline
 	} else {
 		// Regular HTTP methods (PUT/POST) streaming body
 		log.Println("=======>Excuted")
@@ -130,28 +162,73 @@ func (s *Studio) HandleLiveIngest(w http.ResponseWriter, r *http.Request) {
 			f.Flush()
 		}
 		reader = r.Body
+		// Closing the body would wait for the blocked Read; an expired deadline interrupts it
+		setReadDeadline = http.NewResponseController(w).SetReadDeadline
+	}
+
+	// Register: on air if nothing outranks it, otherwise a hot standby
+	src := &liveSource{
+		id:          s.nextLiveSource(),
+		principal:   principal,
+		priority:    s.sourcePriority(r, principal),
+		remote:      s.sourceIP(r),
+		connectedAt: time.Now().UTC(),
+		meta:        meta,
+
+		setReadDeadline: setReadDeadline,
 	}
+	// the codec comes from the Content-Type, or is sniffed from the first audio
+	src.codec, _ = codecFromContentType(r.Header.Get("Content-Type"))
+	onAir := s.addLiveSource(src)
 
-	// Mark active
-	s.liveMu.Lock()
-	s.liveIngest = reader
-	s.liveActive.Store(true)
-	s.liveMu.Unlock()
+	log.Printf("[live %s] connected: id=%s method=%s source=%s priority=%d on_air=%v name=%q bitrate=%s", s.ID, src.id, r.Method, principal, src.priority, onAir, meta.Name, meta.Bitrate)
 
-	log.Printf("[live %s] connected: method=%s name=%q bitrate=%s", s.ID, r.Method, meta.Name, meta.Bitrate)
+	grace := liveEarlyEOFGrace
+	if r.Method == http.MethodPut || r.Method == http.MethodPost {
+		grace += 10 * time.Second
+	}
+	s.readLiveSource(src, reader, grace, r.Method)
 
+	if hijackedConn != nil {
+		_ = hijackedConn.Close()
+	}
+
+	s.removeLiveSource(src)
+
+	log.Printf("[live %s] %s ended", s.ID, src.id)
+	if s.autoDJ != nil && !s.liveActive.Load() {
+		log.Printf("[live %s] AutoDJ resumed", s.ID)
+	}
+}
+
+// readLiveSource feeds a registered source's audio to the studio until it disconnects, is kicked
+// or sends nothing for liveReadTimeout. Encoders that close right after connecting get grace to
+// send their first audio.
+func (s *Studio) readLiveSource(src *liveSource, reader io.Reader, grace time.Duration, method string) {
 	buf := make([]byte, 8192)
 	graceStart := time.Now()
 	earlyEOFs := 0
 	bytesReceived := 0
 	receivedAudio := false
-	isPutLike := r.Method == http.MethodPut || r.Method == http.MethodPost
+	deadlines := true
 	for {
+		// A frozen encoder network would block Read forever; the stall watchdog has already
+		// taken the source off air, the deadline eventually drops the connection
+		if deadlines {
+			if err := src.setReadDeadline(time.Now().Add(s.liveReadTimeout)); err != nil {
+				log.Printf("[live %s] read deadline unsupported: %v", s.ID, err)
+				deadlines = false
+			}
+		}
+		if src.kicked.Load() {
+			log.Printf("[live %s] %s kicked", s.ID, src.id)
+			break
+		}
 		n, err := reader.Read(buf)
 		if n > 0 {
 			chunk := make([]byte, n)
 			copy(chunk, buf[:n])
-			s.push(chunk)
+			s.liveData(src, chunk)
 			bytesReceived += n
 			if !receivedAudio {
 				receivedAudio = true
@@ -162,17 +239,21 @@ func (s *Studio) HandleLiveIngest(w http.ResponseWriter, r *http.Request) {
 			}
 		}
 		if err != nil {
+			if src.kicked.Load() {
+				log.Printf("[live %s] %s kicked", s.ID, src.id)
+				break
+			}
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				log.Printf("[live %s] %s no data for %s, dropping connection (totalBytes=%d)", s.ID, src.id, s.liveReadTimeout, bytesReceived)
+				break
+			}
 			if (errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)) && n == 0 && !receivedAudio {
-				// Time based grace only (ignore retry cap) until maxGrace exceeded
+				// Time based grace only (ignore retry cap) until grace exceeded
 				graceElapsed := time.Since(graceStart)
-				maxGrace := liveEarlyEOFGrace
-				if isPutLike {
-					maxGrace = liveEarlyEOFGrace + 10*time.Second
-				}
-				if graceElapsed < maxGrace {
+				if graceElapsed < grace {
 					earlyEOFs++
 					if earlyEOFs%5 == 0 { // log every 5th attempt to reduce noise
-						log.Printf("[live %s] waiting for first audio (EOF attempts=%d elapsed=%s grace=%s method=%s)", s.ID, earlyEOFs, graceElapsed.Round(time.Millisecond), maxGrace, r.Method)
+						log.Printf("[live %s] waiting for first audio (EOF attempts=%d elapsed=%s grace=%s method=%s)", s.ID, earlyEOFs, graceElapsed.Round(time.Millisecond), grace, method)
 					}
 					time.Sleep(liveEarlyEOFSleep)
 					continue
@@ -180,32 +261,13 @@ func (s *Studio) HandleLiveIngest(w http.ResponseWriter, r *http.Request) {
 			}
 			// If we reached here: either audio received then read ended, or grace expired without audio
 			if !receivedAudio {
-				log.Printf("[live %s] terminating: no audio within grace (elapsed=%s attempts=%d method=%s)", s.ID, time.Since(graceStart).Round(time.Millisecond), earlyEOFs, r.Method)
+				log.Printf("[live %s] terminating: no audio within grace (elapsed=%s attempts=%d method=%s)", s.ID, time.Since(graceStart).Round(time.Millisecond), earlyEOFs, method)
 			} else {
 				log.Printf("[live %s] READ end n=%d err=%v (totalBytes=%d)", s.ID, n, err, bytesReceived)
 			}
 			break
 		}
 	}
-
-	if hijackedConn != nil {
-		_ = hijackedConn.Close()
-	}
-
-	s.liveMu.Lock()
-	if s.liveIngest != nil {
-		_ = s.liveIngest.Close()
-		s.liveIngest = nil
-	}
-	s.liveActive.Store(false)
-	s.clearLiveMeta()
-	s.liveMu.Unlock()
-
-	log.Printf("[live %s] ended", s.ID)
-	// Log AutoDJ resume after live suppression ends (if AutoDJ configured)
-	if s.autoDJ != nil {
-		log.Printf("[live %s] AutoDJ resumed", s.ID)
-	}
 }
 
 // Live metadata helpers
@@ -227,3 +289,55 @@ func extractLiveMeta(r *http.Request) LiveMeta {
 	}
 	return lm
 }
+
+// HandleStreamKeys manages the studio's stream keys.
+//
+//	GET    /studio/{id}/keys               list keys (no secrets)
+//	POST   /studio/{id}/keys?label=...&priority=N  issue a key; the secret is only returned here
+//	POST   /studio/{id}/keys/{key}/rotate  replace the secret of a key
+//	DELETE /studio/{id}/keys/{key}         revoke a key
+func (s *Studio) HandleStreamKeys(w http.ResponseWriter, r *http.Request, rest []string) {
+	switch {
+	case len(rest) == 0 || (len(rest) == 1 && rest[0] == ""):
+		switch r.Method {
+		case http.MethodGet:
+			netutil.ServerResponse(w, 200, "Success", s.auth.listKeys())
+		case http.MethodPost:
+			priority, _ := strconv.Atoi(r.URL.Query().Get("priority"))
+			k, err := s.auth.issueKey(r.URL.Query().Get("label"), priority)
+			if err != nil {
+				netutil.ServerResponse(w, 500, "Could not issue key", nil)
+				return
+			}
+			log.Printf("[live %s] stream key issued id=%s", s.ID, k.ID)
+			netutil.ServerResponse(w, 201, "Key issued", k)
+		default:
+			netutil.ServerResponse(w, 405, "Method not allowed", nil)
+		}
+	case len(rest) == 1:
+		if r.Method != http.MethodDelete {
+			netutil.ServerResponse(w, 405, "Method not allowed", nil)
+			return
+		}
+		if err := s.auth.revokeKey(rest[0]); err != nil {
+			netutil.ServerResponse(w, 404, err.Error(), nil)
+			return
+		}
+		log.Printf("[live %s] stream key revoked id=%s", s.ID, rest[0])
+		netutil.ServerResponse(w, 200, "Key revoked", nil)
+	case len(rest) == 2 && rest[1] == "rotate":
+		if r.Method != http.MethodPost {
+			netutil.ServerResponse(w, 405, "Method not allowed", nil)
+			return
+		}
+		k, err := s.auth.rotateKey(rest[0])
+		if err != nil {
+			netutil.ServerResponse(w, 404, err.Error(), nil)
+			return
+		}
+		log.Printf("[live %s] stream key rotated id=%s", s.ID, k.ID)
+		netutil.ServerResponse(w, 200, "Key rotated", k)
+	default:
+		netutil.ServerResponse(w, 404, "Unknown keys endpoint", nil)
+	}
+}
@@ -0,0 +1,151 @@
+package stream
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// HandleAdminMetadata implements Icecast's metadata update used by BUTT, Mixxx, RadioDJ etc.:
+//
+//	GET /admin/metadata?mount=/studio/{id}/live&mode=updinfo&song=Artist%20-%20Title
+//
+// The request is authenticated with the studio's source credentials.
+func (m *Manager) HandleAdminMetadata(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if mode := q.Get("mode"); mode != "updinfo" {
+		icecastResponse(w, http.StatusBadRequest, "Unsupported mode")
+		return
+	}
+	studioID := studioFromMount(q.Get("mount"))
+	studio, ok := m.GetStudio(studioID)
+	if !ok {
+		icecastResponse(w, http.StatusNotFound, "Source does not exist")
+		return
+	}
+	principal, fail := studio.checkSource(r)
+	if fail != nil {
+		for k, v := range fail.Header {
+			w.Header()[k] = v
+		}
+		icecastResponse(w, fail.Status, fail.Message)
+		return
+	}
+
+	song := q.Get("song")
+	if song == "" {
+		// Some clients send artist/title separately
+		artist, title := q.Get("artist"), q.Get("title")
+		switch {
+		case artist != "" && title != "":
+			song = artist + " - " + title
+		default:
+			song = artist + title
+		}
+	}
+	song = strings.TrimSpace(decodeMetaCharset(song, q.Get("charset")))
+
+	if err := studio.setLiveTitle(principal, song); err != nil {
+		msg := "Source is not live"
+		if errors.Is(err, errNotSourceOwner) {
+			msg = "Source is connected with other credentials"
+		}
+		icecastResponse(w, titleErrorStatus(err), msg)
+		return
+	}
+	log.Printf("[live %s] metadata updated song=%q", studio.ID, song)
+	icecastResponse(w, http.StatusOK, "Metadata update successful")
+}
+
+var (
+	errNotLive        = errors.New("source is not live")
+	errNotSourceOwner = errors.New("source is not connected with these credentials")
+)
+
+// titleErrorStatus is the HTTP status for a setLiveTitle error
+func titleErrorStatus(err error) int {
+	if errors.Is(err, errNotSourceOwner) {
+		return http.StatusForbidden
+	}
+	return http.StatusBadRequest
+}
+
+// studioFromMount maps an Icecast mount to a studio ID.
+// Accepted forms: /studio/{id}/live, /studio/{id}, /{id}, /{id}.mp3, {id}
+func studioFromMount(mount string) string {
+	mount = strings.Trim(mount, "/")
+	parts := strings.Split(mount, "/")
+	if len(parts) >= 2 && parts[0] == "studio" {
+		return parts[1]
+	}
+	id := parts[0]
+	if i := strings.LastIndexByte(id, '.'); i > 0 {
+		id = id[:i]
+	}
+	return id
+}
+
+// decodeMetaCharset converts Latin-1 titles (the Icecast default for legacy encoders) to UTF-8
+func decodeMetaCharset(s, charset string) string {
+	cs := strings.ToLower(charset)
+	if cs == "" && utf8.ValidString(s) {
+		return s
+	}
+	if cs != "" && cs != "iso-8859-1" && cs != "latin1" {
+		return s
+	}
+	runes := make([]rune, 0, len(s))
+	for i := 0; i < len(s); i++ {
+		runes = append(runes, rune(s[i]))
+	}
+	return string(runes)
+}
+
+func icecastResponse(w http.ResponseWriter, code int, msg string) {
+	ret := 0
+	if code < 400 {
+		ret = 1
+	}
+	w.Header().Set("Content-Type", "text/xml")
+	w.WriteHeader(code)
+	_, _ = fmt.Fprintf(w, "<?xml version=\"1.0\"?>\n<iceresponse><message>%s</message><return>%d</return></iceresponse>\n", msg, ret)
+}
+
+// setSourceTitle sets the song title of one source (e.g. from a relay's ICY metadata)
+func (s *Studio) setSourceTitle(src *liveSource, title string) {
+	s.liveMu.Lock()
+	defer s.liveMu.Unlock()
+	src.meta.Title = title
+	src.meta.TitleUpdatedAt = time.Now().UTC()
+	if s.liveOnAir == src {
+		s.setLiveMeta(src.meta)
+	}
+}
+
+// setLiveTitle updates the song title of the live sources logged in as principal. A login can
+// only retitle its own sources: errNotSourceOwner if none of the connected ones is principal's.
+func (s *Studio) setLiveTitle(principal, title string) error {
+	s.liveMu.Lock()
+	defer s.liveMu.Unlock()
+	if s.liveOnAir == nil {
+		return errNotLive
+	}
+	now := time.Now().UTC()
+	matched := false
+	for _, src := range s.liveSources {
+		if src.principal == principal {
+			src.meta.Title = title
+			src.meta.TitleUpdatedAt = now
+			matched = true
+		}
+	}
+	if !matched {
+		return errNotSourceOwner
+	}
+	s.setLiveMeta(s.liveOnAir.meta)
+	return nil
+}
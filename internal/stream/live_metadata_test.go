@@ -0,0 +1,113 @@
+package stream
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ivugurura/radio-studio/internal/geo"
+)
+
+func TestStudioFromMount(t *testing.T) {
+	for mount, want := range map[string]string{
+		"/studio/abc/live": "abc",
+		"/studio/abc":      "abc",
+		"studio/abc/live/": "abc",
+		"/abc":             "abc",
+		"/abc.mp3":         "abc",
+		"abc":              "abc",
+		"/.hidden":         ".hidden",
+		"":                 "",
+	} {
+		if got := studioFromMount(mount); got != want {
+			t.Errorf("%q: %q, want %q", mount, got, want)
+		}
+	}
+}
+
+func TestAdminMetadata(t *testing.T) {
+	djHash, _ := HashSourcePassword("hackme")
+	otherHash, _ := HashSourcePassword("other")
+	m := NewManager(t.TempDir(), geo.NewResolver("", "test", false))
+	s := m.RegisterStudio("test", WithSourceAccounts(
+		SourceAccount{User: "dj", PasswordHash: djHash},
+		SourceAccount{User: "guest", PasswordHash: otherHash},
+	))
+	t.Cleanup(func() { m.RemoveStudio("test") })
+
+	update := func(q url.Values, user, pass string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/admin/metadata?"+q.Encode(), nil)
+		if user != "" {
+			r.SetBasicAuth(user, pass)
+		}
+		rec := httptest.NewRecorder()
+		m.HandleAdminMetadata(rec, r)
+		return rec
+	}
+	song := func(mount, title string) url.Values {
+		return url.Values{"mount": {mount}, "mode": {"updinfo"}, "song": {title}}
+	}
+
+	if rec := update(song("/studio/test/live", "Early"), "dj", "hackme"); rec.Code != http.StatusBadRequest {
+		t.Fatalf("update before the source connects answered %d", rec.Code)
+	}
+
+	src := newTestSource(s, 1, nil)
+	src.principal = "user:dj"
+	s.addLiveSource(src)
+	s.liveData(src, testMP3Frames(testMP3Header, 3))
+	waitFor(t, "the source on air", func() bool {
+		state, _, _ := s.OnAir()
+		return state == OnAirLive
+	})
+
+	tests := []struct {
+		name       string
+		q          url.Values
+		user, pass string
+		wantCode   int
+	}{
+		{"unsupported mode", url.Values{"mount": {"/test"}, "mode": {"stats"}}, "dj", "hackme", http.StatusBadRequest},
+		{"unknown mount", song("/studio/nope/live", "X"), "dj", "hackme", http.StatusNotFound},
+		{"no credentials", song("/studio/test/live", "X"), "", "", http.StatusUnauthorized},
+		{"wrong password", song("/studio/test/live", "X"), "dj", "wrong", http.StatusUnauthorized},
+		{"another account", song("/studio/test/live", "Hijacked"), "guest", "other", http.StatusForbidden},
+		{"the source's account", song("/test.mp3", "Artist - Title"), "dj", "hackme", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := update(tt.q, tt.user, tt.pass)
+			if rec.Code != tt.wantCode {
+				t.Fatalf("answered %d, want %d: %s", rec.Code, tt.wantCode, rec.Body)
+			}
+			if !strings.Contains(rec.Body.String(), "<iceresponse>") {
+				t.Fatalf("body is not an iceresponse: %s", rec.Body)
+			}
+			if rec.Code == http.StatusUnauthorized && rec.Header().Get("WWW-Authenticate") == "" {
+				t.Fatal("401 without WWW-Authenticate")
+			}
+		})
+	}
+
+	if got := s.nowPlaying().Current; got != "Artist - Title" {
+		t.Fatalf("now playing %q", got)
+	}
+	if got := icyMetaText(s.streamMeta()); !strings.HasPrefix(got, "StreamTitle='Artist - Title';") {
+		t.Fatalf("ICY metadata %q", got)
+	}
+
+	// Latin-1 from legacy encoders, as separate artist and title
+	q := url.Values{"mount": {"/studio/test/live"}, "mode": {"updinfo"}, "artist": {"Caf\xe9"}, "title": {"Song"}}
+	if rec := update(q, "dj", "hackme"); rec.Code != http.StatusOK {
+		t.Fatalf("artist/title update answered %d", rec.Code)
+	}
+	if got := s.nowPlaying().Current; got != "Café - Song" {
+		t.Fatalf("now playing %q", got)
+	}
+	if lm := s.LiveMeta(); lm == nil || time.Since(lm.TitleUpdatedAt) > time.Minute {
+		t.Fatalf("live meta = %+v", lm)
+	}
+}
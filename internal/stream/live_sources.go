@@ -0,0 +1,483 @@
+package stream
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/ivugurura/radio-studio/internal/netutil"
+)
+
+// Live sources: a studio accepts several encoders at once. The connected source with the highest
+// priority is on air (ties go to the one connected first); the others are hot standbys that keep
+// reading and hold their most recent audio, so one can be promoted at once if the active source
+// drops. Switching goes through the distributor's handoff, so it always lands on a frame boundary.
+// An admin can pin a source on air or kick one.
+//
+// A source that sends nothing for liveStallTimeout is stalled, and one whose level stays below the
+// silence threshold for silenceTimeout is silent: either way it stays connected but loses the air
+// (to a standby, or to the AutoDJ) until audio comes back. Each one is recorded as an incident.
+// Levels are only measured on MP3, so AAC and Ogg sources can stall but are never silent.
+
+// liveStandbyBuffer is how much recent audio a standby source keeps for its promotion
+const liveStandbyBuffer = 32 * 1024
+
+const (
+	defaultLiveStallTimeout = 5 * time.Second
+	defaultLiveReadTimeout  = 60 * time.Second
+	maxLiveIncidents        = 20
+)
+
+// liveIncident kinds
+const (
+	incidentStall   = "stall"
+	incidentSilence = "silence"
+)
+
+var errLiveSourceAbsent = errors.New("live source not found")
+
+// liveSource is one connected encoder
+type liveSource struct {
+	id          string // feed source name
+	principal   string
+	priority    int
+	remote      string
+	connectedAt time.Time
+	meta        LiveMeta
+	fallback    bool  // a fallback relay: only on air while no other source is healthy
+	codec       Codec // from the Content-Type on connect, or sniffed from the first audio
+
+	// setReadDeadline bounds the connection's blocked Read (nil if unsupported)
+	setReadDeadline func(time.Time) error
+	kicked          atomic.Bool
+
+	// guarded by Studio.liveMu
+	standby  []byte
+	bytes    int64
+	lastData time.Time
+	framer   mp3Framer // frames MP3 audio for the level meter
+	level    levelMeter
+	pager    oggPager      // pages Ogg audio and keeps its headers
+	incident *liveIncident // open stall or silence; the source is off air while set
+}
+
+// kick makes the ingest handler drop the connection: its reader is unblocked and sees kicked
+func (src *liveSource) kick() {
+	src.kicked.Store(true)
+	if src.setReadDeadline != nil {
+		_ = src.setReadDeadline(time.Now())
+	}
+}
+
+// liveIncident is one stretch of time a connected source sent no audio, or only silence
+type liveIncident struct {
+	Kind      string     `json:"kind"` // stall | silence
+	Source    string     `json:"source"`
+	Principal string     `json:"principal"`
+	OnAir     bool       `json:"on_air"` // the source was on air when it stalled
+	StartedAt time.Time  `json:"started_at"`
+	EndedAt   *time.Time `json:"ended_at,omitempty"`
+	Outcome   string     `json:"outcome,omitempty"` // recovered | disconnected | stalled
+}
+
+// WithLiveTimeouts sets how long a live source may send nothing before it is taken off air
+// (stall) and before its connection is dropped (read)
+func WithLiveTimeouts(stall, read time.Duration) StudioOption {
+	return func(s *Studio) {
+		if stall > 0 {
+			s.liveStallTimeout = stall
+		}
+		if read > 0 {
+			s.liveReadTimeout = read
+		}
+	}
+}
+
+// liveSourceView is what the sources API exposes
+type liveSourceView struct {
+	ID            string       `json:"id"`
+	Principal     string       `json:"principal"`
+	Priority      int          `json:"priority"`
+	Remote        string       `json:"remote"`
+	Name          string       `json:"name,omitempty"`
+	ConnectedAt   time.Time    `json:"connected_at"`
+	OnAir         bool         `json:"on_air"`
+	Pinned        bool         `json:"pinned,omitempty"`
+	Fallback      bool         `json:"fallback,omitempty"` // a fallback relay
+	Codec         Codec        `json:"codec,omitempty"`
+	Incident      string       `json:"incident,omitempty"` // stall | silence while off air for one
+	Levels        *AudioLevels `json:"levels,omitempty"`
+	BytesReceived int64        `json:"bytes_received"`
+	Buffered      int          `json:"buffered"`
+}
+
+// sourcePriority is the priority a connecting source gets: its account's priority, lowered
+// (never raised) by ?priority= so a backup encoder can share the main encoder's credentials.
+func (s *Studio) sourcePriority(r *http.Request, principal string) int {
+	p := s.auth.priority(principal)
+	if v := r.URL.Query().Get("priority"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n < p {
+			p = n
+		}
+	}
+	return p
+}
+
+// addLiveSource registers a connected source; it goes on air if nothing is live or it outranks
+// the source on air (unless that one was pinned by an admin). Returns whether it is on air.
+func (s *Studio) addLiveSource(src *liveSource) bool {
+	s.liveMu.Lock()
+	defer s.liveMu.Unlock()
+	src.lastData = time.Now()
+	s.liveSources = append(s.liveSources, src)
+	s.reselectLocked()
+	if cur := s.liveOnAir; cur != src {
+		log.Printf("[live %s] %s (%s, priority %d) on standby behind %s (priority %d)", s.ID, src.id, src.principal, src.priority, cur.id, cur.priority)
+		return false
+	}
+	return true
+}
+
+// removeLiveSource unregisters a disconnected source, promoting the best standby if it was on air
+func (s *Studio) removeLiveSource(src *liveSource) {
+	s.liveMu.Lock()
+	defer s.liveMu.Unlock()
+	for i, o := range s.liveSources {
+		if o == src {
+			s.liveSources = append(s.liveSources[:i], s.liveSources[i+1:]...)
+			break
+		}
+	}
+	if src.incident != nil {
+		s.endIncidentLocked(src, "disconnected")
+	}
+	if s.livePinned == src {
+		s.livePinned = nil
+	}
+	if s.liveOnAir == src {
+		s.liveOnAir = nil
+		s.reselectLocked()
+	}
+}
+
+// reselectLocked puts the best healthy source on air: the pinned one, else the highest priority
+// (the source on air keeps it on a tie, then the earliest connected), fallback relays last.
+// With none, live goes off and the AutoDJ takes over.
+func (s *Studio) reselectLocked() {
+	var best *liveSource
+	if p := s.livePinned; p != nil && p.incident == nil {
+		best = p
+	} else {
+		if cur := s.liveOnAir; cur != nil && cur.incident == nil {
+			best = cur
+		}
+		for _, src := range s.liveSources {
+			if src.incident == nil && (best == nil || src.outranks(best)) {
+				best = src
+			}
+		}
+	}
+	switch {
+	case best == nil && s.liveOnAir != nil:
+		log.Printf("[live %s] no healthy source, %s off air", s.ID, s.liveOnAir.id)
+		s.liveOnAir = nil
+		s.setLive(false)
+		s.clearLiveMeta()
+	case best == nil:
+		s.setLive(false)
+		s.clearLiveMeta()
+	case best != s.liveOnAir:
+		s.activateLocked(best)
+	}
+}
+
+func (src *liveSource) outranks(o *liveSource) bool {
+	if src.fallback != o.fallback {
+		return o.fallback
+	}
+	return src.priority > o.priority
+}
+
+// checkLiveStalls marks sources that sent nothing for liveStallTimeout as stalled
+func (s *Studio) checkLiveStalls() {
+	s.liveMu.Lock()
+	defer s.liveMu.Unlock()
+	now := time.Now()
+	changed := false
+	for _, src := range s.liveSources {
+		if (src.incident != nil && src.incident.Kind == incidentStall) || now.Sub(src.lastData) < s.liveStallTimeout {
+			continue
+		}
+		if src.incident != nil {
+			s.endIncidentLocked(src, "stalled")
+		}
+		s.openIncidentLocked(src, incidentStall, src.lastData)
+		src.standby = nil
+		log.Printf("[live %s] INCIDENT %s (%s) stalled: no audio for %s (on_air=%v)", s.ID, src.id, src.principal, now.Sub(src.lastData).Round(time.Second), src.incident.OnAir)
+		changed = true
+	}
+	if changed {
+		s.reselectLocked()
+	}
+}
+
+// checkSilenceLocked opens or closes the silence incident of src after its level was measured.
+// It reports whether the source's health changed.
+func (s *Studio) checkSilenceLocked(src *liveSource, now time.Time) bool {
+	if s.silenceTimeout <= 0 {
+		return false
+	}
+	quiet := src.level.quiet(now)
+	switch {
+	case src.incident == nil && quiet >= s.silenceTimeout:
+		s.openIncidentLocked(src, incidentSilence, now.Add(-quiet))
+		log.Printf("[live %s] INCIDENT %s (%s) silent: below %.0f dBFS for %s (on_air=%v)", s.ID, src.id, src.principal, s.silenceThresholdDB, quiet.Round(time.Second), src.incident.OnAir)
+		return true
+	case src.incident != nil && src.incident.Kind == incidentSilence && quiet == 0:
+		s.endIncidentLocked(src, "recovered")
+		return true
+	}
+	return false
+}
+
+func (s *Studio) openIncidentLocked(src *liveSource, kind string, since time.Time) {
+	src.incident = &liveIncident{
+		Kind:      kind,
+		Source:    src.id,
+		Principal: src.principal,
+		OnAir:     src == s.liveOnAir,
+		StartedAt: since.UTC(),
+	}
+	s.liveIncidents = append(s.liveIncidents, src.incident)
+	if over := len(s.liveIncidents) - maxLiveIncidents; over > 0 {
+		s.liveIncidents = append(s.liveIncidents[:0:0], s.liveIncidents[over:]...)
+	}
+}
+
+// endIncidentLocked closes the open incident of src
+func (s *Studio) endIncidentLocked(src *liveSource, outcome string) {
+	now := time.Now().UTC()
+	inc := src.incident
+	src.incident = nil
+	inc.EndedAt = &now
+	inc.Outcome = outcome
+	log.Printf("[live %s] INCIDENT %s %s %s after %s", s.ID, src.id, inc.Kind, outcome, now.Sub(inc.StartedAt).Round(time.Second))
+}
+
+func (s *Studio) liveWatchLoop() {
+	t := time.NewTicker(time.Second)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			s.checkLiveStalls()
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// liveIncidentsSnapshot returns copies of the recent incidents, newest last
+func (s *Studio) liveIncidentsSnapshot() []liveIncident {
+	s.liveMu.RLock()
+	defer s.liveMu.RUnlock()
+	out := make([]liveIncident, len(s.liveIncidents))
+	for i, inc := range s.liveIncidents {
+		out[i] = *inc
+	}
+	return out
+}
+
+// liveData takes audio read from a source: on-air audio goes to the feed, standby audio is buffered
+func (s *Studio) liveData(src *liveSource, chunk []byte) {
+	s.liveMu.Lock()
+	defer s.liveMu.Unlock()
+	now := time.Now()
+	src.bytes += int64(len(chunk))
+	src.lastData = now
+	changed := false
+	if src.incident != nil && src.incident.Kind == incidentStall {
+		s.endIncidentLocked(src, "recovered")
+		changed = true
+	}
+	if src.codec == "" {
+		src.codec = sniffCodec(chunk)
+	}
+	if src.bytes == int64(len(chunk)) {
+		s.checkSourceCodecLocked(src)
+	}
+	switch src.codec {
+	case CodecOgg:
+		// only whole pages go on, so that a standby is promoted on a page boundary
+		chunk = src.pager.pushAll(chunk)
+	case CodecMP3:
+		if frames := src.framer.push(chunk); len(frames) > 0 && src.level.add(frames, s.silenceThresholdDB, now) {
+			changed = s.checkSilenceLocked(src, now) || changed
+		}
+	}
+	if changed {
+		s.reselectLocked()
+	}
+	if len(chunk) == 0 {
+		return
+	}
+	if s.liveOnAir == src {
+		s.pushCodec(src.id, src.codec, chunk)
+		return
+	}
+	src.standby = append(src.standby, chunk...)
+	if over := len(src.standby) - liveStandbyBuffer; over > 0 {
+		if src.codec == CodecOgg {
+			over = oggPageStart(src.standby, over)
+		}
+		src.standby = append(src.standby[:0], src.standby[over:]...)
+	}
+}
+
+// checkSourceCodecLocked warns, on a source's first audio, when dead air can't be detected in
+// its codec, and when it disagrees with the studio's output codec: listeners are disconnected
+// when it goes on air, and reconnect in its codec
+func (s *Studio) checkSourceCodecLocked(src *liveSource) {
+	if s.silenceTimeout > 0 && src.codec != CodecMP3 {
+		log.Printf("[live %s] %s sends %s: dead-air detection only covers MP3 sources", s.ID, src.id, src.codec)
+	}
+	out := s.ring.currentFormat().codec
+	if s.ring.buffered() == 0 || out == src.codec {
+		return
+	}
+	log.Printf("[live %s] %s sends %s but the studio plays %s; listeners will be reconnected when it goes on air", s.ID, src.id, src.codec, out)
+}
+
+// activateLocked puts src on air; the previous on-air source (if any) becomes a standby.
+// The standby audio src buffered is sent first so the switch leaves no gap.
+func (s *Studio) activateLocked(src *liveSource) {
+	prev := s.liveOnAir
+	s.liveOnAir = src
+	s.setLiveMeta(src.meta)
+	s.setLive(true)
+	// the distributor starts afresh with every source: an Ogg source's stream needs its headers again
+	if hdr := src.pager.headers; src.codec == CodecOgg && len(hdr) > 0 && !bytes.HasPrefix(src.standby, hdr) {
+		s.pushCodec(src.id, src.codec, hdr)
+	}
+	if len(src.standby) > 0 {
+		s.pushCodec(src.id, src.codec, src.standby)
+		src.standby = nil
+	}
+	if prev != nil {
+		log.Printf("[live %s] %s (priority %d) takes over from %s (priority %d)", s.ID, src.id, src.priority, prev.id, prev.priority)
+	} else {
+		log.Printf("[live %s] %s (%s, priority %d) on air", s.ID, src.id, src.principal, src.priority)
+	}
+}
+
+func (s *Studio) findLiveSourceLocked(id string) *liveSource {
+	for _, src := range s.liveSources {
+		if src.id == id {
+			return src
+		}
+	}
+	return nil
+}
+
+// forceLiveSource pins a source on air until it disconnects or another source is forced.
+// A pinned source that stalls gives the air back until it recovers.
+func (s *Studio) forceLiveSource(id string) error {
+	s.liveMu.Lock()
+	defer s.liveMu.Unlock()
+	src := s.findLiveSourceLocked(id)
+	if src == nil {
+		return errLiveSourceAbsent
+	}
+	s.livePinned = src
+	s.reselectLocked()
+	return nil
+}
+
+// kickLiveSource disconnects a source; "current" names whichever source is on air
+func (s *Studio) kickLiveSource(id string) error {
+	s.liveMu.RLock()
+	src := s.liveOnAir
+	if id != "current" {
+		src = s.findLiveSourceLocked(id)
+	}
+	s.liveMu.RUnlock()
+	if src == nil {
+		return errLiveSourceAbsent
+	}
+	log.Printf("[live %s] kicking %s (%s)", s.ID, src.id, src.principal)
+	src.kick()
+	return nil
+}
+
+func (s *Studio) listLiveSources() []liveSourceView {
+	s.liveMu.RLock()
+	defer s.liveMu.RUnlock()
+	out := make([]liveSourceView, 0, len(s.liveSources))
+	now := time.Now()
+	for _, src := range s.liveSources {
+		incident := ""
+		if src.incident != nil {
+			incident = src.incident.Kind
+		}
+		out = append(out, liveSourceView{
+			ID:            src.id,
+			Principal:     src.principal,
+			Priority:      src.priority,
+			Remote:        src.remote,
+			Name:          src.meta.Name,
+			ConnectedAt:   src.connectedAt,
+			OnAir:         src == s.liveOnAir,
+			Pinned:        src == s.livePinned,
+			Fallback:      src.fallback,
+			Codec:         src.codec,
+			Incident:      incident,
+			Levels:        src.level.levels(now),
+			BytesReceived: src.bytes,
+			Buffered:      len(src.standby),
+		})
+	}
+	return out
+}
+
+// HandleLiveSources lists and controls the connected live sources.
+//
+//	GET    /studio/{id}/sources                  list sources (on air and standby)
+//	POST   /studio/{id}/sources/{source}/switch  put a source on air and pin it there
+//	DELETE /studio/{id}/sources/{source}         disconnect a source ("current" = the one on air)
+func (s *Studio) HandleLiveSources(w http.ResponseWriter, r *http.Request, rest []string) {
+	switch {
+	case len(rest) == 0 || (len(rest) == 1 && rest[0] == ""):
+		if r.Method != http.MethodGet {
+			netutil.ServerResponse(w, 405, "Method not allowed", nil)
+			return
+		}
+		netutil.ServerResponse(w, 200, "Success", s.listLiveSources())
+	case len(rest) == 1:
+		if r.Method != http.MethodDelete {
+			netutil.ServerResponse(w, 405, "Method not allowed", nil)
+			return
+		}
+		if err := s.kickLiveSource(rest[0]); err != nil {
+			netutil.ServerResponse(w, 404, err.Error(), nil)
+			return
+		}
+		netutil.ServerResponse(w, 200, "Source disconnected", nil)
+	case len(rest) == 2 && rest[1] == "switch":
+		if r.Method != http.MethodPost {
+			netutil.ServerResponse(w, 405, "Method not allowed", nil)
+			return
+		}
+		if err := s.forceLiveSource(rest[0]); err != nil {
+			netutil.ServerResponse(w, 404, err.Error(), nil)
+			return
+		}
+		log.Printf("[live %s] %s forced on air", s.ID, rest[0])
+		netutil.ServerResponse(w, 200, "Source on air", nil)
+	default:
+		netutil.ServerResponse(w, 404, "Unknown sources endpoint", nil)
+	}
+}
@@ -0,0 +1,262 @@
+package stream
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ivugurura/radio-studio/internal/geo"
+)
+
+func newTestStudio(t *testing.T, opts ...StudioOption) *Studio {
+	t.Helper()
+	s := NewStudio("test", t.TempDir(), 128, geo.NewResolver("", "test", false), nil, time.Hour, opts...)
+	t.Cleanup(s.Close)
+	return s
+}
+
+func newTestSource(s *Studio, priority int, kicked *bool) *liveSource {
+	return &liveSource{
+		id:          s.nextLiveSource(),
+		principal:   "user:test",
+		priority:    priority,
+		connectedAt: time.Now(),
+		setReadDeadline: func(time.Time) error {
+			if kicked != nil {
+				*kicked = true
+			}
+			return nil
+		},
+	}
+}
+
+// stall backdates the last audio of src past the stall timeout
+func stall(s *Studio, src *liveSource) {
+	s.liveMu.Lock()
+	src.lastData = time.Now().Add(-2 * s.liveStallTimeout)
+	s.liveMu.Unlock()
+}
+
+// onAirID returns the ID of the source listLiveSources reports on air ("" if none)
+func onAirID(t *testing.T, s *Studio) string {
+	t.Helper()
+	id := ""
+	for _, v := range s.listLiveSources() {
+		if v.OnAir {
+			if id != "" {
+				t.Fatalf("two sources on air: %s and %s", id, v.ID)
+			}
+			id = v.ID
+		}
+	}
+	return id
+}
+
+func TestLiveSourceTakeoverAndPromotion(t *testing.T) {
+	s := newTestStudio(t)
+
+	low := newTestSource(s, 0, nil)
+	if !s.addLiveSource(low) {
+		t.Fatalf("first source should go on air")
+	}
+	if !s.liveActive.Load() {
+		t.Fatalf("liveActive should be set once a source is on air")
+	}
+
+	tie := newTestSource(s, 0, nil)
+	if s.addLiveSource(tie) {
+		t.Fatalf("equal priority must not take over")
+	}
+
+	high := newTestSource(s, 5, nil)
+	if !s.addLiveSource(high) {
+		t.Fatalf("higher priority should take over")
+	}
+	if got := onAirID(t, s); got != high.id {
+		t.Fatalf("on air = %q, want %q", got, high.id)
+	}
+
+	// standby audio is buffered, capped at liveStandbyBuffer
+	s.liveData(low, make([]byte, liveStandbyBuffer+100))
+	for _, v := range s.listLiveSources() {
+		if v.ID == low.id && v.Buffered != liveStandbyBuffer {
+			t.Fatalf("standby buffered %d bytes, want %d", v.Buffered, liveStandbyBuffer)
+		}
+	}
+
+	// the best standby (earliest on a tie) is promoted and its buffer flushed
+	s.removeLiveSource(high)
+	if got := onAirID(t, s); got != low.id {
+		t.Fatalf("after drop on air = %q, want %q", got, low.id)
+	}
+	for _, v := range s.listLiveSources() {
+		if v.ID == low.id && v.Buffered != 0 {
+			t.Fatalf("promoted source still holds %d buffered bytes", v.Buffered)
+		}
+	}
+
+	s.removeLiveSource(low)
+	if got := onAirID(t, s); got != tie.id {
+		t.Fatalf("after second drop on air = %q, want %q", got, tie.id)
+	}
+	s.removeLiveSource(tie)
+	if s.liveActive.Load() {
+		t.Fatalf("liveActive should clear when the last source leaves")
+	}
+	if s.LiveMeta() != nil {
+		t.Fatalf("live metadata should clear when the last source leaves")
+	}
+}
+
+func TestLiveSourceForceAndKick(t *testing.T) {
+	s := newTestStudio(t)
+
+	var lowKicked, highKicked bool
+	low := newTestSource(s, 0, &lowKicked)
+	s.addLiveSource(low)
+	backup := newTestSource(s, -1, nil)
+	s.addLiveSource(backup)
+
+	if err := s.forceLiveSource(backup.id); err != nil {
+		t.Fatalf("force: %v", err)
+	}
+	if got := onAirID(t, s); got != backup.id {
+		t.Fatalf("on air = %q, want forced %q", got, backup.id)
+	}
+
+	// a pinned source is not displaced by a higher priority one
+	high := newTestSource(s, 10, &highKicked)
+	if s.addLiveSource(high) {
+		t.Fatalf("higher priority must not displace a pinned source")
+	}
+
+	if err := s.forceLiveSource("live-999"); err != errLiveSourceAbsent {
+		t.Fatalf("force unknown = %v, want errLiveSourceAbsent", err)
+	}
+
+	// kicking only signals the connection; removal happens when its handler ends
+	if err := s.kickLiveSource(low.id); err != nil || !lowKicked {
+		t.Fatalf("kick %s: err=%v kicked=%v", low.id, err, lowKicked)
+	}
+	if err := s.kickLiveSource("current"); err != nil {
+		t.Fatalf("kick current: %v", err)
+	}
+	if highKicked {
+		t.Fatalf("kick current hit %s instead of the source on air", high.id)
+	}
+
+	// when the pinned source drops, priority rules apply again
+	s.removeLiveSource(backup)
+	if got := onAirID(t, s); got != high.id {
+		t.Fatalf("after pinned drop on air = %q, want %q", got, high.id)
+	}
+}
+
+func TestLiveSourceStallFailover(t *testing.T) {
+	s := newTestStudio(t, WithLiveTimeouts(time.Second, 0))
+
+	main := newTestSource(s, 5, nil)
+	s.addLiveSource(main)
+	backup := newTestSource(s, 0, nil)
+	s.addLiveSource(backup)
+
+	// the on-air source stalls: the standby takes over, the stalled one stays connected
+	stall(s, main)
+	s.checkLiveStalls()
+	if got := onAirID(t, s); got != backup.id {
+		t.Fatalf("on air = %q, want standby %q", got, backup.id)
+	}
+	if len(s.listLiveSources()) != 2 {
+		t.Fatalf("stalled source was dropped")
+	}
+
+	// nothing healthy left: the AutoDJ gets the air back
+	stall(s, backup)
+	s.checkLiveStalls()
+	if got := onAirID(t, s); got != "" || s.liveActive.Load() {
+		t.Fatalf("on air = %q live=%v, want off air", got, s.liveActive.Load())
+	}
+
+	// audio flows again: the recovered source is switched back in
+	s.liveData(main, make([]byte, 100))
+	if got := onAirID(t, s); got != main.id || !s.liveActive.Load() {
+		t.Fatalf("on air = %q live=%v, want recovered %q", got, s.liveActive.Load(), main.id)
+	}
+
+	s.removeLiveSource(backup)
+	incidents := s.liveIncidentsSnapshot()
+	if len(incidents) != 2 {
+		t.Fatalf("got %d incidents, want 2", len(incidents))
+	}
+	for i, want := range []struct {
+		source, outcome string
+		onAir           bool
+	}{{main.id, "recovered", true}, {backup.id, "disconnected", true}} {
+		inc := incidents[i]
+		if inc.Source != want.source || inc.Outcome != want.outcome || inc.OnAir != want.onAir || inc.EndedAt == nil {
+			t.Fatalf("incident %d = %+v, want %s %s", i, inc, want.source, want.outcome)
+		}
+	}
+}
+
+func TestLiveSourcePinnedStall(t *testing.T) {
+	s := newTestStudio(t, WithLiveTimeouts(time.Second, 0))
+
+	pinned := newTestSource(s, 0, nil)
+	s.addLiveSource(pinned)
+	other := newTestSource(s, 0, nil)
+	s.addLiveSource(other)
+	if err := s.forceLiveSource(pinned.id); err != nil {
+		t.Fatal(err)
+	}
+
+	stall(s, pinned)
+	s.checkLiveStalls()
+	if got := onAirID(t, s); got != other.id {
+		t.Fatalf("on air = %q, want %q while the pinned source is stalled", got, other.id)
+	}
+	// the pin survives the stall
+	s.liveData(pinned, make([]byte, 100))
+	if got := onAirID(t, s); got != pinned.id {
+		t.Fatalf("on air = %q, want pinned %q back", got, pinned.id)
+	}
+}
+
+func TestLiveSourceSilenceFailover(t *testing.T) {
+	s := newTestStudio(t, WithSilenceDetection(-50, 2*time.Second))
+	loud := testLevelFrame(testGranule{part23: 2000, bigValues: 288, gain: 170, tables: [3]int{13, 0, 0}})
+	second := func(frame []byte) []byte { // 39 frames: one meter window
+		var b []byte
+		for i := 0; i < 39; i++ {
+			b = append(b, frame...)
+		}
+		return b
+	}
+
+	src := newTestSource(s, 0, nil)
+	s.addLiveSource(src)
+	s.liveData(src, second(testMP3Frames(testMP3Header, 1)))
+	if got := onAirID(t, s); got != src.id {
+		t.Fatalf("source taken off air after one second of silence")
+	}
+
+	// the silence started long enough ago: off air at the next measurement
+	s.liveMu.Lock()
+	src.level.quietSince = time.Now().Add(-time.Minute)
+	s.liveMu.Unlock()
+	s.liveData(src, second(testMP3Frames(testMP3Header, 1)))
+	if got := onAirID(t, s); got != "" || s.liveActive.Load() {
+		t.Fatalf("on air = %q live=%v, want the AutoDJ", got, s.liveActive.Load())
+	}
+	if v := s.listLiveSources()[0]; v.Incident != incidentSilence || v.Levels == nil || v.Levels.PeakEstimateDB != levelFloorDB {
+		t.Fatalf("source view = %+v", v)
+	}
+
+	s.liveData(src, second(loud))
+	if got := onAirID(t, s); got != src.id {
+		t.Fatalf("on air = %q, want %q back once audio returns", got, src.id)
+	}
+	inc := s.liveIncidentsSnapshot()
+	if len(inc) != 1 || inc[0].Kind != incidentSilence || inc[0].Outcome != "recovered" || !inc[0].OnAir {
+		t.Fatalf("incidents = %+v", inc)
+	}
+}
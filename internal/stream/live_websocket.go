@@ -0,0 +1,153 @@
+package stream
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// ebmlMagic opens WebM files, what MediaRecorder produces by default in Chromium browsers
+var ebmlMagic = []byte{0x1A, 0x45, 0xDF, 0xA3}
+
+// recorderContainer names the container a browser's MediaRecorder wrapped the audio in (WebM, or
+// MP4 from Safari), which the studio can't play; "" for anything else
+func recorderContainer(head []byte) string {
+	switch {
+	case bytes.HasPrefix(head, ebmlMagic):
+		return "WebM"
+	case len(head) >= 8 && string(head[4:8]) == "ftyp":
+		return "MP4"
+	}
+	return ""
+}
+
+// wsSourceMessage is a metadata update sent by a WebSocket source as a text message
+type wsSourceMessage struct {
+	Title string `json:"title"`
+}
+
+// WithWebSocketOrigins lists the origins of the pages (e.g. "https://dj.example.com") allowed to
+// open WebSocket sources, "*" for any. With none, only pages served from the studio's own host
+// are. Clients that send no Origin are not browsers, and are let through.
+func WithWebSocketOrigins(origins ...string) StudioOption {
+	return func(s *Studio) { s.wsOrigins = origins }
+}
+
+// wsOriginAllowed checks a WebSocket source's Origin against the allowed origins
+func (s *Studio) wsOriginAllowed(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	if len(s.wsOrigins) == 0 {
+		u, err := url.Parse(origin)
+		return err == nil && strings.EqualFold(u.Host, r.Host)
+	}
+	for _, o := range s.wsOrigins {
+		if o == "*" || strings.EqualFold(strings.TrimSuffix(o, "/"), origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// HandleLiveWebSocket takes a live source over a WebSocket, for DJs broadcasting from a browser:
+//
+//	GET /studio/{id}/live?key=sk_...&name=...&genre=...&description=...&url=...&bitrate=...  (Upgrade: websocket)
+//
+// Binary messages carry the encoded audio, text messages are JSON metadata updates
+// ({"title": "Artist - Title"}). The audio is MP3, AAC (ADTS) or Ogg, as on the HTTP ingest:
+// WebM and MP4, what MediaRecorder produces in most browsers, are refused with a 1003 close.
+// Authentication is the HTTP ingest's; browsers can't send Basic auth on a WebSocket, so they use
+// a stream key, from a page whose origin is allowed (see WithWebSocketOrigins). The show's name,
+// genre, description, url and bitrate are taken from the query (or Ice-* headers) on connect.
+func (s *Studio) HandleLiveWebSocket(w http.ResponseWriter, r *http.Request) {
+	log.Printf("[live %s] incoming method=WebSocket remote=%s", s.ID, r.RemoteAddr)
+	if !s.wsOriginAllowed(r) {
+		log.Printf("[live %s] websocket from origin %q refused", s.ID, r.Header.Get("Origin"))
+		http.Error(w, "Origin not allowed", http.StatusForbidden)
+		return
+	}
+	principal, ok := s.authorizeSource(w, r)
+	if !ok {
+		return
+	}
+	conn, br, err := upgradeWebSocket(w, r)
+	if err != nil {
+		log.Printf("[live %s] websocket upgrade failed: %v", s.ID, err)
+		return
+	}
+	defer conn.Close()
+
+	meta := extractLiveMeta(r)
+	q := r.URL.Query()
+	for _, f := range []struct {
+		param string
+		field *string
+	}{
+		{"name", &meta.Name},
+		{"genre", &meta.Genre},
+		{"description", &meta.Description},
+		{"url", &meta.URL},
+		{"bitrate", &meta.Bitrate},
+	} {
+		if v := q.Get(f.param); v != "" {
+			*f.field = v
+		}
+	}
+	meta.Source = principal
+
+	src := &liveSource{
+		id:          s.nextLiveSource(),
+		principal:   principal,
+		priority:    s.sourcePriority(r, principal),
+		remote:      s.sourceIP(r),
+		connectedAt: time.Now().UTC(),
+		meta:        meta,
+
+		setReadDeadline: conn.SetReadDeadline,
+	}
+	ws := &wsReader{conn: conn, br: br, onText: func(msg string) {
+		var m wsSourceMessage
+		if err := json.Unmarshal([]byte(msg), &m); err != nil {
+			log.Printf("[live %s] %s: ignoring message: %v", s.ID, src.id, err)
+			return
+		}
+		if m.Title != "" {
+			s.setSourceTitle(src, m.Title)
+			log.Printf("[live %s] metadata updated song=%q", s.ID, m.Title)
+		}
+	}}
+
+	// the source only connects with its first audio, once it is known not to be WebM or MP4
+	_ = conn.SetReadDeadline(time.Now().Add(s.liveReadTimeout))
+	head := make([]byte, 8192)
+	n, err := io.ReadAtLeast(ws, head, 8)
+	if err != nil {
+		log.Printf("[live %s] websocket source ended before sending audio: %v", s.ID, err)
+		ws.close()
+		return
+	}
+	head = head[:n]
+	if c := recorderContainer(head); c != "" {
+		log.Printf("[live %s] websocket source refused: %s audio (source=%s)", s.ID, c, principal)
+		ws.closeWith(wsCloseUnsupported, c+" is not supported: send MP3, AAC (ADTS) or Ogg")
+		return
+	}
+	onAir := s.addLiveSource(src)
+	log.Printf("[live %s] connected: id=%s method=WebSocket source=%s priority=%d on_air=%v name=%q bitrate=%s", s.ID, src.id, principal, src.priority, onAir, meta.Name, meta.Bitrate)
+
+	s.readLiveSource(src, io.MultiReader(bytes.NewReader(head), ws), liveEarlyEOFGrace, "WebSocket")
+	ws.close()
+	s.removeLiveSource(src)
+
+	log.Printf("[live %s] %s ended", s.ID, src.id)
+	if s.autoDJ != nil && !s.liveActive.Load() {
+		log.Printf("[live %s] AutoDJ resumed", s.ID)
+	}
+}
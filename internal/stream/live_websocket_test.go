@@ -0,0 +1,215 @@
+package stream
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// wsClientFrame builds a masked client frame
+func wsClientFrame(fin bool, op byte, payload []byte) []byte {
+	b0 := op
+	if fin {
+		b0 |= 0x80
+	}
+	f := []byte{b0}
+	switch n := len(payload); {
+	case n < 126:
+		f = append(f, 0x80|byte(n))
+	case n <= 0xFFFF:
+		f = binary.BigEndian.AppendUint16(append(f, 0x80|126), uint16(n))
+	default:
+		f = binary.BigEndian.AppendUint64(append(f, 0x80|127), uint64(n))
+	}
+	mask := []byte{0x12, 0x34, 0x56, 0x78}
+	f = append(f, mask...)
+	for i, c := range payload {
+		f = append(f, c^mask[i&3])
+	}
+	return f
+}
+
+// readServerFrame reads an unmasked server frame
+func readServerFrame(t *testing.T, br *bufio.Reader) (byte, []byte) {
+	t.Helper()
+	var hdr [2]byte
+	if _, err := io.ReadFull(br, hdr[:]); err != nil {
+		t.Fatal(err)
+	}
+	payload := make([]byte, hdr[1]&0x7F)
+	if _, err := io.ReadFull(br, payload); err != nil {
+		t.Fatal(err)
+	}
+	return hdr[0] & 0x0F, payload
+}
+
+func wsDial(t *testing.T, srv *httptest.Server, query string, header ...string) (net.Conn, *bufio.Reader, string) {
+	t.Helper()
+	conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	key := "dGhlIHNhbXBsZSBub25jZQ=="
+	req := "GET /studio/test/live?" + query + " HTTP/1.1\r\nHost: x\r\nUpgrade: websocket\r\nConnection: keep-alive, Upgrade\r\n" +
+		"Sec-WebSocket-Key: " + key + "\r\nSec-WebSocket-Version: 13\r\n"
+	for _, h := range header {
+		req += h + "\r\n"
+	}
+	req += "\r\n"
+	if _, err := io.WriteString(conn, req); err != nil {
+		t.Fatal(err)
+	}
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conn, br, resp.Status + " " + resp.Header.Get("Sec-WebSocket-Accept")
+}
+
+func TestLiveWebSocket(t *testing.T) {
+	s := newTestStudio(t)
+	key, err := s.auth.issueKey("guest", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(http.HandlerFunc(s.HandleLiveWebSocket))
+	t.Cleanup(srv.Close)
+
+	if _, _, status := wsDial(t, srv, "key=sk_wrong"); !strings.HasPrefix(status, "401") {
+		t.Fatalf("wrong key answered %s", status)
+	}
+
+	conn, br, status := wsDial(t, srv, "key="+key.Secret+"&name=Guest+Show&genre=Talk")
+	// the RFC 6455 example key
+	if status != "101 Switching Protocols s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fatalf("handshake answered %s", status)
+	}
+
+	frames := testMP3Frames(testMP3Header, 3)
+	var out []byte
+	// audio split across a fragmented message, with a ping in between
+	out = append(out, wsClientFrame(false, wsOpBinary, frames[:500])...)
+	out = append(out, wsClientFrame(true, wsOpPing, []byte("hi"))...)
+	out = append(out, wsClientFrame(true, wsOpContinuation, frames[500:])...)
+	out = append(out, wsClientFrame(true, wsOpText, []byte(`{"title":"Guest - Interview"}`))...)
+	if _, err := conn.Write(out); err != nil {
+		t.Fatal(err)
+	}
+	if op, payload := readServerFrame(t, br); op != wsOpPong || string(payload) != "hi" {
+		t.Fatalf("got op %d %q, want a pong", op, payload)
+	}
+
+	waitFor(t, "the source on air", func() bool {
+		state, _, _ := s.OnAir()
+		return state == OnAirLive
+	})
+	waitFor(t, "the title", func() bool { return s.nowPlaying().Current == "Guest - Interview" })
+	v := s.listLiveSources()
+	if len(v) != 1 || v[0].Name != "Guest Show" || v[0].Principal != "key:"+key.ID {
+		t.Fatalf("sources = %+v", v)
+	}
+	waitFor(t, "the audio", func() bool { return s.ring.buffered() >= len(frames) })
+
+	// a close is echoed and ends the source
+	if _, err := conn.Write(wsClientFrame(true, wsOpClose, []byte{0x03, 0xE8})); err != nil {
+		t.Fatal(err)
+	}
+	if op, payload := readServerFrame(t, br); op != wsOpClose || binary.BigEndian.Uint16(payload) != wsCloseNormal {
+		t.Fatalf("got op %d %v, want a close", op, payload)
+	}
+	waitFor(t, "the source gone", func() bool { return len(s.listLiveSources()) == 0 })
+}
+
+func TestWSReaderRejectsUnmaskedFrames(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	r := &wsReader{conn: server, br: bufio.NewReader(server)}
+	go func() {
+		client.Write([]byte{0x82, 0x01, 0xFF})
+		io.Copy(io.Discard, client) // the close frame
+	}()
+	if _, err := r.Read(make([]byte, 10)); err != errWSProtocol {
+		t.Fatalf("err = %v", err)
+	}
+}
+
+func TestLiveWebSocketOrigins(t *testing.T) {
+	tests := []struct {
+		name    string
+		allowed []string
+		origin  string
+		want    string
+	}{
+		{"no origin: not a browser", nil, "", "101"},
+		{"same host by default", nil, "https://x", "101"},
+		{"other host by default", nil, "https://evil.example", "403"},
+		{"listed origin", []string{"https://dj.example.com/"}, "https://DJ.example.com", "101"},
+		{"unlisted origin", []string{"https://dj.example.com"}, "https://x", "403"},
+		{"any origin", []string{"*"}, "https://evil.example", "101"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestStudio(t, WithWebSocketOrigins(tt.allowed...))
+			key, err := s.auth.issueKey("guest", 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			srv := httptest.NewServer(http.HandlerFunc(s.HandleLiveWebSocket))
+			t.Cleanup(srv.Close)
+			var header []string
+			if tt.origin != "" {
+				header = append(header, "Origin: "+tt.origin)
+			}
+			if _, _, status := wsDial(t, srv, "key="+key.Secret, header...); !strings.HasPrefix(status, tt.want) {
+				t.Fatalf("answered %s, want %s", status, tt.want)
+			}
+		})
+	}
+}
+
+func TestLiveWebSocketRefusesWebM(t *testing.T) {
+	s := newTestStudio(t)
+	key, err := s.auth.issueKey("guest", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(http.HandlerFunc(s.HandleLiveWebSocket))
+	t.Cleanup(srv.Close)
+
+	conn, br, _ := wsDial(t, srv, "key="+key.Secret)
+	webm := append([]byte{0x1A, 0x45, 0xDF, 0xA3, 0x9F, 0x42, 0x86, 0x81, 0x01}, "webm"...)
+	if _, err := conn.Write(wsClientFrame(true, wsOpBinary, webm)); err != nil {
+		t.Fatal(err)
+	}
+	op, payload := readServerFrame(t, br)
+	if op != wsOpClose || len(payload) < 2 || binary.BigEndian.Uint16(payload) != wsCloseUnsupported || !strings.Contains(string(payload[2:]), "WebM") {
+		t.Fatalf("got op %d %q, want an unsupported-data close", op, payload)
+	}
+	if v := s.listLiveSources(); len(v) != 0 {
+		t.Fatalf("sources = %+v", v)
+	}
+}
+
+func TestRecorderContainer(t *testing.T) {
+	for _, tt := range []struct {
+		head []byte
+		want string
+	}{
+		{[]byte{0x1A, 0x45, 0xDF, 0xA3, 0x9F, 0x42, 0x86, 0x81}, "WebM"},
+		{[]byte("\x00\x00\x00\x1cftypM4A "), "MP4"},
+		{testMP3Frames(testMP3Header, 1), ""},
+		{testADTSFrames(3, 200, 1), ""},
+		{[]byte("OggS\x00\x02\x00\x00"), ""},
+	} {
+		if got := recorderContainer(tt.head); got != tt.want {
+			t.Errorf("% X: %q, want %q", tt.head[:8], got, tt.want)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package stream
 
 import (
+	"crypto/subtle"
 	"errors"
 	"log"
 	"net/http"
@@ -17,7 +18,7 @@ import (
 // Useful if later you inject DB handles, metrics, logger, bitrate, etc
 type RequestValidator func(r *http.Request, studioID, action string) error
 
-type StudioFactory func(id, audioDir string, bitrateKbps int, geoR *geo.Resolver, autoDJFactory AutoDJFactory, snapshotInterval time.Duration) *Studio
+type StudioFactory func(id, audioDir string, bitrateKbps int, geoR *geo.Resolver, autoDJFactory AutoDJFactory, snapshotInterval time.Duration, opts ...StudioOption) *Studio
 
 type ManagerOption func(*Manager)
 
@@ -41,6 +42,33 @@ func WithAutoDJFactory(f AutoDJFactory) ManagerOption {
 	return func(m *Manager) { m.autoDJFactory = f }
 }
 
+// WithStudioOptions sets options applied to every studio before its own RegisterStudio options
+func WithStudioOptions(opts ...StudioOption) ManagerOption {
+	return func(m *Manager) { m.studioOptions = append(m.studioOptions, opts...) }
+}
+
+// AdminTokenValidator protects the given actions with a static bearer token.
+// An empty token disables those actions entirely.
+func AdminTokenValidator(token string, actions ...string) RequestValidator {
+	protected := make(map[string]bool, len(actions))
+	for _, a := range actions {
+		protected[a] = true
+	}
+	return func(r *http.Request, studioID, action string) error {
+		if !protected[action] {
+			return nil
+		}
+		if token == "" {
+			return errors.New("admin API disabled")
+		}
+		auth := r.Header.Get("Authorization")
+		if !strings.HasPrefix(auth, "Bearer ") || subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(auth, "Bearer ")), []byte(token)) != 1 {
+			return errors.New("invalid admin token")
+		}
+		return nil
+	}
+}
+
 // Manager coordinates all studios
 type Manager struct {
 	mu           sync.RWMutex
@@ -51,6 +79,7 @@ type Manager struct {
 	defaultBitrateKbps int
 	snapshotInterval   time.Duration
 	autoDJFactory      AutoDJFactory
+	studioOptions      []StudioOption
 
 	validator RequestValidator
 	factory   StudioFactory
@@ -65,15 +94,12 @@ func NewManager(baseDir string, geoR *geo.Resolver, opts ...ManagerOption) *Mana
 		defaultBitrateKbps: 128,
 		geoResolver:        geoR,
 		snapshotInterval:   5 * time.Second,
-		// Provide a default AutoDJ factory that works even if no backend playlist is configured.
-		// It passes empty strings so the backend playlist fetch will noop; you can supply a fallback track via ManagerOption later.
+		// Default AutoDJ works without a backend: shuffle the studio's audio directory.
 		autoDJFactory: func(dir string, studioID string, bitrate int, push func([]byte)) AutoDJ {
-			// Empty endpoint/apiKey/fallback means AutoDJ will attempt backend fetch (fails silently) and just idle unless a fallback is later provided.
-			// To enable a simple filesystem playlist, implement a filesystemPlaylist and wire it here.
-			return NewAutoDJ(dir, studioID, bitrate, push, "", "", "")
+			return NewAutoDJWithPlaylist(dir, bitrate, push, NewFilesystemPlaylist(dir, PlaylistShuffle, nil, time.Minute), "", "", "")
 		},
-		factory: func(id, dir string, bitrate int, geoR *geo.Resolver, dj AutoDJFactory, snapInt time.Duration) *Studio {
-			return NewStudio(id, dir, bitrate, geoR, dj, snapInt)
+		factory: func(id, dir string, bitrate int, geoR *geo.Resolver, dj AutoDJFactory, snapInt time.Duration, opts ...StudioOption) *Studio {
+			return NewStudio(id, dir, bitrate, geoR, dj, snapInt, opts...)
 		},
 	}
 
@@ -89,15 +115,19 @@ func (m *Manager) SetFactory(f StudioFactory) {
 	m.factory = f
 }
 
-func (m *Manager) RegisterStudio(studioID string) *Studio {
+func (m *Manager) RegisterStudio(studioID string, opts ...StudioOption) *Studio {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 	if s, ok := m.studios[studioID]; ok {
 		return s
 	}
 	dir := filepath.Join(m.audioBaseDir, studioID)
-	studio := m.factory(studioID, dir, m.defaultBitrateKbps, m.geoResolver, m.autoDJFactory, m.snapshotInterval)
-	studio.ID = studioID
+	all := append(append([]StudioOption{}, m.studioOptions...), opts...)
+	studio := m.factory(studioID, dir, m.defaultBitrateKbps, m.geoResolver, m.autoDJFactory, m.snapshotInterval, all...)
+	// the studio's goroutines already read its ID: only write it if the factory set another
+	if studio.ID != studioID {
+		studio.ID = studioID
+	}
 	m.studios[studioID] = studio
 	log.Printf("Manager: registered studio %s (audioDir=%s)", studioID, dir)
 
@@ -179,7 +209,7 @@ func (m *Manager) StartMonitor(interval time.Duration, stop <-chan struct{}) {
 
 // RouteStudioRequest parses path and forwards to the appropriate studio handler.
 // Expected pattern: /studio/{id}/{action}
-// Actions: listen | live (extend as needed: metadata, status, etc.)
+// Actions: listen | live | status | snapshot | skip | now | events | cover | hls | keys | sources
 func (m *Manager) RouteStudioRequest(w http.ResponseWriter, r *http.Request) {
 	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/studio/"), "/")
 	if len(parts) < 2 {
@@ -193,11 +223,26 @@ func (m *Manager) RouteStudioRequest(w http.ResponseWriter, r *http.Request) {
 		netutil.ServerResponse(w, 404, "Studio not found", nil)
 		return
 	}
+	if m.validator != nil {
+		if err := m.validator(r, studioID, action); err != nil {
+			netutil.ServerResponse(w, 401, err.Error(), nil)
+			return
+		}
+	}
 
 	switch action {
 	case "live":
+		if isWebSocketUpgrade(r) {
+			studio.HandleLiveWebSocket(w, r)
+			return
+		}
 		studio.HandleLiveIngest(w, r)
 	case "listen":
+		// /studio/{id}/listen[/{mount}]
+		if len(parts) > 2 && parts[2] != "" {
+			studio.HandleMountListen(w, r, parts[2])
+			return
+		}
 		studio.HandleListen(w, r)
 	case "status":
 		studio.HandleStatus(w, r)
@@ -207,6 +252,18 @@ func (m *Manager) RouteStudioRequest(w http.ResponseWriter, r *http.Request) {
 		studio.HandleSkip(w, r)
 	case "now":
 		studio.HandleNowPlaying(w, r)
+	case "events":
+		studio.HandleOnAirEvents(w, r)
+	case "cover":
+		studio.HandleCover(w, r)
+	case "hls":
+		studio.HandleHLS(w, r, parts[2:])
+	case "keys":
+		// /studio/{id}/keys[/{keyID}[/rotate]]
+		studio.HandleStreamKeys(w, r, parts[2:])
+	case "sources":
+		// /studio/{id}/sources[/{sourceID}[/switch]]
+		studio.HandleLiveSources(w, r, parts[2:])
 	default:
 		netutil.ServerResponse(w, 404, "Unknown action", nil)
 	}
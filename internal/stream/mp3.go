@@ -0,0 +1,483 @@
+package stream
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"os"
+	"strconv"
+	"time"
+)
+
+// MPEG audio frame header parsing (MPEG-1/2/2.5, layers I-III).
+// Only what the streaming code needs: sizes, durations and sync.
+
+const (
+	mpeg1  = 3
+	mpeg2  = 2
+	mpeg25 = 0
+)
+
+var mp3Bitrates = [2][4][16]int{
+	// MPEG-1: layer index 1=III, 2=II, 3=I
+	{
+		{},
+		{0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 0},
+		{0, 32, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 384, 0},
+		{0, 32, 64, 96, 128, 160, 192, 224, 256, 288, 320, 352, 384, 416, 448, 0},
+	},
+	// MPEG-2 / 2.5
+	{
+		{},
+		{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160, 0},
+		{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160, 0},
+		{0, 32, 48, 56, 64, 80, 96, 112, 128, 144, 160, 176, 192, 224, 256, 0},
+	},
+}
+
+var mp3SampleRates = [4][3]int{
+	mpeg25: {11025, 12000, 8000},
+	mpeg2:  {22050, 24000, 16000},
+	mpeg1:  {44100, 48000, 32000},
+}
+
+// mp3Header is a decoded 4-byte MPEG audio frame header
+type mp3Header struct {
+	Version    int // mpeg1, mpeg2 or mpeg25
+	Layer      int // 1, 2 or 3
+	Bitrate    int // kbps
+	SampleRate int
+	Padding    bool
+	Mono       bool
+	CRC        bool
+	FrameSize  int // bytes, including header
+	Samples    int // PCM samples per channel in this frame
+}
+
+// parseMP3Header decodes b[0:4]; ok is false for anything that is not a usable frame header
+// (free-format bitrate and reserved values are rejected).
+func parseMP3Header(b []byte) (mp3Header, bool) {
+	if len(b) < 4 || b[0] != 0xFF || b[1]&0xE0 != 0xE0 {
+		return mp3Header{}, false
+	}
+	version := int(b[1]>>3) & 3
+	layerBits := int(b[1]>>1) & 3
+	brIdx := int(b[2] >> 4)
+	srIdx := int(b[2]>>2) & 3
+	if version == 1 || layerBits == 0 || brIdx == 0 || brIdx == 15 || srIdx == 3 || b[3]&3 == 2 {
+		return mp3Header{}, false
+	}
+	table := 0
+	if version != mpeg1 {
+		table = 1
+	}
+	h := mp3Header{
+		Version:    version,
+		Layer:      4 - layerBits,
+		Bitrate:    mp3Bitrates[table][layerBits][brIdx],
+		SampleRate: mp3SampleRates[version][srIdx],
+		Padding:    b[2]&0x02 != 0,
+		Mono:       b[3]>>6 == 3,
+		CRC:        b[1]&0x01 == 0,
+	}
+	pad := 0
+	if h.Padding {
+		pad = 1
+	}
+	switch h.Layer {
+	case 1:
+		h.Samples = 384
+		h.FrameSize = (12*h.Bitrate*1000/h.SampleRate + pad) * 4
+	case 2:
+		h.Samples = 1152
+		h.FrameSize = 144*h.Bitrate*1000/h.SampleRate + pad
+	case 3:
+		if version == mpeg1 {
+			h.Samples = 1152
+			h.FrameSize = 144*h.Bitrate*1000/h.SampleRate + pad
+		} else {
+			h.Samples = 576
+			h.FrameSize = 72*h.Bitrate*1000/h.SampleRate + pad
+		}
+	}
+	return h, true
+}
+
+// Duration is the playback time of one frame
+func (h mp3Header) Duration() time.Duration {
+	return time.Duration(h.Samples) * time.Second / time.Duration(h.SampleRate)
+}
+
+// sideInfoSize is the length of the layer III side information following the header (and CRC)
+func (h mp3Header) sideInfoSize() int {
+	switch {
+	case h.Version == mpeg1 && h.Mono:
+		return 17
+	case h.Version == mpeg1:
+		return 32
+	case h.Mono:
+		return 9
+	default:
+		return 17
+	}
+}
+
+// vbrFrameCount reads the total frame count from a Xing/Info or VBRI header frame.
+// ok is false if frame is an ordinary audio frame (or the header carries no count).
+func vbrFrameCount(frame []byte, h mp3Header) (frames int, ok bool) {
+	if h.Layer != 3 {
+		return 0, false
+	}
+	off := 4 + h.sideInfoSize()
+	if h.CRC {
+		off += 2
+	}
+	if off+12 <= len(frame) {
+		if tag := string(frame[off : off+4]); tag == "Xing" || tag == "Info" {
+			if binary.BigEndian.Uint32(frame[off+4:])&1 == 0 {
+				return 0, true // header frame without a count: still not audio
+			}
+			return int(binary.BigEndian.Uint32(frame[off+8:])), true
+		}
+	}
+	// VBRI always sits 32 bytes after the header
+	if 36+18 <= len(frame) && string(frame[36:40]) == "VBRI" {
+		return int(binary.BigEndian.Uint32(frame[36+14:])), true
+	}
+	return 0, false
+}
+
+// mp3Clock accumulates the playback time of frames. Time is kept as a sample count per sample
+// rate so that rounding a frame duration to nanoseconds never drifts over a long track.
+type mp3Clock struct {
+	base    time.Duration // time accumulated before the last sample rate change
+	rate    int
+	samples int64
+}
+
+func (c *mp3Clock) add(h mp3Header) {
+	c.addSamples(h.SampleRate, h.Samples)
+}
+
+// addSamples adds n samples at rate (the clock also paces AAC, whose frames have other sizes)
+func (c *mp3Clock) addSamples(rate, n int) {
+	if rate != c.rate {
+		c.base = c.elapsed()
+		c.rate = rate
+		c.samples = 0
+	}
+	c.samples += int64(n)
+}
+
+func (c *mp3Clock) elapsed() time.Duration {
+	if c.rate == 0 {
+		return c.base
+	}
+	return c.base + time.Duration(c.samples*int64(time.Second)/int64(c.rate))
+}
+
+// compatible reports whether two headers plausibly belong to the same stream
+func (h mp3Header) compatible(o mp3Header) bool {
+	return h.Version == o.Version && h.Layer == o.Layer && h.SampleRate == o.SampleRate
+}
+
+// mp3Sync returns the offset of the first frame in b at or after from, or -1.
+// A candidate header is only accepted if the following frame also starts with a compatible
+// header (or the candidate frame ends exactly at len(b)), which rules out false syncs in audio data.
+func mp3Sync(b []byte, from int) int {
+	for i := from; i+4 <= len(b); i++ {
+		if b[i] != 0xFF {
+			continue
+		}
+		h, ok := parseMP3Header(b[i:])
+		if !ok {
+			continue
+		}
+		next := i + h.FrameSize
+		if next == len(b) {
+			return i
+		}
+		if next+4 > len(b) {
+			// can't confirm; accept only if nothing better can follow
+			if next > len(b) {
+				continue
+			}
+			return i
+		}
+		if h2, ok := parseMP3Header(b[next:]); ok && h.compatible(h2) {
+			return i
+		}
+	}
+	return -1
+}
+
+// silentMP3Frame builds a frame in the format of header hdr whose side info and audio data are all
+// zero, which every decoder plays as silence. CRC and padding are cleared; nil if hdr is not a header.
+func silentMP3Frame(hdr []byte) []byte {
+	if len(hdr) < 4 {
+		return nil
+	}
+	b := []byte{hdr[0], hdr[1] | 0x01, hdr[2] &^ 0x02, hdr[3]}
+	h, ok := parseMP3Header(b)
+	if !ok {
+		return nil
+	}
+	frame := make([]byte, h.FrameSize)
+	copy(frame, b)
+	return frame
+}
+
+// mp3Framer re-chunks an arbitrary byte stream into whole MPEG audio frames.
+// Bytes that are not part of a frame are dropped while resyncing. If no frame sync is found
+// in a large window the input is assumed not to be MPEG audio and is passed through unchanged.
+type mp3Framer struct {
+	pending []byte
+	synced  bool
+	last    mp3Header
+	lastRaw [4]byte // raw bytes of last, to build silent frames in the same format
+
+	junk int64 // bytes dropped while resyncing
+}
+
+const mp3PassthroughWindow = 16 * 1024
+
+// push appends data and returns the whole frames now available (possibly none)
+func (f *mp3Framer) push(data []byte) []byte {
+	f.pending = append(f.pending, data...)
+	var out []byte
+	i := 0
+	for len(f.pending)-i >= 4 {
+		if f.synced {
+			if h, ok := parseMP3Header(f.pending[i:]); ok && h.compatible(f.last) {
+				if i+h.FrameSize > len(f.pending) {
+					break
+				}
+				out = append(out, f.pending[i:i+h.FrameSize]...)
+				i += h.FrameSize
+				continue
+			}
+			f.synced = false
+		}
+		j := mp3Sync(f.pending, i)
+		if j < 0 {
+			if len(f.pending)-i > mp3PassthroughWindow {
+				// not MPEG audio (or hopelessly corrupt): don't stall the stream
+				end := len(f.pending) - 3
+				out = append(out, f.pending[i:end]...)
+				i = end
+			}
+			break
+		}
+		f.junk += int64(j - i)
+		h, _ := parseMP3Header(f.pending[j:])
+		f.last = h
+		copy(f.lastRaw[:], f.pending[j:j+4])
+		f.synced = true
+		i = j
+	}
+	f.pending = append(f.pending[:0:0], f.pending[i:]...)
+	return out
+}
+
+// mp3FileReader yields only the valid MPEG audio frames of a file: leading ID3v2 tags and trailing
+// ID3v1 / APE / Lyrics3 tags are excluded, and anything between frames that doesn't parse is skipped.
+// A leading Xing/Info/VBRI header frame is consumed (it holds no audio) and its frame count kept.
+type mp3FileReader struct {
+	r      *bufio.Reader
+	synced bool
+	last   mp3Header
+
+	vbrChecked bool
+	vbrFrames  int // total frames announced by a Xing/VBRI header (0 if none)
+
+	fileStats
+}
+
+// fileStats counts what a file reader found in a file's audio region
+type fileStats struct {
+	junk    int64 // bytes skipped inside the audio region
+	regions int   // number of distinct corrupt regions
+	frames  int
+}
+
+func (st *fileStats) stats() *fileStats { return st }
+
+func (st *fileStats) skip(r *bufio.Reader, n int, inJunk *bool) {
+	if !*inJunk {
+		*inJunk = true
+		st.regions++
+	}
+	if n > 0 {
+		_, _ = r.Discard(n)
+		st.junk += int64(n)
+	}
+}
+
+func newMP3FileReader(f *os.File) (*mp3FileReader, error) {
+	r, err := audioSection(f)
+	if err != nil {
+		return nil, err
+	}
+	return &mp3FileReader{r: r}, nil
+}
+
+// audioSection reads the audio region of a file: without leading ID3v2 and trailing tags
+func audioSection(f *os.File) (*bufio.Reader, error) {
+	st, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	start, end := int64(0), st.Size()
+
+	// leading ID3v2 tag(s); some taggers stack more than one
+	hdr := make([]byte, 10)
+	for {
+		if _, err := f.ReadAt(hdr, start); err != nil {
+			break
+		}
+		n := id3v2Size(hdr)
+		if n == 0 {
+			break
+		}
+		start += int64(n)
+	}
+	end = audioEnd(f, start, end)
+	if end < start {
+		end = start
+	}
+	return bufio.NewReaderSize(io.NewSectionReader(f, start, end-start), 64*1024), nil
+}
+
+// audioEnd strips trailing ID3v1, APEv1/v2 and Lyrics3v2 tags (in any order)
+func audioEnd(f *os.File, start, end int64) int64 {
+	buf := make([]byte, 32)
+	for {
+		switch {
+		case end-start >= 128 && readAtIs(f, end-128, "TAG"):
+			end -= 128
+		case end-start >= 32 && readAtIs(f, end-32, "APETAGEX"):
+			if _, err := f.ReadAt(buf, end-32); err != nil {
+				return end
+			}
+			size := int64(binary.LittleEndian.Uint32(buf[12:16])) // items + footer
+			if binary.LittleEndian.Uint32(buf[20:24])&(1<<31) != 0 {
+				size += 32 // header present
+			}
+			if size <= 0 || size > end-start {
+				return end
+			}
+			end -= size
+		case end-start >= 15 && readAtIs(f, end-9, "LYRICS200"):
+			if _, err := f.ReadAt(buf[:6], end-15); err != nil {
+				return end
+			}
+			size, err := strconv.Atoi(string(buf[:6]))
+			if err != nil || int64(size)+15 > end-start {
+				return end
+			}
+			end -= int64(size) + 15
+		default:
+			return end
+		}
+	}
+}
+
+func readAtIs(f *os.File, off int64, magic string) bool {
+	b := make([]byte, len(magic))
+	if _, err := f.ReadAt(b, off); err != nil {
+		return false
+	}
+	return string(b) == magic
+}
+
+// next returns the next frame; io.EOF at the end of the audio region
+func (m *mp3FileReader) next() ([]byte, mp3Header, error) {
+	inJunk := false
+	for {
+		hb, err := m.r.Peek(4)
+		if len(hb) < 4 {
+			if len(hb) > 0 {
+				m.skip(m.r, len(hb), &inJunk)
+			}
+			if err == nil {
+				err = io.EOF
+			}
+			return nil, mp3Header{}, err
+		}
+		h, ok := parseMP3Header(hb)
+		if ok && m.synced && !h.compatible(m.last) {
+			ok = false
+		}
+		if ok && !m.synced {
+			// not in sync yet: the following frame must confirm this header (unless the file ends here)
+			look, _ := m.r.Peek(h.FrameSize + 4)
+			switch {
+			case len(look) == h.FrameSize:
+			case len(look) == h.FrameSize+4:
+				h2, ok2 := parseMP3Header(look[h.FrameSize:])
+				ok = ok2 && h.compatible(h2)
+			default:
+				ok = false
+			}
+		}
+		if !ok {
+			m.synced = false
+			m.skip(m.r, 1, &inJunk)
+			continue
+		}
+		frame := make([]byte, h.FrameSize)
+		if n, err := io.ReadFull(m.r, frame); err != nil {
+			m.skip(m.r, 0, &inJunk)
+			m.junk += int64(n)
+			return nil, mp3Header{}, io.EOF // truncated last frame
+		}
+		m.synced = true
+		m.last = h
+		if !m.vbrChecked {
+			m.vbrChecked = true
+			if n, ok := vbrFrameCount(frame, h); ok {
+				m.vbrFrames = n
+				continue
+			}
+		}
+		m.frames++
+		return frame, h, nil
+	}
+}
+
+func (m *mp3FileReader) nextFrame() ([]byte, int, int, error) {
+	frame, h, err := m.next()
+	return frame, h.SampleRate, h.Samples, err
+}
+
+// mp3Duration returns the playback length of an MP3 file. The Xing/VBRI frame count is used when
+// present; otherwise every frame header is walked, which is exact for both CBR and VBR files.
+func mp3Duration(path string) (time.Duration, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	frames, err := newMP3FileReader(f)
+	if err != nil {
+		return 0, err
+	}
+	var clock mp3Clock
+	_, h, err := frames.next()
+	if err != nil {
+		return 0, err
+	}
+	if frames.vbrFrames > 0 {
+		clock.rate = h.SampleRate
+		clock.samples = int64(frames.vbrFrames) * int64(h.Samples)
+		return clock.elapsed(), nil
+	}
+	for err == nil {
+		clock.add(h)
+		_, h, err = frames.next()
+	}
+	if err != io.EOF {
+		return 0, err
+	}
+	return clock.elapsed(), nil
+}
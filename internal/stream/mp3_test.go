@@ -0,0 +1,180 @@
+package stream
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+// MPEG-1 layer III, 128 kbps, 44.1 kHz, joint stereo, no CRC: 417-byte frames
+var testMP3Header = []byte{0xFF, 0xFB, 0x90, 0x64}
+
+// testMP3Frames returns n frames with the given header; the payload has no 0xFF bytes
+func testMP3Frames(hdr []byte, n int) []byte {
+	h, ok := parseMP3Header(hdr)
+	if !ok {
+		panic("bad test header")
+	}
+	var out []byte
+	for i := 0; i < n; i++ {
+		f := make([]byte, h.FrameSize)
+		copy(f, hdr)
+		f[h.FrameSize-1] = byte(i) // tell frames apart
+		out = append(out, f...)
+	}
+	return out
+}
+
+func TestParseMP3Header(t *testing.T) {
+	tests := []struct {
+		name       string
+		hdr        []byte
+		ok         bool
+		layer      int
+		bitrate    int
+		sampleRate int
+		frameSize  int
+		samples    int
+	}{
+		{"mpeg1 layer3", []byte{0xFF, 0xFB, 0x90, 0x64}, true, 3, 128, 44100, 417, 1152},
+		{"mpeg1 layer3 padded", []byte{0xFF, 0xFB, 0x92, 0x64}, true, 3, 128, 44100, 418, 1152},
+		{"mpeg1 layer3 48k mono", []byte{0xFF, 0xFB, 0x94, 0xC4}, true, 3, 128, 48000, 384, 1152},
+		{"mpeg2 layer3", []byte{0xFF, 0xF3, 0x90, 0x64}, true, 3, 80, 22050, 261, 576},
+		{"mpeg2.5 layer3", []byte{0xFF, 0xE3, 0x90, 0x64}, true, 3, 80, 11025, 522, 576},
+		{"mpeg1 layer2", []byte{0xFF, 0xFD, 0x90, 0x64}, true, 2, 160, 44100, 522, 1152},
+		{"mpeg1 layer1", []byte{0xFF, 0xFF, 0x90, 0x64}, true, 1, 288, 44100, 312, 384},
+		{"no sync", []byte{0xFE, 0xFB, 0x90, 0x64}, false, 0, 0, 0, 0, 0},
+		{"reserved version", []byte{0xFF, 0xEB, 0x90, 0x64}, false, 0, 0, 0, 0, 0},
+		{"reserved layer", []byte{0xFF, 0xF9, 0x90, 0x64}, false, 0, 0, 0, 0, 0},
+		{"free format", []byte{0xFF, 0xFB, 0x00, 0x64}, false, 0, 0, 0, 0, 0},
+		{"bad bitrate", []byte{0xFF, 0xFB, 0xF0, 0x64}, false, 0, 0, 0, 0, 0},
+		{"reserved sample rate", []byte{0xFF, 0xFB, 0x9C, 0x64}, false, 0, 0, 0, 0, 0},
+		{"reserved emphasis", []byte{0xFF, 0xFB, 0x90, 0x66}, false, 0, 0, 0, 0, 0},
+		{"short", []byte{0xFF, 0xFB, 0x90}, false, 0, 0, 0, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, ok := parseMP3Header(tt.hdr)
+			if ok != tt.ok {
+				t.Fatalf("ok = %v, want %v", ok, tt.ok)
+			}
+			if !ok {
+				return
+			}
+			if h.Layer != tt.layer || h.Bitrate != tt.bitrate || h.SampleRate != tt.sampleRate || h.FrameSize != tt.frameSize || h.Samples != tt.samples {
+				t.Fatalf("got layer=%d bitrate=%d rate=%d size=%d samples=%d", h.Layer, h.Bitrate, h.SampleRate, h.FrameSize, h.Samples)
+			}
+		})
+	}
+}
+
+func TestMP3FramerRechunks(t *testing.T) {
+	frames := testMP3Frames(testMP3Header, 10)
+	junk := []byte("not audio at all, just junk")
+	tests := []struct {
+		name     string
+		input    []byte
+		splits   []int // chunk sizes fed to push; the rest goes in one final push
+		want     []byte
+		wantJunk int64
+	}{
+		{"whole frames", frames, nil, frames, 0},
+		{"odd splits", frames, []int{1, 3, 416, 2, 1000}, frames, 0},
+		{"leading junk", append(append([]byte{}, junk...), frames...), []int{5}, frames, int64(len(junk))},
+		{
+			"junk between frames",
+			append(append(append([]byte{}, frames[:417*4]...), junk...), frames[417*4:]...),
+			[]int{417*4 + 3},
+			frames,
+			int64(len(junk)),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var f mp3Framer
+			var out []byte
+			in := tt.input
+			for _, n := range tt.splits {
+				out = append(out, f.push(in[:n])...)
+				in = in[n:]
+			}
+			out = append(out, f.push(in)...)
+			if !bytes.Equal(out, tt.want) {
+				t.Fatalf("got %d bytes, want %d", len(out), len(tt.want))
+			}
+			if f.junk != tt.wantJunk {
+				t.Fatalf("junk = %d, want %d", f.junk, tt.wantJunk)
+			}
+			if len(out)%417 != 0 {
+				t.Fatalf("output is not frame aligned")
+			}
+		})
+	}
+}
+
+func TestMP3FramerHoldsPartialFrame(t *testing.T) {
+	frames := testMP3Frames(testMP3Header, 3)
+	var f mp3Framer
+	out := f.push(frames[:417*2+100])
+	if len(out) != 417*2 {
+		t.Fatalf("emitted %d bytes, want the 2 whole frames", len(out))
+	}
+	if len(f.pending) != 100 {
+		t.Fatalf("pending = %d, want the 100-byte partial frame", len(f.pending))
+	}
+	if out = f.push(frames[417*2+100:]); !bytes.Equal(out, frames[417*2:]) {
+		t.Fatalf("partial frame not completed")
+	}
+}
+
+func TestMP3FramerPassthrough(t *testing.T) {
+	// no frame sync anywhere: once more than the window is pending it is passed through
+	data := bytes.Repeat([]byte("OggS"), mp3PassthroughWindow/4+100)
+	var f mp3Framer
+	out := f.push(data)
+	if len(out) != len(data)-3 || !bytes.Equal(out, data[:len(out)]) {
+		t.Fatalf("passthrough emitted %d of %d bytes", len(out), len(data))
+	}
+	if out := f.push(data[:10]); len(out) != 0 {
+		t.Fatalf("small non-audio push below the window emitted %d bytes", len(out))
+	}
+}
+
+func TestMP3FramerSampleRateChangeResyncs(t *testing.T) {
+	a := testMP3Frames(testMP3Header, 3)
+	b := testMP3Frames([]byte{0xFF, 0xFB, 0x94, 0x64}, 3) // 48 kHz
+	var f mp3Framer
+	out := f.push(append(append([]byte{}, a...), b...))
+	if !bytes.Equal(out, append(append([]byte{}, a...), b...)) {
+		t.Fatalf("got %d bytes, want %d", len(out), len(a)+len(b))
+	}
+	if f.last.SampleRate != 48000 {
+		t.Fatalf("framer did not resync to the new format (rate %d)", f.last.SampleRate)
+	}
+}
+
+func TestMP3Clock(t *testing.T) {
+	h, _ := parseMP3Header(testMP3Header)
+	var c mp3Clock
+	for i := 0; i < 44100; i++ { // 44100 frames of 1152 samples = 1152 s
+		c.add(h)
+	}
+	if got := c.elapsed(); got != 1152*time.Second {
+		t.Fatalf("elapsed = %v, want exactly 1152s", got)
+	}
+}
+
+func TestSilentMP3Frame(t *testing.T) {
+	// CRC and padding bits are cleared
+	f := silentMP3Frame([]byte{0xFF, 0xFA, 0x92, 0x64})
+	h, ok := parseMP3Header(f)
+	if !ok || h.CRC || h.Padding || h.FrameSize != len(f) {
+		t.Fatalf("silent frame header: ok=%v crc=%v padding=%v size=%d len=%d", ok, h.CRC, h.Padding, h.FrameSize, len(f))
+	}
+	if bytes.ContainsFunc(f[4:], func(r rune) bool { return r != 0 }) {
+		t.Fatalf("silent frame payload is not all zero")
+	}
+	if silentMP3Frame([]byte{0, 0, 0, 0}) != nil {
+		t.Fatalf("expected nil for a non-header")
+	}
+}
@@ -0,0 +1,146 @@
+package stream
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+)
+
+// Ogg passthrough: Ogg streams (Vorbis, Opus, FLAC) are not decoded, only split into pages. A
+// logical stream starts with header pages (identification, comments, setup) that a decoder needs
+// before any audio; the pager keeps them so that listeners joining mid-stream get them first.
+
+var oggCapture = []byte("OggS")
+
+const (
+	oggPageHeaderSize = 27
+	oggFlagBOS        = 0x02
+)
+
+var (
+	errOggShort = errors.New("incomplete ogg page")
+	errOggBad   = errors.New("invalid ogg page")
+)
+
+type oggPage struct {
+	size    int
+	bos     bool  // first page of a logical stream
+	granule int64 // -1 when no packet ends on the page
+}
+
+// parseOggPage reads the page at the start of b
+func parseOggPage(b []byte) (oggPage, error) {
+	if len(b) < oggPageHeaderSize {
+		return oggPage{}, errOggShort
+	}
+	if !bytes.HasPrefix(b, oggCapture) || b[4] != 0 || b[5]&^0x07 != 0 {
+		return oggPage{}, errOggBad
+	}
+	nsegs := int(b[26])
+	if len(b) < oggPageHeaderSize+nsegs {
+		return oggPage{}, errOggShort
+	}
+	size := oggPageHeaderSize + nsegs
+	for _, l := range b[oggPageHeaderSize : oggPageHeaderSize+nsegs] {
+		size += int(l)
+	}
+	if len(b) < size {
+		return oggPage{}, errOggShort
+	}
+	return oggPage{
+		size:    size,
+		bos:     b[5]&oggFlagBOS != 0,
+		granule: int64(binary.LittleEndian.Uint64(b[6:14])),
+	}, nil
+}
+
+// oggPager splits an Ogg stream into whole pages and keeps the header pages of the current
+// logical stream. Header pages are held back until the first audio page, so a run of pages that
+// starts a stream always begins with its complete headers.
+type oggPager struct {
+	pending    []byte
+	headers    []byte // of the current logical stream
+	collecting []byte // header pages of a stream that has not started its audio yet
+	inHeaders  bool
+
+	junk int64 // bytes dropped while resyncing
+}
+
+func (p *oggPager) write(data []byte) {
+	p.pending = append(p.pending, data...)
+}
+
+// next returns the next run of whole pages belonging to one logical stream (nil when it needs
+// more data). fresh is true when the run starts a new stream, i.e. begins with its headers.
+func (p *oggPager) next() (pages []byte, fresh bool) {
+	i := 0
+	defer func() { p.pending = append(p.pending[:0:0], p.pending[i:]...) }()
+	for {
+		j := bytes.Index(p.pending[i:], oggCapture)
+		if j < 0 {
+			// keep what could be the start of a capture pattern
+			end := max(i, len(p.pending)-len(oggCapture)+1)
+			p.junk += int64(end - i)
+			i = end
+			return pages, fresh
+		}
+		p.junk += int64(j)
+		i += j
+		page, err := parseOggPage(p.pending[i:])
+		if errors.Is(err, errOggShort) {
+			return pages, fresh
+		}
+		if err != nil {
+			p.junk++
+			i++
+			continue
+		}
+		if page.bos && !p.inHeaders {
+			if len(pages) > 0 {
+				return pages, fresh // the new stream starts a run of its own
+			}
+			p.inHeaders, p.collecting = true, nil
+		}
+		raw := p.pending[i : i+page.size]
+		i += page.size
+		if p.inHeaders {
+			if page.granule == 0 || page.granule == -1 {
+				p.collecting = append(p.collecting, raw...)
+				continue
+			}
+			p.inHeaders = false
+			p.headers, p.collecting = p.collecting, nil
+			pages = append(pages, p.headers...)
+			fresh = true
+		}
+		pages = append(pages, raw...)
+	}
+}
+
+// pushAll writes data and returns all whole pages now available
+func (p *oggPager) pushAll(data []byte) []byte {
+	p.write(data)
+	var out []byte
+	for {
+		pages, _ := p.next()
+		if len(pages) == 0 {
+			return out
+		}
+		out = append(out, pages...)
+	}
+}
+
+// oggPageStart is the offset of the first page boundary at or after off (len(b) if none)
+func oggPageStart(b []byte, off int) int {
+	for off < len(b) {
+		j := bytes.Index(b[off:], oggCapture)
+		if j < 0 {
+			return len(b)
+		}
+		if _, err := parseOggPage(b[off+j:]); !errors.Is(err, errOggBad) {
+			return off + j
+		}
+		off += j + 1
+	}
+	return len(b)
+}
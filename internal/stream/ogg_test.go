@@ -0,0 +1,151 @@
+package stream
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// testOggPage builds an Ogg page carrying payload as one packet (CRC left zero: it isn't checked)
+func testOggPage(bos bool, granule int64, serial uint32, payload []byte) []byte {
+	p := append([]byte{}, oggCapture...)
+	flags := byte(0)
+	if bos {
+		flags |= oggFlagBOS
+	}
+	p = append(p, 0, flags)
+	p = binary.LittleEndian.AppendUint64(p, uint64(granule))
+	p = binary.LittleEndian.AppendUint32(p, serial)
+	p = binary.LittleEndian.AppendUint32(p, 0) // sequence
+	p = binary.LittleEndian.AppendUint32(p, 0) // crc
+	var segs []byte
+	n := len(payload)
+	for ; n >= 255; n -= 255 {
+		segs = append(segs, 255)
+	}
+	segs = append(segs, byte(n))
+	p = append(p, byte(len(segs)))
+	p = append(p, segs...)
+	return append(p, payload...)
+}
+
+// testOggStream is an Opus-like stream: two header pages, then audio pages
+func testOggStream(serial uint32, audioPages int) (headers, audio []byte) {
+	headers = append(testOggPage(true, 0, serial, []byte("OpusHead....")), testOggPage(false, 0, serial, []byte("OpusTags....."))...)
+	for i := 1; i <= audioPages; i++ {
+		audio = append(audio, testOggPage(false, int64(i*960), serial, bytes.Repeat([]byte{byte(i)}, 300))...)
+	}
+	return headers, audio
+}
+
+func TestOggPager(t *testing.T) {
+	headers, audio := testOggStream(1, 3)
+	headers2, audio2 := testOggStream(2, 2)
+
+	var p oggPager
+	// junk, then the headers alone: held back until the first audio page
+	p.write([]byte("junk"))
+	p.write(headers)
+	if pages, _ := p.next(); pages != nil {
+		t.Fatalf("got %d bytes before any audio page", len(pages))
+	}
+	// the audio, split mid-page
+	p.write(audio[:100])
+	if pages, _ := p.next(); pages != nil {
+		t.Fatalf("got %d bytes of an incomplete page", len(pages))
+	}
+	p.write(audio[100 : len(audio)/3+50])
+	pages, fresh := p.next()
+	if !fresh || !bytes.Equal(pages, append(append([]byte{}, headers...), audio[:len(audio)/3]...)) {
+		t.Fatalf("first run: fresh=%v %d bytes", fresh, len(pages))
+	}
+	// the rest, followed by a chained stream: two runs
+	p.write(audio[len(audio)/3+50:])
+	p.write(headers2)
+	p.write(audio2)
+	pages, fresh = p.next()
+	if fresh || !bytes.Equal(pages, audio[len(audio)/3:]) {
+		t.Fatalf("second run: fresh=%v %d bytes", fresh, len(pages))
+	}
+	pages, fresh = p.next()
+	if !fresh || !bytes.Equal(pages, append(append([]byte{}, headers2...), audio2...)) || !bytes.Equal(p.headers, headers2) {
+		t.Fatalf("chained stream: fresh=%v %d bytes", fresh, len(pages))
+	}
+	if p.junk != 4 {
+		t.Fatalf("junk = %d", p.junk)
+	}
+	if at := oggPageStart(audio, 10); at != len(audio)/3 {
+		t.Fatalf("oggPageStart = %d", at)
+	}
+}
+
+func TestCodecFromContentType(t *testing.T) {
+	for ct, want := range map[string]Codec{
+		"audio/mpeg":               CodecMP3,
+		"application/ogg":          CodecOgg,
+		"audio/ogg; codecs=opus":   CodecOgg,
+		"application/octet-stream": "",
+		"":                         "",
+	} {
+		if got, _ := codecFromContentType(ct); got != want {
+			t.Errorf("%q: %q, want %q", ct, got, want)
+		}
+	}
+}
+
+// listen connects a listener and returns its response; the body is read until cancel
+func listen(t *testing.T, s *Studio) (*http.Response, context.CancelFunc) {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(s.HandleListen))
+	t.Cleanup(srv.Close)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL, nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp, cancel
+}
+
+func TestOggListenerJoin(t *testing.T) {
+	// a one-byte burst: the listener joins on the last chunk written, mid-stream
+	s := newTestStudio(t, WithBurst(1, 0))
+	headers, audio := testOggStream(7, 4)
+	src := newTestSource(s, 1, nil)
+	src.codec = CodecOgg
+	s.addLiveSource(src)
+	s.liveData(src, append(append([]byte{}, headers...), audio[:len(audio)/2]...))
+	s.liveData(src, audio[len(audio)/2:])
+	waitFor(t, "the Ogg output", func() bool { return s.ring.buffered() == len(headers)+len(audio) })
+
+	resp, _ := listen(t, s)
+	if ct := resp.Header.Get("Content-Type"); ct != "audio/ogg" {
+		t.Fatalf("Content-Type = %q", ct)
+	}
+	last := audio[len(audio)/2:]
+	got := make([]byte, len(headers)+len(last))
+	if _, err := io.ReadFull(resp.Body, got); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, append(append([]byte{}, headers...), last...)) {
+		t.Fatalf("listener did not get the headers, then the last pages")
+	}
+
+	// an MP3 source takes over: the Ogg listener is closed, new listeners get MP3
+	mp3 := newTestSource(s, 2, nil)
+	s.addLiveSource(mp3)
+	s.liveData(mp3, testMP3Frames(testMP3Header, 3))
+	if _, err := io.Copy(io.Discard, resp.Body); err != nil {
+		t.Fatalf("listener not closed cleanly: %v", err)
+	}
+	resp, _ = listen(t, s)
+	if ct := resp.Header.Get("Content-Type"); ct != "audio/mpeg" {
+		t.Fatalf("Content-Type after the switch = %q", ct)
+	}
+}
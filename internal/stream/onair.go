@@ -0,0 +1,198 @@
+package stream
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// On-air state: what listeners hear, as decided by the distributor from the source of the frames
+// it sends out. Now playing, status and snapshots are all derived from it, and every transition is
+// kept (the latest ones) and published to subscribers.
+
+// OnAirState is what a studio is broadcasting
+type OnAirState string
+
+const (
+	OnAirLive     OnAirState = "live"     // a live encoder
+	OnAirRelay    OnAirState = "relay"    // a relayed upstream stream
+	OnAirAutoDJ   OnAirState = "autodj"   // the AutoDJ playlist
+	OnAirFallback OnAirState = "fallback" // the AutoDJ's fallback track (empty playlist)
+	OnAirSilence  OnAirState = "silence"  // nothing: keepalive silence
+	OnAirOff      OnAirState = "off"      // nothing, and no silence either (AAC or Ogg output)
+)
+
+const (
+	maxOnAirHistory     = 20
+	onAirSubscriberBuf  = 16
+	sourceRelayPrefix   = "relay-"
+	sourceLivePrefix    = "live-"
+	onAirEventKeepalive = 15 * time.Second
+)
+
+// OnAirEvent is a transition of a studio's on-air state
+type OnAirEvent struct {
+	StudioID string     `json:"studio_id"`
+	From     OnAirState `json:"from"`
+	To       OnAirState `json:"to"`
+	Source   string     `json:"source"` // the feed source now on air (live-3, autodj, ...)
+	At       time.Time  `json:"at"`
+}
+
+type onAirMachine struct {
+	mu      sync.Mutex
+	state   OnAirState
+	source  string
+	since   time.Time
+	history []OnAirEvent
+	subs    map[chan OnAirEvent]struct{}
+}
+
+func newOnAirMachine() *onAirMachine {
+	return &onAirMachine{
+		state: OnAirSilence,
+		since: time.Now().UTC(),
+		subs:  make(map[chan OnAirEvent]struct{}),
+	}
+}
+
+// set moves to state (fed by source) and returns the transition, or false if nothing changed
+func (m *onAirMachine) set(studioID string, state OnAirState, source string, at time.Time) (OnAirEvent, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if state == m.state && source == m.source {
+		return OnAirEvent{}, false
+	}
+	ev := OnAirEvent{StudioID: studioID, From: m.state, To: state, Source: source, At: at.UTC()}
+	m.state, m.source, m.since = state, source, ev.At
+	m.history = append(m.history, ev)
+	if over := len(m.history) - maxOnAirHistory; over > 0 {
+		m.history = append(m.history[:0:0], m.history[over:]...)
+	}
+	for ch := range m.subs {
+		select {
+		case ch <- ev:
+		default: // a slow subscriber misses events; the history still has them
+		}
+	}
+	return ev, true
+}
+
+func (m *onAirMachine) current() (OnAirState, string, time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.state, m.source, m.since
+}
+
+func (m *onAirMachine) transitions() []OnAirEvent {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]OnAirEvent(nil), m.history...)
+}
+
+// onAirStateOf maps a feed source to the state it puts the studio in
+func (s *Studio) onAirStateOf(source string) OnAirState {
+	switch {
+	case strings.HasPrefix(source, sourceLivePrefix):
+		return OnAirLive
+	case strings.HasPrefix(source, sourceRelayPrefix):
+		return OnAirRelay
+	case source == sourceOffAir:
+		return OnAirOff
+	case source == sourceAutoDJ:
+		if s.autoDJ != nil {
+			if cur, _, _, ok := s.autoDJ.NowPlaying(); ok && cur.Fallback {
+				return OnAirFallback
+			}
+		}
+		return OnAirAutoDJ
+	default:
+		return OnAirSilence
+	}
+}
+
+// updateOnAir is called by the distributor for the source of the frames it sends out
+func (s *Studio) updateOnAir(source string) {
+	if ev, ok := s.onAir.set(s.ID, s.onAirStateOf(source), source, time.Now()); ok {
+		log.Printf("Studio %s: on air %s -> %s (%s)", s.ID, ev.From, ev.To, ev.Source)
+	}
+}
+
+// OnAir returns what the studio is broadcasting, from which feed source, and since when
+func (s *Studio) OnAir() (OnAirState, string, time.Time) {
+	return s.onAir.current()
+}
+
+// OnAirTransitions returns the latest on-air transitions, oldest first
+func (s *Studio) OnAirTransitions() []OnAirEvent {
+	return s.onAir.transitions()
+}
+
+// SubscribeOnAir delivers the studio's on-air transitions until cancel is called.
+// Events are dropped for a subscriber that doesn't keep up.
+func (s *Studio) SubscribeOnAir() (events <-chan OnAirEvent, cancel func()) {
+	ch := make(chan OnAirEvent, onAirSubscriberBuf)
+	s.onAir.mu.Lock()
+	s.onAir.subs[ch] = struct{}{}
+	s.onAir.mu.Unlock()
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			s.onAir.mu.Lock()
+			delete(s.onAir.subs, ch)
+			s.onAir.mu.Unlock()
+		})
+	}
+}
+
+// HandleOnAirEvents streams on-air transitions as server-sent events, starting with the current state.
+//
+//	GET /studio/{id}/events
+func (s *Studio) HandleOnAirEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	events, cancel := s.SubscribeOnAir()
+	defer cancel()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	state, source, since := s.OnAir()
+	if !writeOnAirEvent(w, OnAirEvent{StudioID: s.ID, To: state, Source: source, At: since}) {
+		return
+	}
+	flusher.Flush()
+
+	ping := time.NewTicker(onAirEventKeepalive)
+	defer ping.Stop()
+	for {
+		select {
+		case ev := <-events:
+			if !writeOnAirEvent(w, ev) {
+				return
+			}
+		case <-ping.C:
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		case <-s.stop:
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+func writeOnAirEvent(w http.ResponseWriter, ev OnAirEvent) bool {
+	b, _ := json.Marshal(ev)
+	_, err := fmt.Fprintf(w, "event: onair\ndata: %s\n\n", b)
+	return err == nil
+}
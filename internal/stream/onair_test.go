@@ -0,0 +1,125 @@
+package stream
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// stubAutoDJ reports a fixed track as now playing
+type stubAutoDJ struct{ cur Track }
+
+func (d *stubAutoDJ) Play(ctx context.Context) { <-ctx.Done() }
+func (d *stubAutoDJ) Skip()                    {}
+func (d *stubAutoDJ) ForceReload()             {}
+func (d *stubAutoDJ) Stop()                    {}
+func (d *stubAutoDJ) Pause()                   {}
+func (d *stubAutoDJ) Resume()                  {}
+func (d *stubAutoDJ) NowPlaying() (Track, Track, time.Time, bool) {
+	return d.cur, Track{Title: "Up next"}, time.Now(), d.cur.File != ""
+}
+
+func TestOnAirStateOf(t *testing.T) {
+	s := newTestStudio(t)
+	dj := &stubAutoDJ{cur: Track{File: "a.mp3", Title: "Song"}}
+	s.autoDJ = dj
+	tests := []struct {
+		source   string
+		fallback bool
+		want     OnAirState
+	}{
+		{"live-3", false, OnAirLive},
+		{"relay-1", false, OnAirRelay},
+		{sourceAutoDJ, false, OnAirAutoDJ},
+		{sourceAutoDJ, true, OnAirFallback},
+		{sourceKeepalive, false, OnAirSilence},
+		{sourceOffAir, false, OnAirOff},
+	}
+	for _, tt := range tests {
+		dj.cur.Fallback = tt.fallback
+		if got := s.onAirStateOf(tt.source); got != tt.want {
+			t.Errorf("onAirStateOf(%q, fallback=%v) = %s, want %s", tt.source, tt.fallback, got, tt.want)
+		}
+	}
+}
+
+func TestOnAirTransitions(t *testing.T) {
+	s := newTestStudio(t)
+	if state, _, _ := s.OnAir(); state != OnAirSilence {
+		t.Fatalf("initial state %s, want silence", state)
+	}
+	events, cancel := s.SubscribeOnAir()
+	defer cancel()
+
+	// the distributor drives the state from the source of the frames it sends out
+	s.push(sourceAutoDJ, testMP3Frames(testMP3Header, 2))
+	s.push("live-1", testMP3Frames(testMP3Header, 2))
+	s.push("live-2", testMP3Frames(testMP3Header, 2))
+
+	want := []struct {
+		from, to OnAirState
+		source   string
+	}{
+		{OnAirSilence, OnAirAutoDJ, sourceAutoDJ},
+		{OnAirAutoDJ, OnAirLive, "live-1"},
+		{OnAirLive, OnAirLive, "live-2"}, // a switch between live sources is a transition too
+	}
+	for i, w := range want {
+		select {
+		case ev := <-events:
+			if ev.From != w.from || ev.To != w.to || ev.Source != w.source || ev.StudioID != s.ID || ev.At.IsZero() {
+				t.Fatalf("event %d = %+v, want %s -> %s (%s)", i, ev, w.from, w.to, w.source)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("event %d not delivered", i)
+		}
+	}
+	if got := s.OnAirTransitions(); len(got) != 3 || got[2].Source != "live-2" {
+		t.Fatalf("transitions = %+v", got)
+	}
+
+	np := s.nowPlaying()
+	if np.Source != string(OnAirLive) {
+		t.Fatalf("now playing source %q, want live", np.Source)
+	}
+
+	cancel()
+	s.push(sourceAutoDJ, testMP3Frames(testMP3Header, 2))
+	deadline := time.Now().Add(time.Second)
+	for state, _, _ := s.OnAir(); state != OnAirAutoDJ && time.Now().Before(deadline); state, _, _ = s.OnAir() {
+		time.Sleep(5 * time.Millisecond)
+	}
+	select {
+	case ev := <-events:
+		t.Fatalf("event %+v delivered after cancel", ev)
+	default:
+	}
+}
+
+func TestNowPlayingFollowsState(t *testing.T) {
+	s := newTestStudio(t)
+	s.autoDJ = &stubAutoDJ{cur: Track{File: "a.mp3", Title: "Song", Artist: "Band"}}
+	s.setLiveMeta(LiveMeta{Name: "Morning show", Title: "Live title", TitleUpdatedAt: time.Now()})
+
+	tests := []struct {
+		source      string
+		wantCurrent string
+		wantNext    string
+	}{
+		{sourceAutoDJ, "Song", "Up next"},
+		{"live-1", "Live title", ""}, // not the AutoDJ track during a live show
+		{sourceKeepalive, "", ""},
+	}
+	for _, tt := range tests {
+		s.onAir.set(s.ID, s.onAirStateOf(tt.source), tt.source, time.Now())
+		np := s.nowPlaying()
+		if np.Current != tt.wantCurrent || np.Next != tt.wantNext {
+			t.Errorf("%s: now playing %q / next %q, want %q / %q", tt.source, np.Current, np.Next, tt.wantCurrent, tt.wantNext)
+		}
+		s.buildSnapshot()
+		snap := s.Snapshot()
+		if snap.State != s.onAirStateOf(tt.source) || snap.Current != tt.wantCurrent || snap.LiveActive != (snap.State == OnAirLive) {
+			t.Errorf("%s: snapshot %+v", tt.source, snap)
+		}
+	}
+}
@@ -3,7 +3,9 @@ package stream
 import (
 	"encoding/json"
 	"net/http"
+	"os"
 	"path/filepath"
+	"strings"
 	"sync"
 	"time"
 )
@@ -15,6 +17,76 @@ type Track struct {
 	Artist      string
 	Album       string
 	DurationSec float64
+	Cover       *CoverArt // embedded art, only loaded for the playing track
+	Fallback    bool      // the fallback track, played while the playlist is empty
+}
+
+// withTags fills missing fields from the file's ID3 tags; the title falls back to the file name.
+// DurationSec is the length measured from the MPEG frames, or the TLEN tag if that fails.
+func (t Track) withTags(withCover bool) Track {
+	if t.File == "" {
+		return t
+	}
+	if d, err := trackDurations.get(t.File); err == nil && d > 0 {
+		t.DurationSec = d.Seconds()
+	}
+	if tags, err := ReadID3(t.File, withCover); err == nil {
+		setIfEmpty(&t.Title, tags.Title)
+		setIfEmpty(&t.Artist, tags.Artist)
+		setIfEmpty(&t.Album, tags.Album)
+		if t.DurationSec == 0 && tags.LengthMs > 0 {
+			t.DurationSec = float64(tags.LengthMs) / 1000
+		}
+		if tags.Cover != nil {
+			t.Cover = tags.Cover
+		}
+	}
+	if t.Title == "" {
+		base := filepath.Base(t.File)
+		t.Title = strings.TrimSuffix(base, filepath.Ext(base))
+	}
+	return t
+}
+
+// trackDurations caches audioDuration, which reads every frame of a file, by path: a file is only
+// measured again when its size or modification time changes
+var trackDurations = &durationCache{entries: make(map[string]cachedDuration)}
+
+const maxCachedDurations = 10000
+
+type cachedDuration struct {
+	size    int64
+	modTime time.Time
+	d       time.Duration
+}
+
+type durationCache struct {
+	mu      sync.Mutex
+	entries map[string]cachedDuration
+}
+
+func (c *durationCache) get(path string) (time.Duration, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return 0, err
+	}
+	c.mu.Lock()
+	e, ok := c.entries[path]
+	c.mu.Unlock()
+	if ok && e.size == fi.Size() && e.modTime.Equal(fi.ModTime()) {
+		return e.d, nil
+	}
+	d, err := audioDuration(path)
+	if err != nil {
+		return 0, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.entries) >= maxCachedDurations {
+		clear(c.entries)
+	}
+	c.entries[path] = cachedDuration{size: fi.Size(), modTime: fi.ModTime(), d: d}
+	return d, nil
 }
 
 type PlaylistSource interface {
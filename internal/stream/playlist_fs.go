@@ -0,0 +1,247 @@
+package stream
+
+import (
+	"io/fs"
+	"log"
+	"math"
+	"math/rand"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PlaylistMode selects the play order of a filesystem playlist
+type PlaylistMode string
+
+const (
+	PlaylistOrdered  PlaylistMode = "ordered"
+	PlaylistShuffle  PlaylistMode = "shuffle"
+	PlaylistWeighted PlaylistMode = "weighted"
+)
+
+// ParsePlaylistMode maps a config string to a PlaylistMode (default shuffle)
+func ParsePlaylistMode(s string) PlaylistMode {
+	switch PlaylistMode(strings.ToLower(s)) {
+	case PlaylistOrdered:
+		return PlaylistOrdered
+	case PlaylistWeighted, "weighted-shuffle", "weighted_shuffle":
+		return PlaylistWeighted
+	default:
+		return PlaylistShuffle
+	}
+}
+
+var playableExts = map[string]bool{
+	".mp3": true,
+	".aac": true, // ADTS
+}
+
+// fsPlaylist plays the audio files found under a directory (recursively), without any backend.
+// The directory is rescanned periodically; added files join the rotation and removed files drop out.
+type fsPlaylist struct {
+	mu       sync.RWMutex
+	dir      string
+	mode     PlaylistMode
+	weights  map[string]float64 // top-level sub-directory => weight (weighted mode), default 1
+	rescan   time.Duration
+	tracks   []Track // play order
+	idx      int
+	lastScan time.Time
+	rng      *rand.Rand
+}
+
+// NewFilesystemPlaylist creates a playlist over dir. In weighted mode, files in a top-level
+// sub-directory listed in weights are picked proportionally more (or less) often.
+func NewFilesystemPlaylist(dir string, mode PlaylistMode, weights map[string]float64, rescan time.Duration) PlaylistSource {
+	if rescan <= 0 {
+		rescan = time.Minute
+	}
+	return &fsPlaylist{
+		dir:     dir,
+		mode:    mode,
+		weights: weights,
+		rescan:  rescan,
+		idx:     -1,
+		rng:     rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+func (p *fsPlaylist) walk() map[string]Track {
+	found := make(map[string]Track)
+	err := filepath.WalkDir(p.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil // unreadable entries are skipped
+		}
+		if d.IsDir() {
+			if path != p.dir && strings.HasPrefix(d.Name(), ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !playableExts[strings.ToLower(filepath.Ext(path))] {
+			return nil
+		}
+		rel, _ := filepath.Rel(p.dir, path)
+		// titles come from the ID3 tags (or the file name) when the track is played
+		found[path] = Track{ID: filepath.ToSlash(rel), File: path}
+		return nil
+	})
+	if err != nil {
+		log.Printf("fsPlaylist: scan %s: %v", p.dir, err)
+	}
+	return found
+}
+
+// scan reconciles the play order with the files on disk, keeping the current position
+func (p *fsPlaylist) scan() {
+	found := p.walk()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.lastScan = time.Now()
+
+	var cur string
+	if p.idx >= 0 && p.idx < len(p.tracks) {
+		cur = p.tracks[p.idx].File
+	}
+
+	if p.mode == PlaylistOrdered {
+		all := make([]Track, 0, len(found))
+		for _, t := range found {
+			all = append(all, t)
+		}
+		sort.Slice(all, func(i, j int) bool { return all[i].File < all[j].File })
+		// continue after the current file even if it was removed
+		p.idx = sort.Search(len(all), func(i int) bool { return all[i].File >= cur }) - 1
+		if cur != "" && p.idx+1 < len(all) && all[p.idx+1].File == cur {
+			p.idx++
+		}
+		p.tracks = all
+		return
+	}
+
+	kept := make([]Track, 0, len(p.tracks))
+	newIdx := -1
+	for i, t := range p.tracks {
+		if _, ok := found[t.File]; !ok {
+			continue
+		}
+		delete(found, t.File)
+		kept = append(kept, t)
+		if i <= p.idx {
+			newIdx = len(kept) - 1
+		}
+	}
+	// (if the current file was removed, newIdx points at the track played before it)
+	if len(kept) == 0 {
+		// first scan (or everything replaced): start a fresh cycle
+		for _, t := range found {
+			kept = append(kept, t)
+		}
+		p.tracks = kept
+		p.idx = -1
+		p.reshuffle()
+		return
+	}
+	// new files are inserted at random positions in the part of the cycle not yet played
+	for _, t := range found {
+		pos := newIdx + 1 + p.rng.Intn(len(kept)-newIdx)
+		kept = append(kept, Track{})
+		copy(kept[pos+1:], kept[pos:])
+		kept[pos] = t
+	}
+	p.tracks = kept
+	p.idx = newIdx
+	if len(p.tracks) == 0 {
+		p.idx = -1
+	}
+}
+
+// reshuffle builds a new cycle; called with p.mu held
+func (p *fsPlaylist) reshuffle() {
+	if len(p.tracks) < 2 {
+		return
+	}
+	last := p.tracks[len(p.tracks)-1].File
+	switch p.mode {
+	case PlaylistShuffle:
+		p.rng.Shuffle(len(p.tracks), func(i, j int) { p.tracks[i], p.tracks[j] = p.tracks[j], p.tracks[i] })
+	case PlaylistWeighted:
+		// Efraimidis-Spirakis: sort by u^(1/w) descending, so heavier tracks tend to come first
+		keys := make(map[string]float64, len(p.tracks))
+		for _, t := range p.tracks {
+			keys[t.File] = math.Pow(p.rng.Float64(), 1/p.weight(t))
+		}
+		sort.Slice(p.tracks, func(i, j int) bool { return keys[p.tracks[i].File] > keys[p.tracks[j].File] })
+	}
+	// avoid playing the same file twice in a row across cycles
+	if p.tracks[0].File == last {
+		p.tracks[0], p.tracks[1] = p.tracks[1], p.tracks[0]
+	}
+}
+
+func (p *fsPlaylist) weight(t Track) float64 {
+	top := strings.SplitN(t.ID, "/", 2)[0]
+	if w, ok := p.weights[top]; ok && w > 0 {
+		return w
+	}
+	return 1
+}
+
+func (p *fsPlaylist) ensure() {
+	p.mu.RLock()
+	stale := p.lastScan.IsZero() || time.Since(p.lastScan) > p.rescan
+	p.mu.RUnlock()
+	if stale {
+		p.scan()
+	}
+}
+
+func (p *fsPlaylist) current() (Track, bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.idx < 0 || p.idx >= len(p.tracks) {
+		return Track{}, false
+	}
+	return p.tracks[p.idx], true
+}
+
+func (p *fsPlaylist) nextTrack() (Track, bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if len(p.tracks) == 0 {
+		return Track{}, false
+	}
+	n := p.idx + 1
+	if n >= len(p.tracks) {
+		// next cycle is reshuffled, so the next track isn't known yet in shuffle modes
+		if p.mode != PlaylistOrdered {
+			return Track{}, false
+		}
+		n = 0
+	}
+	return p.tracks[n], true
+}
+
+func (p *fsPlaylist) advance() (Track, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.tracks) == 0 {
+		p.idx = -1
+		return Track{}, false
+	}
+	p.idx++
+	if p.idx >= len(p.tracks) {
+		p.idx = 0
+		p.reshuffle()
+	}
+	return p.tracks[p.idx], true
+}
+
+func (p *fsPlaylist) forceReload() {
+	p.mu.Lock()
+	p.lastScan = time.Time{}
+	p.mu.Unlock()
+}
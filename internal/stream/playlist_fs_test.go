@@ -0,0 +1,158 @@
+package stream
+
+import (
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeTracks(t *testing.T, dir string, names ...string) {
+	t.Helper()
+	for _, name := range names {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// testPlaylist is a filesystem playlist over dir with a seeded RNG
+func testPlaylist(dir string, mode PlaylistMode, weights map[string]float64) *fsPlaylist {
+	p := NewFilesystemPlaylist(dir, mode, weights, time.Hour).(*fsPlaylist)
+	p.rng = rand.New(rand.NewSource(1))
+	return p
+}
+
+// playCycle advances through n tracks and returns their IDs
+func playCycle(t *testing.T, p *fsPlaylist, n int) []string {
+	t.Helper()
+	ids := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		tr, ok := p.advance()
+		if !ok {
+			t.Fatalf("no track after %d", i)
+		}
+		ids = append(ids, tr.ID)
+	}
+	return ids
+}
+
+func TestParsePlaylistMode(t *testing.T) {
+	for in, want := range map[string]PlaylistMode{
+		"ordered":          PlaylistOrdered,
+		"ORDERED":          PlaylistOrdered,
+		"weighted":         PlaylistWeighted,
+		"weighted-shuffle": PlaylistWeighted,
+		"shuffle":          PlaylistShuffle,
+		"":                 PlaylistShuffle,
+		"random":           PlaylistShuffle,
+	} {
+		if got := ParsePlaylistMode(in); got != want {
+			t.Errorf("%q: %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestFilesystemPlaylistOrdered(t *testing.T) {
+	dir := t.TempDir()
+	writeTracks(t, dir, "b.mp3", "a.MP3", "sub/c.aac", ".hidden/x.mp3", "notes.txt", "cover.jpg")
+	p := testPlaylist(dir, PlaylistOrdered, nil)
+	p.ensure()
+	if _, ok := p.current(); ok {
+		t.Fatal("a current track before the first advance")
+	}
+	if got := playCycle(t, p, 2); got[0] != "a.MP3" || got[1] != "b.mp3" {
+		t.Fatalf("order = %v", got)
+	}
+
+	// a file sorting after the current one comes next; removing the current one keeps the place
+	writeTracks(t, dir, "bb.mp3")
+	os.Remove(filepath.Join(dir, "b.mp3"))
+	p.scan()
+	if next, _ := p.nextTrack(); next.ID != "bb.mp3" {
+		t.Fatalf("next after a rescan = %q", next.ID)
+	}
+	if got := playCycle(t, p, 3); got[0] != "bb.mp3" || got[1] != "sub/c.aac" || got[2] != "a.MP3" {
+		t.Fatalf("order after a rescan = %v", got)
+	}
+}
+
+func TestFilesystemPlaylistShuffle(t *testing.T) {
+	dir := t.TempDir()
+	names := []string{"1.mp3", "2.mp3", "3.mp3", "4.mp3", "5.mp3", "6.mp3"}
+	writeTracks(t, dir, names...)
+	p := testPlaylist(dir, PlaylistShuffle, nil)
+	p.ensure()
+
+	last := ""
+	for cycle := 0; cycle < 20; cycle++ {
+		got := playCycle(t, p, len(names))
+		seen := map[string]bool{}
+		for _, id := range got {
+			seen[id] = true
+		}
+		if len(seen) != len(names) {
+			t.Fatalf("cycle %d = %v, not every track once", cycle, got)
+		}
+		if got[0] == last {
+			t.Fatalf("cycle %d starts with the track that ended the last one", cycle)
+		}
+		last = got[len(got)-1]
+		if _, ok := p.nextTrack(); ok {
+			t.Fatal("the next cycle's first track is known before it is shuffled")
+		}
+	}
+
+	// mid-cycle: a new file joins the unplayed part, a removed one drops out
+	played := playCycle(t, p, 3)
+	os.Remove(filepath.Join(dir, played[1]))
+	gone := p.tracks[p.idx+1].ID
+	os.Remove(filepath.Join(dir, gone))
+	unplayed := map[string]bool{}
+	for _, tr := range p.tracks[p.idx+2:] {
+		unplayed[tr.ID] = true
+	}
+	writeTracks(t, dir, "new.mp3")
+	unplayed["new.mp3"] = true
+	p.scan()
+	if cur, _ := p.current(); cur.ID != played[2] {
+		t.Fatalf("current = %q, want %q", cur.ID, played[2])
+	}
+	rest := playCycle(t, p, len(unplayed))
+	for _, id := range rest {
+		if !unplayed[id] {
+			t.Fatalf("rest of the cycle = %v, want %v", rest, unplayed)
+		}
+		delete(unplayed, id)
+	}
+	if len(p.tracks) != len(names)-1 {
+		t.Fatalf("%d tracks, want %d", len(p.tracks), len(names)-1)
+	}
+}
+
+func TestFilesystemPlaylistWeighted(t *testing.T) {
+	dir := t.TempDir()
+	writeTracks(t, dir, "jingles/j.mp3", "music/1.mp3", "music/2.mp3", "music/3.mp3", "music/4.mp3")
+	p := testPlaylist(dir, PlaylistWeighted, map[string]float64{"jingles": 20, "music": 0})
+	p.ensure()
+
+	if w := p.weight(Track{ID: "music/1.mp3"}); w != 1 {
+		t.Fatalf("a zero weight counts as %v", w)
+	}
+	// the heavy track leads most cycles: P = 20/24
+	first := 0
+	const cycles = 600
+	for i := 0; i < cycles; i++ {
+		if got := playCycle(t, p, 5); got[0] == "jingles/j.mp3" {
+			first++
+		}
+	}
+	if first < cycles*3/4 || first == cycles {
+		t.Fatalf("the heavy track led %d of %d cycles", first, cycles)
+	}
+}
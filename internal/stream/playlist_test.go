@@ -0,0 +1,37 @@
+package stream
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestTrackDurationCache(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "track.mp3")
+	if err := os.WriteFile(path, testMP3Frames(testMP3Header, 100), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	c := &durationCache{entries: make(map[string]cachedDuration)}
+	d, err := c.get(path)
+	if err != nil || d != 100*1152*time.Second/44100 {
+		t.Fatalf("duration %v, %v", d, err)
+	}
+	// an unchanged file is not measured again
+	e := c.entries[path]
+	e.d = time.Hour
+	c.entries[path] = e
+	if d, _ := c.get(path); d != time.Hour {
+		t.Fatalf("cached duration %v", d)
+	}
+	// a rewritten one is
+	if err := os.WriteFile(path, testMP3Frames(testMP3Header, 50), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if d, _ := c.get(path); d != 50*1152*time.Second/44100 {
+		t.Fatalf("duration after a rewrite %v", d)
+	}
+	if _, err := c.get(filepath.Join(t.TempDir(), "missing.mp3")); err == nil {
+		t.Fatal("no error for a missing file")
+	}
+}
@@ -0,0 +1,346 @@
+package stream
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Push targets: a studio can send its output to remote Icecast/SHOUTcast servers as a source
+// client, with the same SOURCE/PUT protocol HandleLiveIngest accepts. Each target streams from the
+// ring like a listener, gets title updates through the server's /admin/metadata, and reconnects with
+// the relays' backoff when the connection is lost.
+
+const (
+	pushDialTimeout   = 10 * time.Second
+	pushWriteTimeout  = 10 * time.Second
+	pushMetaInterval  = time.Second
+	pushMetaTimeout   = 10 * time.Second
+	pushStateConnect  = "connecting"
+	pushStateStream   = "connected"
+	pushStateRetrying = "retrying"
+)
+
+// PushTarget is a remote server the studio's output is sent to
+type PushTarget struct {
+	URL      string // http(s)://host:port/mount
+	User     string // defaults to "source"
+	Password string
+	Method   string // PUT (default, Icecast 2.4+) or SOURCE (older Icecast and SHOUTcast v2)
+
+	// Ice-* headers; empty fields default to the studio's station info
+	Name        string
+	Genre       string
+	Description string
+	StationURL  string
+	Public      bool
+}
+
+// WithPushTargets sends the studio's output to remote servers; they start with it
+func WithPushTargets(targets ...PushTarget) StudioOption {
+	return func(s *Studio) {
+		for _, t := range targets {
+			s.pushTargets = append(s.pushTargets, &pushTarget{cfg: t, status: PushStatus{URL: redactURL(t.URL), State: pushStateConnect}})
+		}
+	}
+}
+
+// PushStatus is the health of a push target, as reported in the studio status
+type PushStatus struct {
+	URL         string     `json:"url"`
+	State       string     `json:"state"` // connecting | connected | retrying
+	Since       *time.Time `json:"since,omitempty"`
+	Connects    int        `json:"connects"`
+	BytesSent   int64      `json:"bytes_sent"`
+	Title       string     `json:"title,omitempty"` // last title sent
+	LastError   string     `json:"last_error,omitempty"`
+	LastErrorAt *time.Time `json:"last_error_at,omitempty"`
+	RetryAt     *time.Time `json:"retry_at,omitempty"`
+}
+
+type pushTarget struct {
+	cfg PushTarget
+
+	mu     sync.Mutex
+	status PushStatus
+}
+
+func (t *pushTarget) update(f func(st *PushStatus)) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	f(&t.status)
+}
+
+func (t *pushTarget) snapshot() PushStatus {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.status
+}
+
+// pushStatuses lists the push targets' health
+func (s *Studio) pushStatuses() []PushStatus {
+	out := make([]PushStatus, 0, len(s.pushTargets))
+	for _, t := range s.pushTargets {
+		out = append(out, t.snapshot())
+	}
+	return out
+}
+
+// redactURL drops credentials from a target URL before it is logged or reported
+func redactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return raw
+	}
+	u.User = nil
+	return u.String()
+}
+
+// runPush keeps a push target connected until the studio closes
+func (s *Studio) runPush(t *pushTarget) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-s.stop
+		cancel()
+	}()
+
+	backoff := relayMinBackoff
+	for {
+		t.update(func(st *PushStatus) { st.State, st.Since, st.RetryAt = pushStateConnect, nil, nil })
+		started := time.Now()
+		err := s.pushOnce(ctx, t)
+		if ctx.Err() != nil {
+			return
+		}
+		if time.Since(started) >= relayStableAfter {
+			backoff = relayMinBackoff
+		}
+		now := time.Now().UTC()
+		retry := now.Add(backoff)
+		t.update(func(st *PushStatus) {
+			st.State, st.Since = pushStateRetrying, nil
+			st.LastError, st.LastErrorAt, st.RetryAt = err.Error(), &now, &retry
+		})
+		log.Printf("[push %s] %s: %v (reconnecting in %s)", s.ID, redactURL(t.cfg.URL), err, backoff)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return
+		}
+		backoff = min(backoff*2, relayMaxBackoff)
+	}
+}
+
+// pushOnce connects to the target and streams the studio's output until the connection fails
+func (s *Studio) pushOnce(ctx context.Context, t *pushTarget) error {
+	u, err := url.Parse(t.cfg.URL)
+	if err != nil {
+		return err
+	}
+	conn, err := dialPush(ctx, u)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	// closing the connection unblocks a write when the studio closes
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	cursor, format := s.ring.cursorBack(0)
+	if err := s.pushHandshake(conn, u, t.cfg, format.codec); err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	t.update(func(st *PushStatus) {
+		st.State, st.Since = pushStateStream, &now
+		st.Connects++
+		st.Title = ""
+	})
+	log.Printf("[push %s] connected: %s", s.ID, redactURL(t.cfg.URL))
+
+	meta := time.NewTicker(pushMetaInterval)
+	defer meta.Stop()
+	sentTitle := ""
+	s.pushMetadata(ctx, t, u, &sentTitle)
+
+	buf := make([]byte, 0, listenerWriteMax)
+	var sent *streamFormat
+	for {
+		res := s.ring.read(cursor, buf[:0], listenerWriteMax)
+		cursor = res.next
+		if res.skipped {
+			log.Printf("[push %s] %s fell behind, skipped to live edge", s.ID, redactURL(t.cfg.URL))
+		}
+		if len(res.data) == 0 {
+			if res.closed {
+				return errors.New("studio closed")
+			}
+			select {
+			case <-res.wait:
+			case <-meta.C:
+				s.pushMetadata(ctx, t, u, &sentTitle)
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			continue
+		}
+		_ = conn.SetWriteDeadline(time.Now().Add(pushWriteTimeout))
+		if res.format != sent {
+			// the server was told the codec in the handshake: a new one needs a new connection
+			if res.format.codec != format.codec {
+				return fmt.Errorf("output codec changed to %s", res.format.codec)
+			}
+			if pre := res.format.preamble(res.start); len(pre) > 0 {
+				if _, err := conn.Write(pre); err != nil {
+					return err
+				}
+			}
+			sent = res.format
+		}
+		n, err := conn.Write(res.data)
+		t.update(func(st *PushStatus) { st.BytesSent += int64(n) })
+		if err != nil {
+			return err
+		}
+		buf = res.data
+	}
+}
+
+func dialPush(ctx context.Context, u *url.URL) (net.Conn, error) {
+	host, port := u.Hostname(), u.Port()
+	switch u.Scheme {
+	case "http":
+		if port == "" {
+			port = "80"
+		}
+	case "https":
+		if port == "" {
+			port = "443"
+		}
+	default:
+		return nil, fmt.Errorf("unsupported scheme %q", u.Scheme)
+	}
+	d := &net.Dialer{Timeout: pushDialTimeout}
+	addr := net.JoinHostPort(host, port)
+	if u.Scheme == "https" {
+		td := &tls.Dialer{NetDialer: d, Config: &tls.Config{ServerName: host}}
+		return td.DialContext(ctx, "tcp", addr)
+	}
+	return d.DialContext(ctx, "tcp", addr)
+}
+
+// pushHandshake sends the source request and waits for the server to accept it
+func (s *Studio) pushHandshake(conn net.Conn, u *url.URL, cfg PushTarget, codec Codec) error {
+	method, proto := http.MethodPut, "HTTP/1.1"
+	if strings.EqualFold(cfg.Method, "SOURCE") {
+		method, proto = "SOURCE", "HTTP/1.0"
+	}
+	info := s.station
+	if cfg.Name != "" {
+		info.Name = cfg.Name
+	}
+	if cfg.Genre != "" {
+		info.Genre = cfg.Genre
+	}
+	if cfg.Description != "" {
+		info.Description = cfg.Description
+	}
+	if cfg.StationURL != "" {
+		info.URL = cfg.StationURL
+	}
+	user := cfg.User
+	if user == "" {
+		user = defaultSourceUser
+	}
+	public := "0"
+	if cfg.Public {
+		public = "1"
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s %s %s\r\n", method, u.EscapedPath(), proto)
+	h := http.Header{}
+	h.Set("Host", u.Host)
+	h.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(user+":"+cfg.Password)))
+	h.Set("User-Agent", "radio-studio push")
+	h.Set("Content-Type", codec.ContentType())
+	h.Set("Ice-Public", public)
+	h.Set("Ice-Bitrate", strconv.Itoa(s.bitrateKbps))
+	h.Set("Ice-Audio-Info", "bitrate="+strconv.Itoa(s.bitrateKbps))
+	for k, v := range map[string]string{"Ice-Name": info.Name, "Ice-Genre": info.Genre, "Ice-Description": info.Description, "Ice-Url": info.URL} {
+		if v != "" {
+			h.Set(k, v)
+		}
+	}
+	_ = h.Write(&b)
+	b.WriteString("\r\n")
+
+	_ = conn.SetDeadline(time.Now().Add(relayHeaderWait))
+	defer conn.SetDeadline(time.Time{})
+	if _, err := conn.Write([]byte(b.String())); err != nil {
+		return err
+	}
+	br := bufio.NewReader(conn)
+	for {
+		resp, err := http.ReadResponse(br, nil)
+		if err != nil {
+			return fmt.Errorf("reading the server's answer: %w", err)
+		}
+		if resp.StatusCode == http.StatusContinue {
+			continue
+		}
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("server answered %s", resp.Status)
+		}
+		return nil
+	}
+}
+
+var pushMetaClient = &http.Client{Timeout: pushMetaTimeout}
+
+// pushMetadata sends the title on air to the target when it changed since the last update
+func (s *Studio) pushMetadata(ctx context.Context, t *pushTarget, u *url.URL, sent *string) {
+	title := s.streamMeta().Title
+	if title == "" || title == *sent {
+		return
+	}
+	*sent = title
+	q := url.Values{"mount": {u.Path}, "mode": {"updinfo"}, "song": {title}}
+	admin := url.URL{Scheme: u.Scheme, Host: u.Host, Path: "/admin/metadata", RawQuery: q.Encode()}
+	user := t.cfg.User
+	if user == "" {
+		user = defaultSourceUser
+	}
+	// sent off the audio path: a slow admin interface must not hold up the stream
+	go func() {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, admin.String(), nil)
+		if err != nil {
+			return
+		}
+		req.SetBasicAuth(user, t.cfg.Password)
+		req.Header.Set("User-Agent", "radio-studio push")
+		resp, err := pushMetaClient.Do(req)
+		if err != nil {
+			log.Printf("[push %s] %s: metadata update failed: %v", s.ID, redactURL(t.cfg.URL), err)
+			return
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			log.Printf("[push %s] %s: metadata update answered %s", s.ID, redactURL(t.cfg.URL), resp.Status)
+			return
+		}
+		t.update(func(st *PushStatus) { st.Title = title })
+	}()
+}
@@ -0,0 +1,138 @@
+package stream
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// icecastServer accepts source connections like an Icecast server; the first one is dropped after
+// a few frames
+type icecastServer struct {
+	*httptest.Server
+	conns    atomic.Int32
+	received atomic.Int64
+	mu       sync.Mutex
+	requests []*http.Request
+	titles   []string
+}
+
+func newIcecastServer(t *testing.T) *icecastServer {
+	srv := &icecastServer{}
+	srv.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, ok := r.BasicAuth(); !ok || user != "source" || pass != "hackme" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if r.URL.Path == "/admin/metadata" {
+			if r.URL.Query().Get("mount") != "/radio" || r.URL.Query().Get("mode") != "updinfo" {
+				t.Errorf("metadata request %s", r.URL)
+			}
+			srv.mu.Lock()
+			srv.titles = append(srv.titles, r.URL.Query().Get("song"))
+			srv.mu.Unlock()
+			return
+		}
+		n := srv.conns.Add(1)
+		srv.mu.Lock()
+		srv.requests = append(srv.requests, r)
+		srv.mu.Unlock()
+		conn, rw, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			t.Errorf("hijack: %v", err)
+			return
+		}
+		defer conn.Close()
+		rw.WriteString("HTTP/1.0 200 OK\r\n\r\n")
+		rw.Flush()
+		var body io.Reader = rw
+		if n == 1 {
+			body = io.LimitReader(rw, 2000)
+		}
+		buf := make([]byte, 4096)
+		for {
+			n, err := body.Read(buf)
+			srv.received.Add(int64(n))
+			if err != nil {
+				return
+			}
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func (srv *icecastServer) lastTitle() string {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	if len(srv.titles) == 0 {
+		return ""
+	}
+	return srv.titles[len(srv.titles)-1]
+}
+
+// feedLive keeps a live source with a title on air until the test ends
+func feedLive(t *testing.T, s *Studio, title string) {
+	src := newTestSource(s, 1, nil)
+	s.addLiveSource(src)
+	s.setSourceTitle(src, title)
+	done := make(chan struct{})
+	t.Cleanup(func() { close(done) })
+	go func() {
+		frames := testMP3Frames(testMP3Header, 2)
+		for {
+			select {
+			case <-done:
+				return
+			case <-time.After(20 * time.Millisecond):
+				s.liveData(src, frames)
+			}
+		}
+	}()
+}
+
+func TestPushTarget(t *testing.T) {
+	srv := newIcecastServer(t)
+	s := newTestStudio(t,
+		WithStationInfo(StationInfo{Name: "Studio FM", Genre: "Talk"}),
+		WithPushTargets(PushTarget{URL: srv.URL + "/radio", Password: "hackme", Genre: "News", Public: true}))
+	feedLive(t, s, "DJ - Morning Show")
+
+	waitFor(t, "the title update", func() bool { return srv.lastTitle() == "DJ - Morning Show" })
+	srv.mu.Lock()
+	r := srv.requests[0]
+	srv.mu.Unlock()
+	if r.Method != http.MethodPut || r.URL.Path != "/radio" {
+		t.Fatalf("request = %s %s", r.Method, r.URL.Path)
+	}
+	if r.Header.Get("Ice-Name") != "Studio FM" || r.Header.Get("Ice-Genre") != "News" || r.Header.Get("Ice-Public") != "1" || r.Header.Get("Ice-Bitrate") != "128" {
+		t.Fatalf("headers = %v", r.Header)
+	}
+
+	// the first connection is dropped: the target reconnects after its backoff
+	waitFor(t, "a reconnection", func() bool { return srv.conns.Load() >= 2 })
+	waitFor(t, "audio on the new connection", func() bool {
+		st := s.pushStatuses()[0]
+		return st.State == pushStateStream && srv.received.Load() > 4000
+	})
+	st := s.pushStatuses()[0]
+	if st.Connects != 2 || st.LastError == "" || st.BytesSent < 4000 || strings.Contains(st.URL, "hackme") {
+		t.Fatalf("status = %+v", st)
+	}
+}
+
+func TestPushTargetRejected(t *testing.T) {
+	srv := newIcecastServer(t)
+	s := newTestStudio(t, WithPushTargets(PushTarget{URL: srv.URL + "/radio", Password: "wrong", Method: "SOURCE"}))
+
+	waitFor(t, "the failed attempt", func() bool { return s.pushStatuses()[0].State == pushStateRetrying })
+	st := s.pushStatuses()[0]
+	if st.Connects != 0 || !strings.Contains(st.LastError, "401") || st.RetryAt == nil {
+		t.Fatalf("status = %+v", st)
+	}
+}
@@ -0,0 +1,206 @@
+package stream
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Pull relays: a studio can rebroadcast an upstream HTTP/Icecast stream. A relay is one more
+// source in the live registry (feed source relay-N), so priorities, standby, stall and silence
+// handling apply to it as to an encoder. An always-on relay stays connected; a fallback relay only
+// connects while no local source is healthy, and never outranks one. Lost connections are retried
+// with exponential backoff.
+
+const (
+	relayMinBackoff   = time.Second
+	relayMaxBackoff   = time.Minute
+	relayStableAfter  = 30 * time.Second // a connection that lasted this long resets the backoff
+	relayPollInterval = time.Second      // how often a fallback relay checks whether it is needed
+	relayHeaderWait   = 15 * time.Second
+)
+
+// errRelayNotNeeded ends a fallback relay's connection once a local source is back
+var errRelayNotNeeded = errors.New("local source available")
+
+// RelayConfig is an upstream stream a studio rebroadcasts
+type RelayConfig struct {
+	URL      string
+	Name     string // shown in the sources list (defaults to the upstream's icy-name)
+	Priority int
+	Fallback bool // connect only while no local live source is available
+}
+
+// WithRelays adds pull relays to the studio; they start with it
+func WithRelays(relays ...RelayConfig) StudioOption {
+	return func(s *Studio) { s.relays = append(s.relays, relays...) }
+}
+
+var relayClient = &http.Client{
+	Transport: &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           (&net.Dialer{Timeout: 10 * time.Second}).DialContext,
+		ResponseHeaderTimeout: relayHeaderWait,
+	},
+}
+
+// nextRelaySource names a new relay connection
+func (s *Studio) nextRelaySource() string {
+	return sourceRelayPrefix + strconv.FormatUint(s.liveSessions.Add(1), 10)
+}
+
+// runRelay keeps a relay connected until the studio closes
+func (s *Studio) runRelay(cfg RelayConfig) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-s.stop
+		cancel()
+	}()
+
+	backoff := relayMinBackoff
+	for {
+		if cfg.Fallback {
+			for s.hasLocalSource() {
+				select {
+				case <-time.After(relayPollInterval):
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+		started := time.Now()
+		err := s.relayOnce(ctx, cfg)
+		if ctx.Err() != nil {
+			return
+		}
+		if errors.Is(err, errRelayNotNeeded) {
+			log.Printf("[relay %s] %s: %v, disconnecting", s.ID, cfg.URL, err)
+			backoff = relayMinBackoff
+			continue
+		}
+		if time.Since(started) >= relayStableAfter {
+			backoff = relayMinBackoff
+		}
+		log.Printf("[relay %s] %s: %v (reconnecting in %s)", s.ID, cfg.URL, err, backoff)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return
+		}
+		backoff = min(backoff*2, relayMaxBackoff)
+	}
+}
+
+// relayOnce connects to the upstream and feeds it to the studio until the connection ends
+func (s *Studio) relayOnce(ctx context.Context, cfg RelayConfig) error {
+	connCtx, disconnect := context.WithCancel(ctx)
+	defer disconnect()
+	// a read deadline for the response body: once it passes the request is cancelled
+	deadline := time.AfterFunc(s.liveReadTimeout, disconnect)
+	defer deadline.Stop()
+
+	req, err := http.NewRequestWithContext(connCtx, http.MethodGet, cfg.URL, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Icy-MetaData", "1")
+	req.Header.Set("User-Agent", "radio-studio relay")
+	resp, err := relayClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("upstream answered %s", resp.Status)
+	}
+
+	meta := LiveMeta{
+		Name:        resp.Header.Get("Icy-Name"),
+		Genre:       resp.Header.Get("Icy-Genre"),
+		Description: resp.Header.Get("Icy-Description"),
+		URL:         resp.Header.Get("Icy-Url"),
+		Bitrate:     resp.Header.Get("Icy-Br"),
+		Source:      "relay:" + cfg.URL,
+		UpdatedAt:   time.Now().UTC(),
+	}
+	if cfg.Name != "" {
+		meta.Name = cfg.Name
+	}
+	remote := cfg.URL
+	if u, err := url.Parse(cfg.URL); err == nil {
+		remote = u.Host
+	}
+	src := &liveSource{
+		id:          s.nextRelaySource(),
+		principal:   "relay",
+		priority:    cfg.Priority,
+		remote:      remote,
+		connectedAt: time.Now().UTC(),
+		meta:        meta,
+		fallback:    cfg.Fallback,
+		setReadDeadline: func(t time.Time) error {
+			deadline.Reset(time.Until(t))
+			return nil
+		},
+	}
+
+	src.codec, _ = codecFromContentType(resp.Header.Get("Content-Type"))
+
+	var body io.Reader = resp.Body
+	if metaInt, _ := strconv.Atoi(resp.Header.Get("Icy-Metaint")); metaInt > 0 {
+		body = newICYReader(resp.Body, metaInt, func(title string) { s.setSourceTitle(src, title) })
+	}
+
+	onAir := s.addLiveSource(src)
+	defer s.removeLiveSource(src)
+	log.Printf("[relay %s] connected: id=%s url=%s priority=%d fallback=%v on_air=%v name=%q", s.ID, src.id, cfg.URL, cfg.Priority, cfg.Fallback, onAir, meta.Name)
+
+	buf := make([]byte, 8192)
+	for {
+		_ = src.setReadDeadline(time.Now().Add(s.liveReadTimeout))
+		if src.kicked.Load() {
+			return errors.New("kicked")
+		}
+		n, err := body.Read(buf)
+		if n > 0 {
+			chunk := make([]byte, n)
+			copy(chunk, buf[:n])
+			s.liveData(src, chunk)
+		}
+		if err != nil {
+			if src.kicked.Load() {
+				return errors.New("kicked")
+			}
+			if connCtx.Err() != nil && ctx.Err() == nil {
+				return fmt.Errorf("no data for %s", s.liveReadTimeout)
+			}
+			if errors.Is(err, io.EOF) {
+				return errors.New("upstream closed the stream")
+			}
+			return err
+		}
+		if cfg.Fallback && s.hasLocalSource() {
+			return errRelayNotNeeded
+		}
+	}
+}
+
+// hasLocalSource reports whether a healthy source other than a fallback relay is connected
+func (s *Studio) hasLocalSource() bool {
+	s.liveMu.RLock()
+	defer s.liveMu.RUnlock()
+	for _, src := range s.liveSources {
+		if !src.fallback && src.incident == nil {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,98 @@
+package stream
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// icyUpstream serves MP3 frames with ICY metadata; the first connection ends after a few frames
+func icyUpstream(t *testing.T) (*httptest.Server, *atomic.Int32) {
+	var conns atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := conns.Add(1)
+		if r.Header.Get("Icy-MetaData") != "1" {
+			t.Errorf("relay did not ask for ICY metadata")
+		}
+		w.Header().Set("Content-Type", "audio/mpeg")
+		w.Header().Set("icy-name", "Partner FM")
+		w.Header().Set("icy-metaint", "1000")
+		iw := newICYWriter(w, 1000, func() icyMeta { return icyMeta{Title: "Partner - Song"} })
+		frames := testMP3Frames(testMP3Header, 5)
+		for i := 0; n > 1 || i < 4; i++ {
+			if _, err := iw.Write(frames); err != nil {
+				return
+			}
+			w.(http.Flusher).Flush()
+			select {
+			case <-r.Context().Done():
+				return
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &conns
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestRelayFeedsStudio(t *testing.T) {
+	upstream, conns := icyUpstream(t)
+	s := newTestStudio(t, WithRelays(RelayConfig{URL: upstream.URL, Priority: 1}))
+
+	waitFor(t, "the relay on air", func() bool {
+		state, _, _ := s.OnAir()
+		return state == OnAirRelay
+	})
+	waitFor(t, "the upstream title", func() bool { return s.nowPlaying().Current == "Partner - Song" })
+
+	// the first connection ends: the relay reconnects after its backoff
+	waitFor(t, "a reconnection", func() bool { return conns.Load() >= 2 })
+	waitFor(t, "the relay registered again", func() bool {
+		v := s.listLiveSources()
+		return len(v) == 1 && v[0].OnAir && v[0].Name == "Partner FM" && v[0].Principal == "relay"
+	})
+
+	// metadata blocks are stripped: only whole frames reach the ring
+	if junk := s.ring.buffered() % 417; junk != 0 {
+		t.Fatalf("ring holds %d bytes beyond whole frames", junk)
+	}
+}
+
+func TestRelayFallbackRanking(t *testing.T) {
+	s := newTestStudio(t)
+
+	relay := newTestSource(s, 100, nil)
+	relay.fallback = true
+	s.addLiveSource(relay)
+	if got := onAirID(t, s); got != relay.id {
+		t.Fatalf("fallback relay not on air when alone")
+	}
+	if s.hasLocalSource() {
+		t.Fatalf("a fallback relay counts as a local source")
+	}
+
+	local := newTestSource(s, 0, nil)
+	if !s.addLiveSource(local) {
+		t.Fatalf("a local source must outrank a fallback relay whatever their priorities")
+	}
+	if !s.hasLocalSource() {
+		t.Fatalf("local source not seen")
+	}
+	s.removeLiveSource(local)
+	if got := onAirID(t, s); got != relay.id {
+		t.Fatalf("on air = %q, want the fallback relay back", got)
+	}
+}
@@ -0,0 +1,144 @@
+package stream
+
+import "sync"
+
+// audioRing is the studio's shared output buffer. The distributor appends frame-aligned chunks;
+// every listener reads at its own cursor (a chunk sequence number), so a chunk is stored once
+// no matter how many listeners there are. Memory is bounded by maxBytes. Each chunk records the
+// stream format it was written in; a read never spans two formats.
+type audioRing struct {
+	mu       sync.RWMutex
+	slots    []ringSlot
+	head     uint64 // sequence of the next chunk to be written
+	tail     uint64 // oldest sequence still held
+	bytes    int
+	maxBytes int
+	notify   chan struct{} // closed (and replaced) on every write
+	closed   bool
+	format   *streamFormat // of the chunks written from now on
+}
+
+type ringSlot struct {
+	data   []byte
+	format *streamFormat
+}
+
+func newAudioRing(maxBytes, maxSlots int) *audioRing {
+	return &audioRing{
+		slots:    make([]ringSlot, maxSlots),
+		maxBytes: maxBytes,
+		notify:   make(chan struct{}),
+		format:   &streamFormat{codec: CodecMP3},
+	}
+}
+
+// setFormat sets the format of the chunks written next; the first of them starts the format
+func (r *audioRing) setFormat(f *streamFormat) {
+	r.mu.Lock()
+	f.start = r.head
+	r.format = f
+	r.mu.Unlock()
+}
+
+// currentFormat returns the format chunks are being written in
+func (r *audioRing) currentFormat() *streamFormat {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.format
+}
+
+// write appends one chunk; it must start on a frame boundary. The ring keeps a reference to b.
+func (r *audioRing) write(b []byte) {
+	if len(b) == 0 {
+		return
+	}
+	r.mu.Lock()
+	if r.closed {
+		r.mu.Unlock()
+		return
+	}
+	n := uint64(len(r.slots))
+	for r.head > r.tail && (r.head-r.tail >= n || r.bytes+len(b) > r.maxBytes) {
+		old := &r.slots[r.tail%n]
+		r.bytes -= len(old.data)
+		*old = ringSlot{}
+		r.tail++
+	}
+	r.slots[r.head%n] = ringSlot{data: b, format: r.format}
+	r.head++
+	r.bytes += len(b)
+	close(r.notify)
+	r.notify = make(chan struct{})
+	r.mu.Unlock()
+}
+
+func (r *audioRing) close() {
+	r.mu.Lock()
+	if !r.closed {
+		r.closed = true
+		close(r.notify)
+	}
+	r.mu.Unlock()
+}
+
+// cursorBack returns a cursor that replays at least burst bytes of the most recent audio (if held),
+// without going back past the start of the current format, and that format
+func (r *audioRing) cursorBack(burst int) (uint64, *streamFormat) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	n := uint64(len(r.slots))
+	seq, acc := r.head, 0
+	for seq > r.tail && acc < burst && r.slots[(seq-1)%n].format == r.format {
+		seq--
+		acc += len(r.slots[seq%n].data)
+	}
+	return seq, r.format
+}
+
+// buffered returns the number of bytes currently held
+func (r *audioRing) buffered() int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.bytes
+}
+
+// ringRead is the result of one read
+type ringRead struct {
+	data    []byte
+	format  *streamFormat // of data
+	start   uint64        // cursor data was read from
+	next    uint64
+	skipped bool            // cursor had fallen out of the ring and was moved to the live edge
+	wait    <-chan struct{} // set when no data is available yet
+	closed  bool
+}
+
+// read appends chunks starting at cursor to dst, up to max bytes (at least one chunk if any is
+// available) and stopping where the format changes
+func (r *audioRing) read(cursor uint64, dst []byte, max int) ringRead {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	res := ringRead{}
+	if cursor < r.tail || cursor > r.head {
+		cursor = r.head
+		res.skipped = true
+	}
+	n := uint64(len(r.slots))
+	res.start = cursor
+	for cursor < r.head {
+		slot := &r.slots[cursor%n]
+		if len(dst) > 0 && (len(dst)+len(slot.data) > max || slot.format != res.format) {
+			break
+		}
+		res.format = slot.format
+		dst = append(dst, slot.data...)
+		cursor++
+	}
+	res.data = dst
+	res.next = cursor
+	if len(dst) == 0 {
+		res.closed = r.closed
+		res.wait = r.notify
+	}
+	return res
+}
@@ -0,0 +1,140 @@
+package stream
+
+import (
+	"bytes"
+	"testing"
+)
+
+func chunk(n int, b byte) []byte {
+	return bytes.Repeat([]byte{b}, n)
+}
+
+func TestAudioRingEviction(t *testing.T) {
+	tests := []struct {
+		name         string
+		maxBytes     int
+		maxSlots     int
+		writes       []int
+		wantTail     uint64
+		wantHead     uint64
+		wantBuffered int
+	}{
+		{"fits", 100, 8, []int{10, 20, 30}, 0, 3, 60},
+		{"exactly max bytes", 60, 8, []int{10, 20, 30}, 0, 3, 60},
+		{"evict by bytes", 100, 8, []int{40, 40, 40, 40}, 2, 4, 80},
+		{"evict by slots", 1000, 2, []int{10, 10, 10}, 1, 3, 20},
+		{"oversized chunk kept alone", 50, 8, []int{10, 80}, 1, 2, 80},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newAudioRing(tt.maxBytes, tt.maxSlots)
+			for i, n := range tt.writes {
+				r.write(chunk(n, byte(i)))
+			}
+			if r.tail != tt.wantTail || r.head != tt.wantHead {
+				t.Fatalf("tail,head = %d,%d want %d,%d", r.tail, r.head, tt.wantTail, tt.wantHead)
+			}
+			if got := r.buffered(); got != tt.wantBuffered {
+				t.Fatalf("buffered = %d, want %d", got, tt.wantBuffered)
+			}
+		})
+	}
+}
+
+func TestAudioRingCursorBack(t *testing.T) {
+	r := newAudioRing(1000, 8)
+	for i, n := range []int{10, 20, 30} {
+		r.write(chunk(n, byte(i)))
+	}
+	tests := []struct {
+		burst int
+		want  uint64
+	}{
+		{0, 3},
+		{1, 2},
+		{30, 2},
+		{31, 1},
+		{50, 1},
+		{60, 0},
+		{1000, 0},
+	}
+	for _, tt := range tests {
+		if got, _ := r.cursorBack(tt.burst); got != tt.want {
+			t.Errorf("cursorBack(%d) = %d, want %d", tt.burst, got, tt.want)
+		}
+	}
+
+	// after eviction the burst can't reach before the tail
+	r.write(chunk(990, 9))
+	if got, _ := r.cursorBack(1 << 20); got != r.tail {
+		t.Errorf("cursorBack after eviction = %d, want tail %d", got, r.tail)
+	}
+}
+
+func TestAudioRingRead(t *testing.T) {
+	r := newAudioRing(1000, 8)
+	r.write(chunk(10, 'a'))
+	r.write(chunk(20, 'b'))
+	r.write(chunk(30, 'c'))
+
+	tests := []struct {
+		name        string
+		cursor      uint64
+		max         int
+		wantData    string
+		wantNext    uint64
+		wantSkipped bool
+	}{
+		{"all", 0, 100, string(chunk(10, 'a')) + string(chunk(20, 'b')) + string(chunk(30, 'c')), 3, false},
+		{"stops before max", 0, 35, string(chunk(10, 'a')) + string(chunk(20, 'b')), 2, false},
+		{"one chunk even if over max", 2, 5, string(chunk(30, 'c')), 3, false},
+		{"at head", 3, 100, "", 3, false},
+		{"ahead of head skips to live edge", 7, 100, "", 3, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := r.read(tt.cursor, nil, tt.max)
+			if string(res.data) != tt.wantData || res.next != tt.wantNext || res.skipped != tt.wantSkipped {
+				t.Fatalf("read = %d bytes next=%d skipped=%v, want %d bytes next=%d skipped=%v",
+					len(res.data), res.next, res.skipped, len(tt.wantData), tt.wantNext, tt.wantSkipped)
+			}
+			if (len(res.data) == 0) != (res.wait != nil) {
+				t.Fatalf("wait channel should be set exactly when no data is returned")
+			}
+		})
+	}
+
+	// a cursor that fell behind the tail moves to the live edge
+	lag := newAudioRing(1000, 2)
+	for i := 0; i < 5; i++ {
+		lag.write(chunk(10, byte(i)))
+	}
+	res := lag.read(0, nil, 100)
+	if !res.skipped || res.next != lag.head || len(res.data) != 0 {
+		t.Fatalf("lagging read: skipped=%v next=%d (head %d) data=%d", res.skipped, res.next, lag.head, len(res.data))
+	}
+}
+
+func TestAudioRingWaitAndClose(t *testing.T) {
+	r := newAudioRing(1000, 8)
+	res := r.read(0, nil, 100)
+	if res.wait == nil {
+		t.Fatalf("empty ring should return a wait channel")
+	}
+	r.write(chunk(10, 'a'))
+	select {
+	case <-res.wait:
+	default:
+		t.Fatalf("write should wake readers")
+	}
+
+	r.close()
+	res = r.read(1, nil, 100)
+	if !res.closed {
+		t.Fatalf("read at head of a closed ring should report closed")
+	}
+	r.write(chunk(10, 'b'))
+	if r.head != 1 {
+		t.Fatalf("write after close was accepted")
+	}
+}
@@ -0,0 +1,242 @@
+package stream
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SHOUTcast sources: legacy playout systems (SAM Broadcaster, RadioBOSS, ...) speak the SHOUTcast
+// v1 source protocol rather than Icecast's HTTP. They connect to the port after the server's, send
+// the password on a line, wait for OK2, send icy-* header lines and a blank line, then raw audio;
+// titles go to /admin.cgi on the server's port. The server's port also plays the stream, as
+// SHOUTcast's does. Only v1 is spoken: SHOUTcast v2 sources (Ultravox 2.1 framing) are turned away
+// and must be set to the legacy v1 mode, where DNAS 2's "password:#sid" form is accepted.
+
+const (
+	shoutcastHeaderWait = 15 * time.Second
+	shoutcastMaxHeaders = 64
+	shoutcastMaxLine    = 4096
+
+	ultravoxSync = 0x5A // first byte of every Ultravox message
+)
+
+// WithShoutcastSource accepts SHOUTcast sources for the studio: addr (host:port) serves
+// /admin.cgi and listeners, the port after it takes sources
+func WithShoutcastSource(addr string) StudioOption {
+	return func(s *Studio) { s.shoutcastAddr = addr }
+}
+
+// startShoutcast opens the studio's SHOUTcast ports; they close with the studio
+func (s *Studio) startShoutcast() {
+	host, p, err := net.SplitHostPort(s.shoutcastAddr)
+	port, perr := strconv.Atoi(p)
+	if err != nil || perr != nil || port <= 0 || port >= 65535 {
+		log.Printf("[live %s] SHOUTcast: invalid address %q", s.ID, s.shoutcastAddr)
+		return
+	}
+	srcLn, err := net.Listen("tcp", net.JoinHostPort(host, strconv.Itoa(port+1)))
+	if err != nil {
+		log.Printf("[live %s] SHOUTcast: %v", s.ID, err)
+		return
+	}
+	baseLn, err := net.Listen("tcp", s.shoutcastAddr)
+	if err != nil {
+		srcLn.Close()
+		log.Printf("[live %s] SHOUTcast: %v", s.ID, err)
+		return
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/admin.cgi", s.HandleShoutcastAdmin)
+	mux.HandleFunc("/", s.HandleListen)
+	base := &http.Server{Handler: mux, ReadHeaderTimeout: shoutcastHeaderWait}
+
+	go s.serveShoutcastSources(srcLn)
+	go base.Serve(baseLn)
+	go func() {
+		<-s.stop
+		srcLn.Close()
+		base.Close()
+	}()
+	log.Printf("[live %s] SHOUTcast: sources on %s, admin and listeners on %s", s.ID, srcLn.Addr(), baseLn.Addr())
+}
+
+func (s *Studio) serveShoutcastSources(ln net.Listener) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			log.Printf("[live %s] SHOUTcast accept: %v", s.ID, err)
+			time.Sleep(100 * time.Millisecond)
+			continue
+		}
+		go s.handleShoutcastSource(conn)
+	}
+}
+
+// shoutcastLogin turns a SHOUTcast password into a source login checkSource understands: a stream
+// key, a "user:password" DJ login, or else the password of the "source" account.
+// Stream IDs (password:#sid, from encoders set up for DNAS 2) are ignored: the studio is picked
+// by the port.
+func shoutcastLogin(password, remoteAddr string) *http.Request {
+	if i := strings.Index(password, ":#"); i >= 0 {
+		password = password[:i]
+	}
+	r := &http.Request{Header: http.Header{}, URL: &url.URL{}, RemoteAddr: remoteAddr}
+	if strings.HasPrefix(password, streamKeyPrefix) {
+		r.URL.RawQuery = url.Values{"key": {password}}.Encode()
+		return r
+	}
+	user, pass, ok := strings.Cut(password, ":")
+	if !ok {
+		user, pass = defaultSourceUser, password
+	}
+	r.SetBasicAuth(user, pass)
+	return r
+}
+
+func readShoutcastLine(br *bufio.Reader) (string, error) {
+	line, err := br.ReadSlice('\n')
+	if errors.Is(err, bufio.ErrBufferFull) || len(line) > shoutcastMaxLine {
+		return "", errors.New("line too long")
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(line), "\r\n"), nil
+}
+
+// isUltravox reports whether a source opens with an Ultravox message (0x5A, then a zero byte)
+// rather than a v1 password line. A line needs at least one byte more than its first, so looking
+// at the second never waits on a v1 source.
+func isUltravox(br *bufio.Reader) bool {
+	if b, err := br.Peek(1); err != nil || b[0] != ultravoxSync {
+		return false
+	}
+	b, err := br.Peek(2)
+	return err == nil && b[1] == 0
+}
+
+// handleShoutcastSource runs the SHOUTcast source handshake and feeds the audio that follows
+func (s *Studio) handleShoutcastSource(conn net.Conn) {
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(shoutcastHeaderWait))
+	br := bufio.NewReaderSize(conn, shoutcastMaxLine)
+
+	if isUltravox(br) {
+		log.Printf("[live %s] SHOUTcast %s: v2 (Ultravox) source refused; set the encoder to SHOUTcast v1 (legacy)", s.ID, conn.RemoteAddr())
+		return
+	}
+	password, err := readShoutcastLine(br)
+	if err != nil {
+		log.Printf("[live %s] SHOUTcast %s: reading password: %v", s.ID, conn.RemoteAddr(), err)
+		return
+	}
+	login := shoutcastLogin(password, conn.RemoteAddr().String())
+	principal, fail := s.checkSource(login)
+	if fail != nil {
+		_, _ = conn.Write([]byte("invalid password\r\n"))
+		return
+	}
+	if _, err := conn.Write([]byte("OK2\r\nicy-caps:11\r\n\r\n")); err != nil {
+		return
+	}
+
+	hdr := http.Header{}
+	for i := 0; ; i++ {
+		line, err := readShoutcastLine(br)
+		if err != nil {
+			log.Printf("[live %s] SHOUTcast %s: reading headers: %v", s.ID, conn.RemoteAddr(), err)
+			return
+		}
+		if line == "" {
+			break
+		}
+		if i == shoutcastMaxHeaders {
+			log.Printf("[live %s] SHOUTcast %s: too many headers", s.ID, conn.RemoteAddr())
+			return
+		}
+		if k, v, ok := strings.Cut(line, ":"); ok {
+			hdr.Add(strings.TrimSpace(k), strings.TrimSpace(v))
+		}
+	}
+	_ = conn.SetDeadline(time.Time{})
+
+	meta := LiveMeta{
+		Name:        hdr.Get("Icy-Name"),
+		Genre:       hdr.Get("Icy-Genre"),
+		Description: hdr.Get("Icy-Description"),
+		URL:         hdr.Get("Icy-Url"),
+		Bitrate:     hdr.Get("Icy-Br"),
+		Public:      hdr.Get("Icy-Pub"),
+		Source:      principal,
+		RawHeaders:  map[string]string{},
+		UpdatedAt:   time.Now().UTC(),
+	}
+	for k, v := range hdr {
+		if strings.HasPrefix(strings.ToLower(k), "icy-") {
+			meta.RawHeaders[k] = strings.Join(v, ", ")
+		}
+	}
+	src := &liveSource{
+		id:          s.nextLiveSource(),
+		principal:   principal,
+		priority:    s.sourcePriority(login, principal),
+		remote:      s.sourceIP(login),
+		connectedAt: time.Now().UTC(),
+		meta:        meta,
+
+		setReadDeadline: conn.SetReadDeadline,
+	}
+	src.codec, _ = codecFromContentType(hdr.Get("Content-Type"))
+	onAir := s.addLiveSource(src)
+	log.Printf("[live %s] connected: id=%s method=SHOUTcast source=%s priority=%d on_air=%v name=%q bitrate=%s", s.ID, src.id, principal, src.priority, onAir, meta.Name, meta.Bitrate)
+
+	// br holds whatever audio arrived with the headers
+	s.readLiveSource(src, br, liveEarlyEOFGrace, "SHOUTcast")
+	s.removeLiveSource(src)
+	log.Printf("[live %s] %s ended", s.ID, src.id)
+}
+
+// HandleShoutcastAdmin implements SHOUTcast's title update, on the studio's SHOUTcast port:
+//
+//	GET /admin.cgi?pass=...&mode=updinfo&song=Artist%20-%20Title
+//
+// pass takes the same forms as the source password; without it, Basic auth is used.
+func (s *Studio) HandleShoutcastAdmin(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("mode") != "updinfo" {
+		http.Error(w, "Unsupported mode", http.StatusBadRequest)
+		return
+	}
+	login := r
+	if pass := q.Get("pass"); pass != "" {
+		login = shoutcastLogin(pass, r.RemoteAddr)
+		login.Header.Set("X-Forwarded-For", r.Header.Get("X-Forwarded-For"))
+	}
+	principal, fail := s.checkSource(login)
+	if fail != nil {
+		for k, v := range fail.Header {
+			w.Header()[k] = v
+		}
+		http.Error(w, fail.Message, fail.Status)
+		return
+	}
+	song := strings.TrimSpace(decodeMetaCharset(q.Get("song"), q.Get("charset")))
+	if err := s.setLiveTitle(principal, song); err != nil {
+		http.Error(w, err.Error(), titleErrorStatus(err))
+		return
+	}
+	log.Printf("[live %s] metadata updated song=%q", s.ID, song)
+	w.Header().Set("Content-Type", "text/html")
+	fmt.Fprint(w, "<html><body>Update successful</body></html>")
+}
@@ -0,0 +1,109 @@
+package stream
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestShoutcastLogin(t *testing.T) {
+	tests := []struct {
+		password, user, pass, key string
+	}{
+		{"hackme", "source", "hackme", ""},
+		{"hackme:#2", "source", "hackme", ""},
+		{"dj:secret", "dj", "secret", ""},
+		{"dj:secret:#1", "dj", "secret", ""},
+		{"sk_abc", "", "", "sk_abc"},
+	}
+	for _, tt := range tests {
+		r := shoutcastLogin(tt.password, "10.0.0.1:5000")
+		user, pass, _ := r.BasicAuth()
+		if user != tt.user || pass != tt.pass || r.URL.Query().Get("key") != tt.key {
+			t.Errorf("%q: user=%q pass=%q key=%q", tt.password, user, pass, r.URL.Query().Get("key"))
+		}
+	}
+}
+
+func shoutcastConnect(t *testing.T, addr, password string) (net.Conn, *bufio.Reader) {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	if _, err := io.WriteString(conn, password+"\r\n"); err != nil {
+		t.Fatal(err)
+	}
+	return conn, bufio.NewReader(conn)
+}
+
+func TestShoutcastSource(t *testing.T) {
+	hash, err := HashSourcePassword("hackme")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newTestStudio(t, WithSourceAccounts(SourceAccount{User: "source", PasswordHash: hash}))
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go s.serveShoutcastSources(ln)
+
+	_, br := shoutcastConnect(t, ln.Addr().String(), "wrong")
+	if line, _ := br.ReadString('\n'); line != "invalid password\r\n" {
+		t.Fatalf("wrong password answered %q", line)
+	}
+
+	// an Ultravox 2.1 authentication message is refused without an answer
+	conn, br := shoutcastConnect(t, ln.Addr().String(), "\x5a\x00\x10\x01\x00\x10hackme")
+	if b, err := br.ReadByte(); err != io.EOF {
+		t.Fatalf("Ultravox source answered %q, %v", b, err)
+	}
+	conn.Close()
+
+	conn, br = shoutcastConnect(t, ln.Addr().String(), "hackme:#1")
+	for _, want := range []string{"OK2\r\n", "icy-caps:11\r\n", "\r\n"} {
+		if line, _ := br.ReadString('\n'); line != want {
+			t.Fatalf("handshake line %q, want %q", line, want)
+		}
+	}
+	// audio may follow the headers in the same packet
+	frames := testMP3Frames(testMP3Header, 3)
+	if _, err := io.WriteString(conn, "icy-name:SAM Live\r\nicy-genre:Talk\r\nicy-br:128\r\nicy-pub:1\r\n\r\n"+string(frames)); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the source on air", func() bool {
+		state, _, _ := s.OnAir()
+		return state == OnAirLive
+	})
+	v := s.listLiveSources()
+	if len(v) != 1 || v[0].Name != "SAM Live" || v[0].Principal != "user:source" {
+		t.Fatalf("sources = %+v", v)
+	}
+
+	rec := httptest.NewRecorder()
+	q := url.Values{"pass": {"hackme"}, "mode": {"updinfo"}, "song": {"Artist - Title"}}
+	s.HandleShoutcastAdmin(rec, httptest.NewRequest(http.MethodGet, "/admin.cgi?"+q.Encode(), nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("admin.cgi answered %d: %s", rec.Code, rec.Body)
+	}
+	if got := s.nowPlaying().Current; got != "Artist - Title" {
+		t.Fatalf("now playing %q", got)
+	}
+
+	rec = httptest.NewRecorder()
+	q.Set("pass", "wrong")
+	s.HandleShoutcastAdmin(rec, httptest.NewRequest(http.MethodGet, "/admin.cgi?"+q.Encode(), nil))
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("admin.cgi with a wrong password answered %d", rec.Code)
+	}
+
+	conn.Close()
+	waitFor(t, "the source gone", func() bool { return len(s.listLiveSources()) == 0 })
+}
@@ -0,0 +1,485 @@
+package stream
+
+import (
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// SourceAccount is a user allowed to push audio into a studio.
+// PasswordHash is produced by HashSourcePassword; plain passwords are never stored.
+type SourceAccount struct {
+	User         string `json:"user"`
+	PasswordHash string `json:"password_hash"`
+	Priority     int    `json:"priority,omitempty"` // higher takes over from lower when both are connected
+}
+
+// StreamKey is an opaque secret that can be used instead of basic auth:
+// /studio/{id}/live?key=...
+// Only the SHA-256 of the secret is kept; the secret itself is returned once on issue/rotate.
+type StreamKey struct {
+	ID        string     `json:"id"`
+	Label     string     `json:"label,omitempty"`
+	Prefix    string     `json:"prefix"`
+	Hash      string     `json:"hash"`
+	Priority  int        `json:"priority,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+	RotatedAt *time.Time `json:"rotated_at,omitempty"`
+}
+
+// streamKeyView is what the keys API exposes (never the hash)
+type streamKeyView struct {
+	ID        string     `json:"id"`
+	Label     string     `json:"label,omitempty"`
+	Prefix    string     `json:"prefix"`
+	Priority  int        `json:"priority"`
+	CreatedAt time.Time  `json:"created_at"`
+	RotatedAt *time.Time `json:"rotated_at,omitempty"`
+	Secret    string     `json:"secret,omitempty"`
+}
+
+const (
+	passwordHashScheme = "pbkdf2-sha256"
+	passwordHashIter   = 120000
+	streamKeyPrefix    = "sk_"
+	defaultSourceUser  = "source" // the login of encoders that only send a password (SOURCE, SHOUTcast)
+)
+
+var (
+	errMissingAuth     = errors.New("missing auth")
+	errInvalidCreds    = errors.New("invalid credentials")
+	errUnknownKey      = errors.New("unknown stream key")
+	errStreamKeyAbsent = errors.New("stream key not found")
+)
+
+// HashSourcePassword returns a salted PBKDF2 hash suitable for SourceAccount.PasswordHash.
+// Format: pbkdf2-sha256$<iterations>$<base64 salt>$<base64 key>
+func HashSourcePassword(password string) (string, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	dk, err := pbkdf2.Key(sha256.New, password, salt, passwordHashIter, 32)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s$%d$%s$%s", passwordHashScheme, passwordHashIter,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(dk)), nil
+}
+
+func verifySourcePassword(encoded, password string) bool {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 4 || parts[0] != passwordHashScheme {
+		return false
+	}
+	iter, err := strconv.Atoi(parts[1])
+	if err != nil || iter <= 0 {
+		return false
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return false
+	}
+	want, err := base64.RawStdEncoding.DecodeString(parts[3])
+	if err != nil || len(want) == 0 {
+		return false
+	}
+	got, err := pbkdf2.Key(sha256.New, password, salt, iter, len(want))
+	if err != nil {
+		return false
+	}
+	return subtle.ConstantTimeCompare(got, want) == 1
+}
+
+func hashStreamKey(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+func newStreamKeySecret() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return streamKeyPrefix + base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// sourceAuth holds the credentials a studio accepts for live sources.
+// Accounts come from config and (optionally) the backend; stream keys are managed through the keys API.
+type sourceAuth struct {
+	mu       sync.RWMutex
+	accounts map[string]SourceAccount // static (config)
+	remote   map[string]SourceAccount // fetched from backend
+	keys     map[string]*StreamKey
+	keysFile string
+
+	// backend accounts; fetchMu lets a single login refresh them while the others wait
+	fetchMu   sync.Mutex
+	endpoint  string
+	apiKey    string
+	lastFetch time.Time
+	ttl       time.Duration
+	client    *http.Client
+}
+
+func newSourceAuth() *sourceAuth {
+	return &sourceAuth{
+		accounts: make(map[string]SourceAccount),
+		remote:   make(map[string]SourceAccount),
+		keys:     make(map[string]*StreamKey),
+		ttl:      time.Minute,
+		client:   &http.Client{Timeout: 5 * time.Second},
+	}
+}
+
+func (a *sourceAuth) addAccount(acc SourceAccount) {
+	a.mu.Lock()
+	a.accounts[acc.User] = acc
+	a.mu.Unlock()
+}
+
+type backendSources struct {
+	Accounts []SourceAccount `json:"accounts"`
+}
+
+// fetch refreshes the backend account list; on failure the previous list is kept.
+func (a *sourceAuth) fetch() error {
+	req, err := http.NewRequest("GET", a.endpoint, nil)
+	if err != nil {
+		return err
+	}
+	if a.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+a.apiKey)
+	}
+	res, err := a.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("status %d", res.StatusCode)
+	}
+	var bs backendSources
+	if err := json.NewDecoder(res.Body).Decode(&bs); err != nil {
+		return err
+	}
+	out := make(map[string]SourceAccount, len(bs.Accounts))
+	for _, acc := range bs.Accounts {
+		if acc.User == "" || acc.PasswordHash == "" {
+			continue
+		}
+		out[acc.User] = acc
+	}
+	a.mu.Lock()
+	a.remote = out
+	a.mu.Unlock()
+	return nil
+}
+
+// ensure refreshes backend accounts once per ttl. A failed fetch also counts, so a backend
+// outage costs one request per ttl instead of one per login.
+func (a *sourceAuth) ensure() {
+	if a.endpoint == "" {
+		return
+	}
+	a.fetchMu.Lock()
+	defer a.fetchMu.Unlock()
+	a.mu.RLock()
+	stale := time.Since(a.lastFetch) > a.ttl
+	a.mu.RUnlock()
+	if !stale {
+		return
+	}
+	err := a.fetch()
+	a.mu.Lock()
+	a.lastFetch = time.Now()
+	cached := len(a.remote)
+	a.mu.Unlock()
+	if err != nil {
+		log.Printf("sourceAuth: fetch %s: %v (keeping %d cached accounts)", a.endpoint, err, cached)
+	}
+}
+
+// checkPassword validates user/password against config and backend accounts.
+func (a *sourceAuth) checkPassword(user, pass string) error {
+	a.ensure()
+	a.mu.RLock()
+	acc, ok := a.accounts[user]
+	if !ok {
+		acc, ok = a.remote[user]
+	}
+	a.mu.RUnlock()
+	if !ok || !verifySourcePassword(acc.PasswordHash, pass) {
+		return errInvalidCreds
+	}
+	return nil
+}
+
+// checkKey returns the ID of the stream key matching secret.
+func (a *sourceAuth) checkKey(secret string) (string, error) {
+	if !strings.HasPrefix(secret, streamKeyPrefix) {
+		return "", errUnknownKey
+	}
+	h := []byte(hashStreamKey(secret))
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	for _, k := range a.keys {
+		if subtle.ConstantTimeCompare(h, []byte(k.Hash)) == 1 {
+			return k.ID, nil
+		}
+	}
+	return "", errUnknownKey
+}
+
+// check authenticates a source request, either by ?key= or by Basic auth.
+// The returned principal identifies the account ("user:<name>" or "key:<id>") for logs and metadata.
+func (a *sourceAuth) check(r *http.Request) (string, error) {
+	if key := r.URL.Query().Get("key"); key != "" {
+		id, err := a.checkKey(key)
+		if err != nil {
+			return "", err
+		}
+		return "key:" + id, nil
+	}
+	user, pass, ok := r.BasicAuth()
+	if !ok {
+		if r.Header.Get("Authorization") == "" {
+			return "", errMissingAuth
+		}
+		return "", errors.New("invalid auth header")
+	}
+	if err := a.checkPassword(user, pass); err != nil {
+		return "", err
+	}
+	return "user:" + user, nil
+}
+
+// priority returns the configured priority of an authenticated principal (0 if unknown)
+func (a *sourceAuth) priority(principal string) int {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	if id, ok := strings.CutPrefix(principal, "key:"); ok {
+		if k, ok := a.keys[id]; ok {
+			return k.Priority
+		}
+		return 0
+	}
+	user := strings.TrimPrefix(principal, "user:")
+	if acc, ok := a.accounts[user]; ok {
+		return acc.Priority
+	}
+	return a.remote[user].Priority
+}
+
+func (a *sourceAuth) listKeys() []streamKeyView {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	out := make([]streamKeyView, 0, len(a.keys))
+	for _, k := range a.keys {
+		out = append(out, streamKeyView{ID: k.ID, Label: k.Label, Prefix: k.Prefix, Priority: k.Priority, CreatedAt: k.CreatedAt, RotatedAt: k.RotatedAt})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].CreatedAt.Before(out[j].CreatedAt) })
+	return out
+}
+
+func (a *sourceAuth) issueKey(label string, priority int) (streamKeyView, error) {
+	secret, err := newStreamKeySecret()
+	if err != nil {
+		return streamKeyView{}, err
+	}
+	k := &StreamKey{
+		ID:        uuid.NewString()[:8],
+		Label:     label,
+		Prefix:    secret[:len(streamKeyPrefix)+4],
+		Hash:      hashStreamKey(secret),
+		Priority:  priority,
+		CreatedAt: time.Now().UTC(),
+	}
+	a.mu.Lock()
+	a.keys[k.ID] = k
+	a.mu.Unlock()
+	a.saveKeys()
+	return streamKeyView{ID: k.ID, Label: k.Label, Prefix: k.Prefix, Priority: k.Priority, CreatedAt: k.CreatedAt, Secret: secret}, nil
+}
+
+// rotateKey replaces the secret of an existing key; the old secret stops working immediately.
+func (a *sourceAuth) rotateKey(id string) (streamKeyView, error) {
+	secret, err := newStreamKeySecret()
+	if err != nil {
+		return streamKeyView{}, err
+	}
+	now := time.Now().UTC()
+	a.mu.Lock()
+	k, ok := a.keys[id]
+	if !ok {
+		a.mu.Unlock()
+		return streamKeyView{}, errStreamKeyAbsent
+	}
+	k.Hash = hashStreamKey(secret)
+	k.Prefix = secret[:len(streamKeyPrefix)+4]
+	k.RotatedAt = &now
+	view := streamKeyView{ID: k.ID, Label: k.Label, Prefix: k.Prefix, Priority: k.Priority, CreatedAt: k.CreatedAt, RotatedAt: k.RotatedAt, Secret: secret}
+	a.mu.Unlock()
+	a.saveKeys()
+	return view, nil
+}
+
+func (a *sourceAuth) revokeKey(id string) error {
+	a.mu.Lock()
+	if _, ok := a.keys[id]; !ok {
+		a.mu.Unlock()
+		return errStreamKeyAbsent
+	}
+	delete(a.keys, id)
+	a.mu.Unlock()
+	a.saveKeys()
+	return nil
+}
+
+func (a *sourceAuth) loadKeys() {
+	if a.keysFile == "" {
+		return
+	}
+	b, err := os.ReadFile(a.keysFile)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			log.Printf("sourceAuth: read %s: %v", a.keysFile, err)
+		}
+		return
+	}
+	var keys []*StreamKey
+	if err := json.Unmarshal(b, &keys); err != nil {
+		log.Printf("sourceAuth: parse %s: %v", a.keysFile, err)
+		return
+	}
+	a.mu.Lock()
+	for _, k := range keys {
+		a.keys[k.ID] = k
+	}
+	a.mu.Unlock()
+}
+
+func (a *sourceAuth) saveKeys() {
+	if a.keysFile == "" {
+		return
+	}
+	a.mu.RLock()
+	keys := make([]*StreamKey, 0, len(a.keys))
+	for _, k := range a.keys {
+		keys = append(keys, k)
+	}
+	b, err := json.MarshalIndent(keys, "", "  ")
+	a.mu.RUnlock()
+	if err != nil {
+		return
+	}
+	tmp := a.keysFile + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o600); err != nil {
+		log.Printf("sourceAuth: write %s: %v", tmp, err)
+		return
+	}
+	if err := os.Rename(tmp, a.keysFile); err != nil {
+		log.Printf("sourceAuth: rename %s: %v", a.keysFile, err)
+	}
+}
+
+// authLimiter blocks an IP after too many failed source logins within a window.
+type authLimiter struct {
+	mu      sync.Mutex
+	max     int
+	window  time.Duration
+	lockout time.Duration
+	entries map[string]*authAttempts
+}
+
+type authAttempts struct {
+	failures     int
+	first        time.Time
+	blockedUntil time.Time
+}
+
+func newAuthLimiter(max int, window, lockout time.Duration) *authLimiter {
+	return &authLimiter{
+		max:     max,
+		window:  window,
+		lockout: lockout,
+		entries: make(map[string]*authAttempts),
+	}
+}
+
+// allow reports whether ip may attempt to authenticate; if not, how long until it may retry.
+func (l *authLimiter) allow(ip string) (bool, time.Duration) {
+	if l == nil || l.max <= 0 {
+		return true, 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	e, ok := l.entries[ip]
+	if !ok {
+		return true, 0
+	}
+	if wait := time.Until(e.blockedUntil); wait > 0 {
+		return false, wait
+	}
+	return true, 0
+}
+
+// fail records a failed attempt and returns true if the IP is now blocked.
+func (l *authLimiter) fail(ip string) bool {
+	if l == nil || l.max <= 0 {
+		return false
+	}
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.entries) > 1024 {
+		l.prune(now)
+	}
+	e, ok := l.entries[ip]
+	if !ok || now.Sub(e.first) > l.window {
+		e = &authAttempts{first: now}
+		l.entries[ip] = e
+	}
+	e.failures++
+	if e.failures >= l.max {
+		e.blockedUntil = now.Add(l.lockout)
+		e.failures = 0
+		e.first = now
+		return true
+	}
+	return false
+}
+
+func (l *authLimiter) reset(ip string) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	delete(l.entries, ip)
+	l.mu.Unlock()
+}
+
+func (l *authLimiter) prune(now time.Time) {
+	for ip, e := range l.entries {
+		if now.Sub(e.first) > l.window && now.After(e.blockedUntil) {
+			delete(l.entries, ip)
+		}
+	}
+}
@@ -0,0 +1,237 @@
+package stream
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ivugurura/radio-studio/internal/netutil"
+)
+
+func TestSourcePasswordHash(t *testing.T) {
+	hash, err := HashSourcePassword("hackme")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, _ := HashSourcePassword("hackme")
+	if hash == other {
+		t.Fatal("two hashes of one password share a salt")
+	}
+	tests := []struct {
+		name     string
+		encoded  string
+		password string
+		want     bool
+	}{
+		{"good password", hash, "hackme", true},
+		{"bad password", hash, "hackm3", false},
+		{"empty password", hash, "", false},
+		{"plain text", "hackme", "hackme", false},
+		{"other scheme", "bcrypt$10$c2FsdA$a2V5", "hackme", false},
+		{"missing key", "pbkdf2-sha256$1000$c2FsdA", "hackme", false},
+		{"empty key", "pbkdf2-sha256$1000$c2FsdA$", "hackme", false},
+		{"bad iterations", "pbkdf2-sha256$-1$c2FsdA$a2V5", "hackme", false},
+		{"bad salt", "pbkdf2-sha256$1000$!!$a2V5", "hackme", false},
+		{"empty", "", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := verifySourcePassword(tt.encoded, tt.password); got != tt.want {
+				t.Fatalf("verify = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func keyRequest(secret string) *http.Request {
+	return httptest.NewRequest(http.MethodPut, "/studio/test/live?key="+secret, nil)
+}
+
+func TestStreamKeys(t *testing.T) {
+	a := newSourceAuth()
+	a.keysFile = filepath.Join(t.TempDir(), "keys.json")
+
+	issued, err := a.issueKey("morning show", 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	principal, err := a.check(keyRequest(issued.Secret))
+	if err != nil || principal != "key:"+issued.ID {
+		t.Fatalf("issued key: %q, %v", principal, err)
+	}
+	if p := a.priority(principal); p != 5 {
+		t.Fatalf("priority = %d", p)
+	}
+	for _, k := range a.listKeys() {
+		if k.Secret != "" {
+			t.Fatal("the keys list exposes a secret")
+		}
+	}
+
+	rotated, err := a.rotateKey(issued.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.check(keyRequest(issued.Secret)); !errors.Is(err, errUnknownKey) {
+		t.Fatalf("old secret after rotation: %v", err)
+	}
+	if principal, err := a.check(keyRequest(rotated.Secret)); err != nil || principal != "key:"+issued.ID {
+		t.Fatalf("rotated key: %q, %v", principal, err)
+	}
+	if rotated.RotatedAt == nil || rotated.Prefix == issued.Prefix {
+		t.Fatalf("rotated view = %+v", rotated)
+	}
+
+	// keys survive a restart, as hashes only
+	loaded := newSourceAuth()
+	loaded.keysFile = a.keysFile
+	loaded.loadKeys()
+	if _, err := loaded.checkKey(rotated.Secret); err != nil {
+		t.Fatalf("persisted key: %v", err)
+	}
+
+	if err := a.revokeKey(issued.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.check(keyRequest(rotated.Secret)); !errors.Is(err, errUnknownKey) {
+		t.Fatalf("revoked key: %v", err)
+	}
+	if _, err := a.rotateKey(issued.ID); !errors.Is(err, errStreamKeyAbsent) {
+		t.Fatalf("rotating a revoked key: %v", err)
+	}
+	if err := a.revokeKey(issued.ID); !errors.Is(err, errStreamKeyAbsent) {
+		t.Fatalf("revoking twice: %v", err)
+	}
+	if _, err := a.check(keyRequest("not-a-key")); !errors.Is(err, errUnknownKey) {
+		t.Fatalf("malformed key: %v", err)
+	}
+}
+
+func TestAuthLimiter(t *testing.T) {
+	l := newAuthLimiter(3, time.Minute, time.Hour)
+	const ip = "192.0.2.1"
+	for i := 0; i < 2; i++ {
+		if l.fail(ip) {
+			t.Fatalf("blocked after %d failures", i+1)
+		}
+	}
+	if ok, _ := l.allow(ip); !ok {
+		t.Fatal("blocked below the limit")
+	}
+	// failures outside the window start a new count
+	l.entries[ip].first = time.Now().Add(-2 * time.Minute)
+	if l.fail(ip) || l.fail(ip) {
+		t.Fatal("failures from an old window counted")
+	}
+	if !l.fail(ip) {
+		t.Fatal("not blocked at the limit")
+	}
+	if ok, wait := l.allow(ip); ok || wait <= 59*time.Minute {
+		t.Fatalf("allow = %v, retry in %v", ok, wait)
+	}
+	if ok, _ := l.allow("192.0.2.2"); !ok {
+		t.Fatal("another address is blocked")
+	}
+	l.reset(ip)
+	if ok, _ := l.allow(ip); !ok {
+		t.Fatal("still blocked after a reset")
+	}
+	if ok, _ := (*authLimiter)(nil).allow(ip); !ok {
+		t.Fatal("a nil limiter blocks")
+	}
+}
+
+func TestAuthLimiterKeyedOnPeer(t *testing.T) {
+	proxies, _ := netutil.ParseCIDRs("10.0.0.0/8")
+	hash, _ := HashSourcePassword("hackme")
+	s := newTestStudio(t,
+		WithSourceAccounts(SourceAccount{User: "dj", PasswordHash: hash}),
+		WithAuthLimit(2, time.Minute, time.Hour),
+		WithTrustedProxies(proxies...))
+
+	login := func(remote, xff, password string) int {
+		r := httptest.NewRequest(http.MethodPut, "/studio/test/live", nil)
+		r.RemoteAddr = remote
+		if xff != "" {
+			r.Header.Set("X-Forwarded-For", xff)
+		}
+		r.SetBasicAuth("dj", password)
+		_, fail := s.checkSource(r)
+		if fail != nil {
+			return fail.Status
+		}
+		return http.StatusOK
+	}
+	// a direct client can't spread its failures over forged addresses
+	login("203.0.113.9:4000", "198.51.100.1", "wrong")
+	login("203.0.113.9:4000", "198.51.100.2", "wrong")
+	if got := login("203.0.113.9:4000", "198.51.100.3", "hackme"); got != http.StatusTooManyRequests {
+		t.Fatalf("forged X-Forwarded-For escaped the lockout: %d", got)
+	}
+	// behind a trusted proxy each client is counted on its own
+	login("10.0.0.1:4000", "198.51.100.1", "wrong")
+	login("10.0.0.1:4000", "198.51.100.1", "wrong")
+	if got := login("10.0.0.1:4000", "198.51.100.1", "hackme"); got != http.StatusTooManyRequests {
+		t.Fatalf("locked out client through the proxy: %d", got)
+	}
+	if got := login("10.0.0.1:4000", "198.51.100.2", "hackme"); got != http.StatusOK {
+		t.Fatalf("another client through the proxy: %d", got)
+	}
+}
+
+func TestSourceBackendFetch(t *testing.T) {
+	hash, _ := HashSourcePassword("hackme")
+	var requests atomic.Int32
+	var failing atomic.Bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if r.Header.Get("Authorization") != "Bearer api-key" {
+			t.Errorf("Authorization = %q", r.Header.Get("Authorization"))
+		}
+		if failing.Load() {
+			http.Error(w, "down", http.StatusBadGateway)
+			return
+		}
+		fmt.Fprintf(w, `{"accounts":[{"user":"remote","password_hash":%q,"priority":2},{"user":"","password_hash":"x"}]}`, hash)
+	}))
+	defer srv.Close()
+
+	a := newSourceAuth()
+	a.endpoint, a.apiKey = srv.URL, "api-key"
+	tests := []struct {
+		name         string
+		fail         bool
+		expire       bool // the cache is older than the ttl
+		password     string
+		wantErr      error
+		wantRequests int32
+	}{
+		{"first login fetches", false, false, "hackme", nil, 1},
+		{"cached within the ttl", false, false, "wrong", errInvalidCreds, 1},
+		{"refetched after the ttl", false, true, "hackme", nil, 2},
+		{"failed fetch keeps the accounts", true, true, "hackme", nil, 3},
+		{"failed fetch is cached too", true, false, "hackme", nil, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			failing.Store(tt.fail)
+			if tt.expire {
+				a.lastFetch = time.Now().Add(-2 * a.ttl)
+			}
+			if err := a.checkPassword("remote", tt.password); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("login: %v, want %v", err, tt.wantErr)
+			}
+			if got := requests.Load(); got != tt.wantRequests {
+				t.Fatalf("backend requests = %d, want %d", got, tt.wantRequests)
+			}
+		})
+	}
+	if p := a.priority("user:remote"); p != 2 {
+		t.Fatalf("priority = %d", p)
+	}
+}
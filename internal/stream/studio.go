@@ -2,9 +2,12 @@ package stream
 
 import (
 	"context"
+	"encoding/binary"
 	"io"
 	"log"
+	"net"
 	"net/http"
+	"strconv"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -17,34 +20,119 @@ import (
 
 type NowPlayingResponse struct {
 	StudioID   string    `json:"studio_id"`
+	Source     string    `json:"source,omitempty"` // on-air state: live | relay | autodj | fallback | silence
 	Current    string    `json:"current"`
+	Artist     string    `json:"artist,omitempty"`
+	Album      string    `json:"album,omitempty"`
+	CoverURL   string    `json:"cover_url,omitempty"`
 	Next       string    `json:"next,omitempty"`
 	StartedAt  time.Time `json:"started_at"`
 	ElapsedSec float64   `json:"elapsed_sec"`
 }
 
 type StudioSnapshot struct {
-	GeneratedAt time.Time      `json:"generated_at"`
-	StudioID    string         `json:"studio_id"`
-	Active      int            `json:"active"`
-	Countries   map[string]int `json:"countries"`
-	ClientTypes map[string]int `json:"client_types"`
-	BytesTotal  int64          `json:"bytes_total"`
-	LiveActive  bool           `json:"live_active"`
-	Current     string         `json:"current"`
-	Next        string         `json:"next"`
+	GeneratedAt time.Time          `json:"generated_at"`
+	StudioID    string             `json:"studio_id"`
+	Active      int                `json:"active"`
+	Countries   map[string]int     `json:"countries"`
+	ClientTypes map[string]int     `json:"client_types"`
+	Mounts      map[string]int     `json:"mounts"` // active listeners per mount ("main" is /listen)
+	BytesTotal  int64              `json:"bytes_total"`
+	LiveActive  bool               `json:"live_active"`
+	State       OnAirState         `json:"state"`
+	Current     string             `json:"current"`
+	Next        string             `json:"next"`
+	Levels      *AudioLevels       `json:"levels,omitempty"`
+	OffAir      bool               `json:"off_air"`
+	Rejected    ListenerRejections `json:"rejected"` // listeners refused by the limits
 }
 
 type studioStatus struct {
-	Studio         string `json:"studio"`
-	IsLive         bool   `json:"is_live"`
-	ListenersCount int    `json:"listeners_count"`
+	Studio         string             `json:"studio"`
+	IsLive         bool               `json:"is_live"`
+	ListenersCount int                `json:"listeners_count"`
+	MountListeners map[string]int     `json:"mount_listeners"` // per mount ("main" is /listen)
+	Rejected       ListenerRejections `json:"rejected"`
+	BurstBytes     int                `json:"burst_bytes"`
+	BurstSeconds   float64            `json:"burst_seconds"`
+	BurstBuffered  int                `json:"burst_buffered"`
+
+	LastHandoff   *handoffInfo   `json:"last_handoff,omitempty"`
+	LiveIncidents []liveIncident `json:"live_incidents,omitempty"`
+	Levels        *AudioLevels   `json:"levels,omitempty"` // of the audio on air
+	OffAir        bool           `json:"off_air"`          // no source is producing audio
+
+	State       OnAirState   `json:"state"`
+	StateSource string       `json:"state_source"`
+	StateSince  time.Time    `json:"state_since"`
+	Transitions []OnAirEvent `json:"transitions,omitempty"`
+	Codec       Codec        `json:"codec"` // of the output
+
+	PushTargets []PushStatus  `json:"push_targets,omitempty"`
+	Mounts      []MountStatus `json:"mounts,omitempty"`
 }
 
-type streamListener struct {
-	l             *listeners.Listener
-	ch            chan []byte
-	droppedInARow int
+// Listener write tuning: each listener sends everything available since its cursor in one write,
+// at most every listenerFlushInterval, using a pooled buffer so memory doesn't grow per listener.
+const (
+	listenerWriteMax      = 64 * 1024
+	listenerFlushInterval = 100 * time.Millisecond
+)
+
+var listenerBufPool = sync.Pool{
+	New: func() any {
+		b := make([]byte, 0, listenerWriteMax)
+		return &b
+	},
+}
+
+// StudioOption customizes a studio before its goroutines start
+type StudioOption func(*Studio)
+
+// WithSourceAccounts adds source (encoder) accounts accepted by the live ingest
+func WithSourceAccounts(accounts ...SourceAccount) StudioOption {
+	return func(s *Studio) {
+		for _, acc := range accounts {
+			s.auth.addAccount(acc)
+		}
+	}
+}
+
+// WithSourceBackend loads additional source accounts from the backend (GET endpoint)
+func WithSourceBackend(endpoint, apiKey string) StudioOption {
+	return func(s *Studio) {
+		s.auth.endpoint = endpoint
+		s.auth.apiKey = apiKey
+	}
+}
+
+// WithStreamKeysFile persists issued stream keys (hashed) to path
+func WithStreamKeysFile(path string) StudioOption {
+	return func(s *Studio) { s.auth.keysFile = path }
+}
+
+// WithAuthLimit blocks an IP for lockout after maxFailures failed source logins within window
+func WithAuthLimit(maxFailures int, window, lockout time.Duration) StudioOption {
+	return func(s *Studio) { s.authLimiter = newAuthLimiter(maxFailures, window, lockout) }
+}
+
+// WithTrustedProxies lets source logins through these proxies be identified by X-Forwarded-For
+func WithTrustedProxies(nets ...*net.IPNet) StudioOption {
+	return func(s *Studio) { s.trustedProxies = nets }
+}
+
+// WithBurst sets how much recent audio a new listener receives on connect.
+// A positive duration takes precedence and is converted using the studio bitrate.
+func WithBurst(bytes int, d time.Duration) StudioOption {
+	return func(s *Studio) {
+		s.burstBytes = bytes
+		s.burstDuration = d
+	}
+}
+
+// WithRingBuffer sets how many bytes of output the studio keeps for lagging listeners and bursts
+func WithRingBuffer(bytes int) StudioOption {
+	return func(s *Studio) { s.ringBytes = bytes }
 }
 
 // Studio represents a radio studio/channel
@@ -53,22 +141,73 @@ type Studio struct {
 	audioDir    string
 	bitrateKbps int
 
-	// Live ingest (if present)
-	liveMu     sync.RWMutex
-	liveIngest io.ReadCloser
-	liveActive atomic.Bool
+	// Live ingest: every connected source, the one on air (pinned by an admin or by priority)
+	liveMu           sync.RWMutex
+	liveSources      []*liveSource
+	liveOnAir        *liveSource
+	livePinned       *liveSource
+	liveIncidents    []*liveIncident
+	liveStallTimeout time.Duration
+	liveReadTimeout  time.Duration
+	liveActive       atomic.Bool
+	liveSessions     atomic.Uint64
 
 	// In Studio struct
 	liveMetaMu sync.RWMutex
 	liveMeta   *LiveMeta
 
-	// Central feed: all upstream audio goes here (AutoDJ or live)
-	feed chan []byte
-
-	// listeners receives bytes (fan-out)
-	listenersMu     sync.RWMutex
-	streamListeners map[*streamListener]struct{}
-	listenersStore  *listeners.Store
+	// Source credentials
+	auth           *sourceAuth
+	authLimiter    *authLimiter
+	trustedProxies []*net.IPNet // peers whose X-Forwarded-For is believed
+
+	// ICY (SHOUTcast-style) listener metadata
+	station    StationInfo
+	icyMetaInt int
+
+	// Central feed: all upstream audio goes here (AutoDJ or live), tagged by source
+	feed chan feedChunk
+
+	// Switching sources happens on frame boundaries with handoffFade of silence in between
+	handoffFade    time.Duration
+	pendingHandoff *handoffInfo // distributor only
+	lastHandoff    atomic.Pointer[handoffInfo]
+
+	// Levels of the audio on air, and the dead-air detection applied to live sources
+	levelMu            sync.Mutex
+	level              levelMeter
+	silenceThresholdDB float64
+	silenceTimeout     time.Duration
+
+	// Keepalive: when the last audio was produced and the format to keep
+	lastAudio  atomic.Int64 // unix nanoseconds
+	lastHeader atomic.Uint32
+
+	onAir *onAirMachine
+
+	relays        []RelayConfig
+	pushTargets   []*pushTarget
+	mounts        []*mountOutput
+	shoutcastAddr string
+	wsOrigins     []string // page origins allowed to open WebSocket sources
+
+	// Output: the distributor re-frames the feed and appends to a shared ring;
+	// each listener reads at its own cursor. New listeners start burstBytes back (burst-on-connect).
+	framer        mp3Framer
+	adts          adtsFramer
+	pager         oggPager
+	ring          *audioRing
+	ringBytes     int
+	burstBytes    int
+	burstDuration time.Duration
+
+	listenersStore *listeners.Store
+	limits         ListenerLimits
+	quota          *ListenerQuota // shared with other studios
+	gate           listenerGate
+
+	// HLS segmenter, fed by the distributor
+	hls *hlsOutput
 
 	// snapshot
 	snapshotMu       sync.RWMutex
@@ -81,17 +220,49 @@ type Studio struct {
 	autoDJCancel context.CancelFunc
 }
 
-func NewStudio(id string, dir string, brKbps int, geoR *geo.Resolver, autoDJF AutoDJFactory, snapIn time.Duration) *Studio {
+func NewStudio(id string, dir string, brKbps int, geoR *geo.Resolver, autoDJF AutoDJFactory, snapIn time.Duration, opts ...StudioOption) *Studio {
 	s := &Studio{
 		ID:               id,
 		audioDir:         dir,
 		bitrateKbps:      brKbps,
-		feed:             make(chan []byte, 4096),
+		feed:             make(chan feedChunk, 4096),
 		listenersStore:   listeners.NewStore(),
-		streamListeners:  make(map[*streamListener]struct{}),
 		geoResolver:      geoR,
 		snapshotInterval: snapIn,
 		stop:             make(chan struct{}),
+		auth:             newSourceAuth(),
+		authLimiter:      newAuthLimiter(5, time.Minute, 5*time.Minute),
+		icyMetaInt:       defaultICYMetaInt,
+		burstBytes:       64 * 1024,
+		hls:              newHLSOutput(),
+		handoffFade:      defaultHandoffFade,
+		liveStallTimeout: defaultLiveStallTimeout,
+		liveReadTimeout:  defaultLiveReadTimeout,
+
+		silenceThresholdDB: defaultSilenceThresholdDB,
+		silenceTimeout:     defaultSilenceTimeout,
+		onAir:              newOnAirMachine(),
+	}
+	for _, o := range opts {
+		o(s)
+	}
+	s.lastAudio.Store(time.Now().UnixNano())
+	s.auth.loadKeys()
+	if s.limits.RetryAfter <= 0 {
+		s.limits.RetryAfter = defaultListenerRetryAfter
+	}
+	if s.burstDuration > 0 {
+		s.burstBytes = int(s.burstDuration.Seconds() * float64(brKbps) * 1000 / 8)
+	}
+	if s.ringBytes <= 0 {
+		s.ringBytes = 10 * brKbps * 1000 / 8 // ~10s of audio
+	}
+	if s.ringBytes < s.burstBytes+64*1024 {
+		s.ringBytes = s.burstBytes + 64*1024
+	}
+	s.ring = newAudioRing(s.ringBytes, 8192)
+	for _, m := range s.mounts {
+		s.initMount(m)
 	}
 
 	// Start distributor + AutoDJ
@@ -100,18 +271,47 @@ func NewStudio(id string, dir string, brKbps int, geoR *geo.Resolver, autoDJF Au
 		ctx, cancel := context.WithCancel(context.Background())
 		s.autoDJCancel = cancel
 		s.autoDJ = autoDJF(dir, id, brKbps, func(b []byte) {
-			// If you want to suppress AutoDJ during live, check s.liveActive.Load() here
+			// The AutoDJ is paused while live; this only drops a chunk already in flight
 			if s.liveActive.Load() {
 				return
 			}
-			s.push(b)
+			// chunks start on a frame, from a file of one codec
+			s.pushCodec(sourceAutoDJ, sniffCodec(b), b)
 		})
 		go s.autoDJ.Play(ctx)
 	}
 	go s.snapshotLoop()
+	go s.hlsReapLoop()
+	go s.liveWatchLoop()
+	go s.keepaliveLoop()
+	for _, r := range s.relays {
+		go s.runRelay(r)
+	}
+	for _, t := range s.pushTargets {
+		go s.runPush(t)
+	}
+	for _, m := range s.mounts {
+		go s.runMount(m)
+	}
+	if s.shoutcastAddr != "" {
+		s.startShoutcast()
+	}
 	return s
 }
 
+// setLive marks live as on air or not, pausing the AutoDJ for the duration of the show.
+// Callers hold liveMu.
+func (s *Studio) setLive(on bool) {
+	if s.liveActive.Swap(on) == on || s.autoDJ == nil {
+		return
+	}
+	if on {
+		s.autoDJ.Pause()
+	} else {
+		s.autoDJ.Resume()
+	}
+}
+
 func (s *Studio) setLiveMeta(m LiveMeta) {
 	s.liveMetaMu.Lock()
 	s.liveMeta = &m
@@ -135,19 +335,6 @@ func (s *Studio) LiveMeta() *LiveMeta {
 	return &m
 }
 
-// func (s *Studio) startAutoDJ() {
-// 	ctx, cancel := context.WithCancel(context.Background())
-// 	s.autoDJCancel = cancel
-// 	runner := s.autoDJ(s.audioDir, s.bitrateKbps, func(b []byte) {
-// 		// If you want to suppress AutoDJ during live, check s.liveActive.Load() here
-// 		if s.liveActive.Load() {
-// 			return
-// 		}
-// 		s.push(b)
-// 	})
-// 	go runner.Play(ctx)
-// }
-
 func (s *Studio) snapshotLoop() {
 	t := time.NewTicker(s.snapshotInterval)
 	defer t.Stop()
@@ -166,24 +353,28 @@ func (s *Studio) Close() {
 	if s.autoDJCancel != nil {
 		s.autoDJCancel()
 	}
-	close(s.feed)
 }
 
-func (s *Studio) push(data []byte) {
+func (s *Studio) push(source string, data []byte) {
+	s.pushCodec(source, CodecMP3, data)
+}
+
+func (s *Studio) pushCodec(source string, codec Codec, data []byte) {
+	// The feed is never closed, as sources may still push while the studio closes; nothing is
+	// queued once it has
+	select {
+	case <-s.stop:
+		return
+	default:
+	}
 	// Non-blocking feed send; if full, drop (rare if sized well)
 	select {
-	case s.feed <- data:
+	case s.feed <- feedChunk{source: source, codec: codec, data: data}:
 	default:
 		// could log; but dropping at feed level should be exceptional
 	}
 }
 
-func (s *Studio) removeListener(sl *streamListener) {
-	s.listenersMu.Lock()
-	delete(s.streamListeners, sl)
-	s.listenersMu.Unlock()
-}
-
 func (s *Studio) buildSnapshot() {
 	active := s.listenersStore.Active()
 	snap := StudioSnapshot{
@@ -191,6 +382,7 @@ func (s *Studio) buildSnapshot() {
 		StudioID:    s.ID,
 		Countries:   make(map[string]int),
 		ClientTypes: make(map[string]int),
+		Mounts:      make(map[string]int),
 	}
 	var totalBytes int64
 	for _, l := range active {
@@ -205,9 +397,18 @@ func (s *Studio) buildSnapshot() {
 			ct = "unknown"
 		}
 		snap.ClientTypes[ct]++
+		snap.Mounts[l.Mount]++
 		totalBytes += l.ByteSent.Load()
 	}
 	snap.BytesTotal = totalBytes
+	np := s.nowPlaying()
+	snap.State = OnAirState(np.Source)
+	snap.LiveActive = snap.State == OnAirLive
+	snap.OffAir = snap.State == OnAirSilence || snap.State == OnAirOff
+	snap.Current = np.Current
+	snap.Next = np.Next
+	snap.Levels = s.levels()
+	snap.Rejected = s.gate.rejections()
 	s.snapshotMu.Lock()
 	s.lastSnapshot = snap
 	s.snapshotMu.Unlock()
@@ -219,86 +420,132 @@ func (s *Studio) Snapshot() StudioSnapshot {
 	return s.lastSnapshot
 }
 
+func (s *Studio) hlsTitle() string {
+	return s.streamMeta().Title
+}
+
+// distribute re-chunks the feed into whole frames (or Ogg pages) and publishes them to the ring.
+// A change of source is handed off on a frame boundary (see handoff).
 func (s *Studio) distribute() {
 	log.Printf("Studio %s: distributer started", s.ID)
-	for data := range s.feed {
-		s.listenersMu.RLock()
-		for ls := range s.streamListeners {
-			select {
-			case ls.ch <- data:
-				ls.droppedInARow = 0
-			default:
-				ls.droppedInARow++
-				if ls.droppedInARow > 50 {
-					close(ls.ch)
-					s.listenersMu.RUnlock()
-					s.removeListener(ls)
-					s.listenersMu.RLock()
-					log.Printf("Studio %s: dropped slow listener", s.ID)
-				}
+	source := ""
+	for {
+		var c feedChunk
+		select {
+		case c = <-s.feed:
+		case <-s.stop:
+			s.ring.close()
+			log.Printf("Studio %s: distributor stopped", s.ID)
+			return
+		}
+		if c.source != source {
+			if source != "" {
+				s.handoff(source, c.source)
 			}
-			ls.l.ByteSent.Add(int64(len(data)))
-			// Heartbeat update every ~5s
-			if hb := ls.l.LastHeartbeat.Load(); hb != nil {
-				if time.Since(*hb) > 5*time.Second {
-					now := time.Now()
-					ls.l.LastHeartbeat.Store(&now)
-				}
+			source = c.source
+		}
+		switch c.codec {
+		case CodecOgg:
+			s.distributeOgg(c)
+			continue
+		case CodecAAC:
+			s.distributeAAC(c)
+			continue
+		}
+		if frames := s.framer.push(c.data); len(frames) > 0 {
+			s.setOutputFormat(CodecMP3, nil, false)
+			s.checkHandoff(CodecMP3)
+			s.updateOnAir(c.source)
+			if c.source != sourceKeepalive {
+				s.lastAudio.Store(time.Now().UnixNano())
 			}
+			if s.framer.synced {
+				s.lastHeader.Store(binary.BigEndian.Uint32(s.framer.lastRaw[:]))
+			}
+			s.levelMu.Lock()
+			s.level.add(frames, s.silenceThresholdDB, time.Now())
+			s.levelMu.Unlock()
+			s.ring.write(frames)
+			s.hls.add(CodecMP3, frames, s.hlsTitle)
 		}
-		s.listenersMu.RUnlock()
 	}
-	log.Printf("Studio %s: distributor stopped", s.ID)
 }
 
-// HandleLiveIngest is called when a live encoder (e.g., BUTT) streams audio to the server.
-// Only one live stream at a time is supported per studio.
-func (s *Studio) HandleLiveIngestV1(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost && r.Method != http.MethodPut {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+// distributeAAC re-frames AAC on ADTS frames, which HLS packs as they are. AAC is not levelled.
+func (s *Studio) distributeAAC(c feedChunk) {
+	frames := s.adts.push(c.data)
+	if len(frames) == 0 {
 		return
 	}
+	s.setOutputFormat(CodecAAC, nil, false)
+	s.checkHandoff(CodecAAC)
+	s.updateOnAir(c.source)
+	s.lastAudio.Store(time.Now().UnixNano())
+	s.ring.write(frames)
+	s.hls.add(CodecAAC, frames, s.hlsTitle)
+}
 
-	s.liveMu.Lock()
-	if s.liveIngest != nil {
-		s.liveIngest.Close() // Stop any previous live stream
-	}
-
-	s.liveIngest = r.Body
-	s.liveActive.Store(true)
-	s.liveMu.Unlock()
-
-	log.Printf("Studio %s: live stream started", s.ID)
-
-	buf := make([]byte, 8192)
+// distributeOgg passes Ogg pages through; each new logical stream starts a format whose headers
+// are replayed to listeners joining it mid-stream. Ogg is not levelled, and ends the HLS playlist.
+func (s *Studio) distributeOgg(c feedChunk) {
+	s.pager.write(c.data)
 	for {
-		n, err := s.liveIngest.Read(buf)
-		if n > 0 {
-			chunk := make([]byte, n)
-			copy(chunk, buf[:n])
-			s.push(chunk)
-		}
-		if err != nil {
-			break
+		pages, fresh := s.pager.next()
+		if len(pages) == 0 {
+			return
 		}
+		s.setOutputFormat(CodecOgg, s.pager.headers, fresh)
+		s.checkHandoff(CodecOgg)
+		s.updateOnAir(c.source)
+		s.lastAudio.Store(time.Now().UnixNano())
+		s.ring.write(pages)
+		s.hls.end()
+	}
+}
+
+// setOutputFormat starts a new ring format when the codec changes, or when a new Ogg stream does
+func (s *Studio) setOutputFormat(codec Codec, headers []byte, fresh bool) {
+	cur := s.ring.currentFormat()
+	if cur.codec == codec && !fresh {
+		return
 	}
-	s.liveMu.Lock()
-	if s.liveIngest != nil {
-		_ = s.liveIngest.Close()
-		s.liveIngest = nil
+	if cur.codec != codec {
+		log.Printf("Studio %s: output codec %s -> %s", s.ID, cur.codec, codec)
 	}
-	s.liveActive.Store(false)
-	s.liveMu.Unlock()
-	log.Printf("Studio %s: live stream ended", s.ID)
+	s.ring.setFormat(&streamFormat{codec: codec, headers: headers})
 }
 
 // HandleListen streams audio (live or AutoDJ) to a listener.
 func (s *Studio) HandleListen(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "audio/mpeg")
+	s.serveListener(w, r, mainMount, s.ring, s.burstBytes)
+}
+
+// serveListener streams a mount's ring to a listener, starting burst bytes back
+func (s *Studio) serveListener(w http.ResponseWriter, r *http.Request, mount string, ring *audioRing, burst int) {
+	release, refused := s.admitListener(mount, s.geoResolver.HashIP(netutil.TrustedClientIP(r, s.trustedProxies)))
+	if refused != "" {
+		s.refuseListener(w, r, mount, refused)
+		return
+	}
+	defer release()
+
+	// The response is in the codec on air when the listener joins; should it change, the
+	// connection is closed and the player reconnects to the new one.
+	cursor, format := ring.cursorBack(burst)
+	codec := format.codec
+	w.Header().Set("Content-Type", codec.ContentType())
 	w.Header().Set("Cache-Control", "no-cache")
 	w.Header().Set("Connection", "keep-alive")
 	w.Header().Set("Accept-Ranges", "bytes")
 	w.Header().Set("Access-Control-Allow-Origin", "*")
+	s.setICYHeaders(w.Header())
+	// Players that can show titles ask for in-band metadata; everyone else gets the clean stream.
+	// Ogg carries its titles in its own comment headers.
+	var out io.Writer = w
+	if r.Header.Get("Icy-MetaData") == "1" && codec != CodecOgg {
+		w.Header().Set("icy-metaint", strconv.Itoa(s.icyMetaInt))
+		out = newICYWriter(w, s.icyMetaInt, s.streamMeta)
+	}
 	// Do NOT manually set Transfer-Encoding; Go will add chunked automatically.
 	w.WriteHeader(http.StatusOK)
 
@@ -307,6 +554,8 @@ func (s *Studio) HandleListen(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
 		return
 	}
+	// send the headers now: a mount may have no audio yet
+	flusher.Flush()
 
 	id := uuid.NewString()
 	ip := netutil.ExtractClientIp(r)
@@ -315,6 +564,7 @@ func (s *Studio) HandleListen(w http.ResponseWriter, r *http.Request) {
 	l := &listeners.Listener{
 		ID:          id,
 		StudioId:    s.ID,
+		Mount:       mount,
 		RemoteIP:    ip,
 		UserAgent:   userAgent,
 		ClientType:  netutil.ClassifyUserAgent(userAgent),
@@ -326,76 +576,189 @@ func (s *Studio) HandleListen(w http.ResponseWriter, r *http.Request) {
 	// Enrich asynchronously (non-blocking)
 	go s.geoResolver.Enrich(l)
 
-	sl := &streamListener{
-		l:  l,
-		ch: make(chan []byte, 2048),
-	}
-	s.listenersMu.Lock()
-	s.streamListeners[sl] = struct{}{}
-	total := len(s.streamListeners)
-	s.listenersMu.Unlock()
-	log.Printf("Studio %s: new listener (total=%d)", s.ID, total)
+	total := len(s.listenersStore.Active())
+	log.Printf("Studio %s: new listener on %s (total=%d)", s.ID, mount, total)
 
 	defer func() {
 		l.MarkDisconnected()
-		s.listenersMu.Lock()
-		delete(s.streamListeners, sl)
-		s.listenersMu.Unlock()
 		s.listenersStore.Remove(l.ID)
-		close(sl.ch)
 		log.Printf("Studio %s: listener disconnected", s.ID)
 	}()
 
-	for data := range sl.ch {
-		if _, err := w.Write(data); err != nil {
-			break
+	ctx := r.Context()
+	if s.limits.MaxSession > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.limits.MaxSession)
+		defer cancel()
+		defer func() {
+			if ctx.Err() == context.DeadlineExceeded {
+				s.gate.expired.Add(1)
+				log.Printf("Studio %s: listener %s reached the session limit", s.ID, l.ID)
+			}
+		}()
+	}
+	lastWrite := time.Time{}
+	var sent *streamFormat // format whose preamble the listener has
+	for ctx.Err() == nil {
+		bp := listenerBufPool.Get().(*[]byte)
+		res := ring.read(cursor, (*bp)[:0], listenerWriteMax)
+		cursor = res.next
+		if res.skipped {
+			log.Printf("Studio %s: listener %s fell behind, skipped to live edge", s.ID, l.ID)
+		}
+		if len(res.data) == 0 {
+			listenerBufPool.Put(bp)
+			if res.closed {
+				return
+			}
+			select {
+			case <-res.wait:
+				continue
+			case <-ctx.Done():
+				return
+			}
+		}
+
+		if res.format != sent {
+			if res.format.codec != codec {
+				listenerBufPool.Put(bp)
+				log.Printf("Studio %s: listener %s: output codec changed to %s, closing", s.ID, l.ID, res.format.codec)
+				return
+			}
+			if pre := res.format.preamble(res.start); len(pre) > 0 {
+				if _, err := out.Write(pre); err != nil {
+					listenerBufPool.Put(bp)
+					return
+				}
+			}
+			sent = res.format
+		}
+		_, err := out.Write(res.data)
+		*bp = res.data
+		listenerBufPool.Put(bp)
+		if err != nil {
+			return
 		}
 		flusher.Flush()
+		l.ByteSent.Add(int64(len(res.data)))
+
+		now := time.Now()
+		// Heartbeat update every ~5s
+		if hb := l.LastHeartbeat.Load(); hb != nil && now.Sub(*hb) > 5*time.Second {
+			l.LastHeartbeat.Store(&now)
+		}
+		// Coalesce: let a few frames accumulate before the next write
+		if wait := listenerFlushInterval - now.Sub(lastWrite); wait > 0 && !lastWrite.IsZero() {
+			select {
+			case <-time.After(wait):
+			case <-ctx.Done():
+				return
+			}
+		}
+		lastWrite = time.Now()
 	}
 }
 
 // Example status endpoint (extend with richer JSON / metrics).
 func (s *Studio) HandleStatus(w http.ResponseWriter, r *http.Request) {
 	// Simple plain text (replace with JSON if you add a JSON encoder)
-	s.listenersMu.RLock()
-	listenerCount := len(s.streamListeners)
-	s.listenersMu.RUnlock()
-
-	live := s.liveActive.Load()
+	active := s.listenersStore.Active()
+	perMount := map[string]int{}
+	for _, l := range active {
+		perMount[l.Mount]++
+	}
+	state, source, since := s.OnAir()
+	buffered := min(s.ring.buffered(), s.burstBytes)
 
 	sStatus := studioStatus{
 		Studio:         s.ID,
-		IsLive:         live,
-		ListenersCount: listenerCount,
+		IsLive:         state == OnAirLive,
+		ListenersCount: len(active),
+		MountListeners: perMount,
+		Rejected:       s.gate.rejections(),
+		BurstBytes:     s.burstBytes,
+		BurstSeconds:   float64(s.burstBytes) * 8 / (float64(s.bitrateKbps) * 1000),
+		BurstBuffered:  buffered,
+		LastHandoff:    s.lastHandoff.Load(),
+		LiveIncidents:  s.liveIncidentsSnapshot(),
+		Levels:         s.levels(),
+		OffAir:         state == OnAirSilence || state == OnAirOff,
+		State:          state,
+		StateSource:    source,
+		StateSince:     since,
+		Transitions:    s.OnAirTransitions(),
+		Codec:          s.ring.currentFormat().codec,
+		PushTargets:    s.pushStatuses(),
+		Mounts:         s.mountStatuses(perMount),
 	}
 
 	netutil.ServerResponse(w, 200, "Success", sStatus)
 }
 
+func (s *Studio) levels() *AudioLevels {
+	s.levelMu.Lock()
+	defer s.levelMu.Unlock()
+	return s.level.levels(time.Now())
+}
+
 func (s *Studio) HandleSnapshot(w http.ResponseWriter, r *http.Request) {
 	snap := s.Snapshot()
 
 	netutil.ServerResponse(w, 200, "Success", snap)
 }
 
-func (s *Studio) HandleNowPlaying(w http.ResponseWriter, r *http.Request) {
-	var resp NowPlayingResponse
-	if s.autoDJ != nil {
-		cur, next, started, ok := s.autoDJ.NowPlaying()
-		if ok {
-			resp = NowPlayingResponse{
-				StudioID:   s.ID,
-				Current:    cur.Title,
-				Next:       next.Title,
-				StartedAt:  started,
-				ElapsedSec: time.Since(started).Seconds(),
+// nowPlaying reports what the on-air state says is playing: the live or relayed source's title,
+// the AutoDJ track (or fallback track) otherwise, nothing while silent
+func (s *Studio) nowPlaying() NowPlayingResponse {
+	state, _, since := s.OnAir()
+	resp := NowPlayingResponse{StudioID: s.ID, Source: string(state), StartedAt: since}
+	switch state {
+	case OnAirLive, OnAirRelay:
+		if lm := s.LiveMeta(); lm != nil {
+			resp.Current = lm.Title
+			resp.StartedAt = lm.TitleUpdatedAt
+			if resp.Current == "" {
+				resp.Current = lm.Name
+				resp.StartedAt = lm.UpdatedAt
+			}
+		}
+	case OnAirAutoDJ, OnAirFallback:
+		if s.autoDJ == nil {
+			break
+		}
+		if cur, next, started, ok := s.autoDJ.NowPlaying(); ok {
+			resp.Current = cur.Title
+			resp.Artist = cur.Artist
+			resp.Album = cur.Album
+			if cur.Cover != nil {
+				resp.CoverURL = "/studio/" + s.ID + "/cover"
 			}
+			resp.Next = trackDisplayTitle(next)
+			resp.StartedAt = started
 		}
 	}
-	if resp.Current == "" {
-		resp.StudioID = s.ID
+	if !resp.StartedAt.IsZero() {
+		resp.ElapsedSec = time.Since(resp.StartedAt).Seconds()
+	}
+	return resp
+}
+
+func (s *Studio) HandleNowPlaying(w http.ResponseWriter, r *http.Request) {
+	netutil.ServerResponse(w, 200, "Success", s.nowPlaying())
+}
+
+// HandleCover serves the embedded cover art of the AutoDJ track on air
+func (s *Studio) HandleCover(w http.ResponseWriter, r *http.Request) {
+	if state, _, _ := s.OnAir(); s.autoDJ != nil && (state == OnAirAutoDJ || state == OnAirFallback) {
+		if cur, _, _, ok := s.autoDJ.NowPlaying(); ok && cur.Cover != nil {
+			w.Header().Set("Content-Type", cur.Cover.MIME)
+			w.Header().Set("Cache-Control", "no-cache")
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+			_, _ = w.Write(cur.Cover.Data)
+			return
+		}
 	}
-	netutil.ServerResponse(w, 200, "Success", resp)
+	netutil.ServerResponse(w, 404, "No cover art", nil)
 }
 
 func (s *Studio) HandleSkip(w http.ResponseWriter, r *http.Request) {
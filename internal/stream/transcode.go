@@ -0,0 +1,414 @@
+package stream
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ivugurura/radio-studio/internal/netutil"
+)
+
+// Mounts: besides /listen, a studio can offer its output re-encoded at other bitrates or in other
+// codecs, e.g. /studio/{id}/listen/64 or /listen/aac. Each mount feeds the studio's output to a
+// Transcoder from the live edge, like a push target, and serves the encoded stream it reads back
+// from a ring of its own. A transcoder that fails is restarted with the relays' backoff; one whose
+// input codec changes is restarted at once.
+
+const (
+	mainMount = "main" // the studio's own output, at /listen
+
+	mountStateStarting = "starting"
+	mountStateRunning  = "running"
+	mountStateRetrying = "retrying"
+)
+
+// Transcoder re-encodes a studio's output. The studio writes its audio in and reads the encoded
+// stream out, concurrently; Close stops it and unblocks both.
+type Transcoder interface {
+	io.Reader
+	io.WriteCloser
+}
+
+// TranscoderFactory starts a transcoder taking audio in codec in
+type TranscoderFactory func(in Codec) (Transcoder, error)
+
+// Mount is an extra listener stream of a studio, at /studio/{id}/listen/{Name}
+type Mount struct {
+	Name       string
+	Codec      Codec // of the transcoder's output
+	Bitrate    int   // kbps, for the burst and buffer sizes
+	Transcoder TranscoderFactory
+}
+
+// WithMounts adds transcoded mounts to the studio; they start with it
+func WithMounts(mounts ...Mount) StudioOption {
+	return func(s *Studio) {
+		for _, m := range mounts {
+			if m.Name == "" || m.Name == mainMount || m.Name == hlsMount || strings.Contains(m.Name, "/") || m.Transcoder == nil {
+				log.Printf("Studio %s: invalid mount %q ignored", s.ID, m.Name)
+				continue
+			}
+			if s.mount(m.Name) != nil {
+				log.Printf("Studio %s: duplicate mount %q ignored", s.ID, m.Name)
+				continue
+			}
+			s.mounts = append(s.mounts, &mountOutput{cfg: m, status: MountStatus{Name: m.Name, Codec: m.Codec, Bitrate: m.Bitrate, State: mountStateStarting}})
+		}
+	}
+}
+
+// MountStatus is the health of a mount, as reported in the studio status
+type MountStatus struct {
+	Name        string     `json:"name"`
+	Codec       Codec      `json:"codec"`
+	Bitrate     int        `json:"bitrate"`
+	State       string     `json:"state"` // starting | running | retrying
+	Since       *time.Time `json:"since,omitempty"`
+	Restarts    int        `json:"restarts"`
+	BytesOut    int64      `json:"bytes_out"` // encoded by the transcoder
+	Listeners   int        `json:"listeners"`
+	LastError   string     `json:"last_error,omitempty"`
+	LastErrorAt *time.Time `json:"last_error_at,omitempty"`
+}
+
+type mountOutput struct {
+	cfg   Mount
+	ring  *audioRing
+	burst int
+
+	// the transcoder's output is re-framed like the studio's, by the goroutine reading it
+	framer mp3Framer
+	adts   adtsFramer
+	pager  oggPager
+
+	mu     sync.Mutex
+	status MountStatus
+}
+
+func (m *mountOutput) update(f func(st *MountStatus)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	f(&m.status)
+}
+
+func (m *mountOutput) snapshot() MountStatus {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.status
+}
+
+// mount returns the mount called name, nil if there is none
+func (s *Studio) mount(name string) *mountOutput {
+	for _, m := range s.mounts {
+		if m.cfg.Name == name {
+			return m
+		}
+	}
+	return nil
+}
+
+// initMount sizes the mount's ring and burst for its bitrate, as NewStudio does for the studio's
+func (s *Studio) initMount(m *mountOutput) {
+	kbps := m.cfg.Bitrate
+	if kbps <= 0 {
+		kbps = s.bitrateKbps
+	}
+	m.burst = s.burstBytes * kbps / max(s.bitrateKbps, 1)
+	if s.burstDuration > 0 {
+		m.burst = int(s.burstDuration.Seconds() * float64(kbps) * 1000 / 8)
+	}
+	size := max(10*kbps*1000/8, m.burst+64*1024)
+	m.ring = newAudioRing(size, 8192)
+	m.ring.setFormat(&streamFormat{codec: m.cfg.Codec})
+}
+
+// mountStatuses lists the mounts' health, with their listener counts
+func (s *Studio) mountStatuses(perMount map[string]int) []MountStatus {
+	out := make([]MountStatus, 0, len(s.mounts))
+	for _, m := range s.mounts {
+		st := m.snapshot()
+		st.Listeners = perMount[m.cfg.Name]
+		out = append(out, st)
+	}
+	return out
+}
+
+// runMount keeps the mount's transcoder running until the studio closes
+func (s *Studio) runMount(m *mountOutput) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-s.stop
+		cancel()
+	}()
+	defer m.ring.close()
+
+	backoff := relayMinBackoff
+	for {
+		m.update(func(st *MountStatus) { st.State, st.Since = mountStateStarting, nil })
+		started := time.Now()
+		err := s.transcodeOnce(ctx, m)
+		if ctx.Err() != nil {
+			return
+		}
+		m.update(func(st *MountStatus) { st.Restarts++ })
+		var changed *codecChangedError
+		if errors.As(err, &changed) {
+			log.Printf("Studio %s: mount %s: %v, restarting the transcoder", s.ID, m.cfg.Name, err)
+			continue
+		}
+		if time.Since(started) >= relayStableAfter {
+			backoff = relayMinBackoff
+		}
+		now := time.Now().UTC()
+		m.update(func(st *MountStatus) {
+			st.State, st.Since = mountStateRetrying, nil
+			st.LastError, st.LastErrorAt = err.Error(), &now
+		})
+		log.Printf("Studio %s: mount %s: %v (restarting in %s)", s.ID, m.cfg.Name, err, backoff)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return
+		}
+		backoff = min(backoff*2, relayMaxBackoff)
+	}
+}
+
+type codecChangedError struct{ codec Codec }
+
+func (e *codecChangedError) Error() string { return fmt.Sprintf("input codec changed to %s", e.codec) }
+
+// transcodeOnce runs one transcoder: the studio's output goes in from the live edge, its output is
+// framed into the mount's ring. It returns when either side fails.
+func (s *Studio) transcodeOnce(ctx context.Context, m *mountOutput) error {
+	cursor, format := s.ring.cursorBack(0)
+	t, err := m.cfg.Transcoder(format.codec)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	now := time.Now().UTC()
+	m.update(func(st *MountStatus) { st.State, st.Since = mountStateRunning, &now })
+	m.framer, m.adts, m.pager = mp3Framer{}, adtsFramer{}, oggPager{}
+
+	outErr := make(chan error, 1)
+	go func() { outErr <- m.readTranscoded(t) }()
+	inErr := make(chan error, 1)
+	go func() { inErr <- s.feedTranscoder(ctx, t, cursor, format.codec) }()
+
+	// the first side to fail stops the other; both are done before the next transcoder starts
+	select {
+	case err = <-outErr:
+		cancel()
+		t.Close()
+		<-inErr
+	case err = <-inErr:
+		t.Close()
+		<-outErr
+	}
+	return err
+}
+
+// feedTranscoder writes the studio's output to t from cursor until it fails or changes codec
+func (s *Studio) feedTranscoder(ctx context.Context, t Transcoder, cursor uint64, codec Codec) error {
+	buf := make([]byte, 0, listenerWriteMax)
+	var sent *streamFormat
+	for {
+		res := s.ring.read(cursor, buf[:0], listenerWriteMax)
+		cursor = res.next
+		if len(res.data) == 0 {
+			if res.closed {
+				return errors.New("studio closed")
+			}
+			select {
+			case <-res.wait:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			continue
+		}
+		if res.format != sent {
+			if res.format.codec != codec {
+				return &codecChangedError{res.format.codec}
+			}
+			if pre := res.format.preamble(res.start); len(pre) > 0 {
+				if _, err := t.Write(pre); err != nil {
+					return err
+				}
+			}
+			sent = res.format
+		}
+		if _, err := t.Write(res.data); err != nil {
+			return err
+		}
+		buf = res.data
+	}
+}
+
+// readTranscoded frames the transcoder's output into the mount's ring until it ends
+func (m *mountOutput) readTranscoded(t Transcoder) error {
+	for {
+		buf := make([]byte, 16*1024)
+		n, err := t.Read(buf)
+		if n > 0 {
+			m.update(func(st *MountStatus) { st.BytesOut += int64(n) })
+			m.publish(buf[:n])
+		}
+		if err == io.EOF {
+			return errors.New("transcoder exited")
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// publish writes whole frames (or Ogg pages) of the mount's codec to its ring
+func (m *mountOutput) publish(data []byte) {
+	switch m.cfg.Codec {
+	case CodecOgg:
+		m.pager.write(data)
+		for {
+			pages, fresh := m.pager.next()
+			if len(pages) == 0 {
+				return
+			}
+			if fresh {
+				m.ring.setFormat(&streamFormat{codec: CodecOgg, headers: m.pager.headers})
+			}
+			m.ring.write(pages)
+		}
+	case CodecAAC:
+		m.ring.write(m.adts.push(data))
+	default:
+		m.ring.write(m.framer.push(data))
+	}
+}
+
+// HandleMountListen streams one of the studio's mounts to a listener
+func (s *Studio) HandleMountListen(w http.ResponseWriter, r *http.Request, name string) {
+	m := s.mount(name)
+	if m == nil {
+		netutil.ServerResponse(w, 404, "Unknown mount", nil)
+		return
+	}
+	s.serveListener(w, r, name, m.ring, m.burst)
+}
+
+// ExecTranscoder runs an external encoder per transcoder: the studio's audio goes to its standard
+// input, the encoded stream is read from its standard output
+func ExecTranscoder(name string, args ...string) TranscoderFactory {
+	return func(Codec) (Transcoder, error) {
+		return startExecTranscoder(exec.Command(name, args...))
+	}
+}
+
+// FFmpegTranscoder encodes to codec at kbps with ffmpeg (found in PATH): MP3 with LAME, AAC-LC
+// in ADTS with ffmpeg's own encoder, Opus in Ogg
+func FFmpegTranscoder(codec Codec, kbps int) TranscoderFactory {
+	return func(in Codec) (Transcoder, error) {
+		return startExecTranscoder(exec.Command("ffmpeg", ffmpegArgs(in, codec, kbps)...))
+	}
+}
+
+func ffmpegArgs(in, out Codec, kbps int) []string {
+	formats := map[Codec]string{CodecMP3: "mp3", CodecAAC: "aac", CodecOgg: "ogg"}
+	encoders := map[Codec]string{CodecMP3: "libmp3lame", CodecAAC: "aac", CodecOgg: "libopus"}
+	outFormat := formats[out]
+	if out == CodecAAC {
+		outFormat = "adts"
+	}
+	// the input format is given, so ffmpeg starts encoding without probing the stream
+	return []string{
+		"-hide_banner", "-loglevel", "error",
+		"-f", formats[in], "-i", "pipe:0",
+		"-vn", "-c:a", encoders[out], "-b:a", fmt.Sprintf("%dk", kbps),
+		"-flush_packets", "1", "-f", outFormat, "pipe:1",
+	}
+}
+
+// execTranscoder is an encoder process; its standard error is kept for the exit error
+type execTranscoder struct {
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout io.ReadCloser
+	stderr *tailBuffer
+
+	once sync.Once
+}
+
+func startExecTranscoder(cmd *exec.Cmd) (*execTranscoder, error) {
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	t := &execTranscoder{cmd: cmd, stdin: stdin, stdout: stdout, stderr: &tailBuffer{max: 512}}
+	cmd.Stderr = t.stderr
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func (t *execTranscoder) Write(b []byte) (int, error) {
+	return t.stdin.Write(b)
+}
+
+// Read returns the process's exit status, and the end of what it logged, once its output ends
+func (t *execTranscoder) Read(b []byte) (int, error) {
+	n, err := t.stdout.Read(b)
+	if err == io.EOF {
+		if werr := t.cmd.Wait(); werr != nil {
+			return n, fmt.Errorf("%s: %v: %s", t.cmd.Path, werr, strings.TrimSpace(t.stderr.String()))
+		}
+	}
+	return n, err
+}
+
+func (t *execTranscoder) Close() error {
+	t.once.Do(func() {
+		// kill first: with its input closed the process could still exit cleanly
+		if t.cmd.Process != nil {
+			_ = t.cmd.Process.Kill()
+		}
+		t.stdin.Close()
+	})
+	return nil
+}
+
+// tailBuffer keeps the last max bytes written to it
+type tailBuffer struct {
+	mu  sync.Mutex
+	max int
+	b   []byte
+}
+
+func (t *tailBuffer) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.b = append(t.b, p...)
+	if over := len(t.b) - t.max; over > 0 {
+		t.b = append(t.b[:0], t.b[over:]...)
+	}
+	return len(p), nil
+}
+
+func (t *tailBuffer) String() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return string(t.b)
+}
@@ -0,0 +1,165 @@
+package stream
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os/exec"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// passTranscoder hands its input back unchanged
+type passTranscoder struct {
+	*io.PipeReader
+	w *io.PipeWriter
+}
+
+func (p passTranscoder) Write(b []byte) (int, error) { return p.w.Write(b) }
+
+func (p passTranscoder) Close() error {
+	p.PipeReader.Close()
+	return p.w.Close()
+}
+
+// passFactory records the input codec of every transcoder it starts
+type passFactory struct {
+	mu     sync.Mutex
+	inputs []Codec
+	fail   error
+}
+
+func (f *passFactory) start(in Codec) (Transcoder, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.inputs = append(f.inputs, in)
+	if f.fail != nil {
+		return nil, f.fail
+	}
+	r, w := io.Pipe()
+	return passTranscoder{r, w}, nil
+}
+
+func (f *passFactory) started() []Codec {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]Codec{}, f.inputs...)
+}
+
+func listenMount(t *testing.T, s *Studio, name string) *http.Response {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.HandleMountListen(w, r, name)
+	}))
+	t.Cleanup(srv.Close)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL, nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+func TestMountTranscodes(t *testing.T) {
+	var f passFactory
+	s := newTestStudio(t, WithMounts(Mount{Name: "64", Codec: CodecMP3, Bitrate: 64, Transcoder: f.start}))
+	m := s.mount("64")
+	waitFor(t, "the transcoder", func() bool { return m.snapshot().State == mountStateRunning })
+
+	frames := testMP3Frames(testMP3Header, 4)
+	s.push(sourceAutoDJ, frames)
+	waitFor(t, "the mount output", func() bool { return m.ring.buffered() == len(frames) })
+
+	resp := listenMount(t, s, "64")
+	if ct := resp.Header.Get("Content-Type"); ct != "audio/mpeg" {
+		t.Fatalf("Content-Type = %q", ct)
+	}
+	got := make([]byte, len(frames))
+	if _, err := io.ReadFull(resp.Body, got); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, frames) {
+		t.Fatal("mount listener did not get the transcoded frames")
+	}
+	waitFor(t, "the listener counted", func() bool {
+		s.buildSnapshot()
+		return s.Snapshot().Mounts["64"] == 1 && s.Snapshot().Mounts[mainMount] == 0
+	})
+	if st := s.mountStatuses(map[string]int{"64": 1}); len(st) != 1 || st[0].BytesOut != int64(len(frames)) || st[0].Listeners != 1 {
+		t.Fatalf("mount status = %+v", st)
+	}
+
+	// the studio switches to AAC: the transcoder is restarted on the new input
+	src := newTestSource(s, 1, nil)
+	s.addLiveSource(src)
+	s.liveData(src, testADTSFrames(3, 200, 4))
+	waitFor(t, "a transcoder for AAC", func() bool {
+		in := f.started()
+		return len(in) == 2 && in[1] == CodecAAC
+	})
+	rec := httptest.NewRecorder()
+	s.HandleMountListen(rec, httptest.NewRequest(http.MethodGet, "/", nil), "nope")
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("unknown mount answered %d", rec.Code)
+	}
+}
+
+func TestMountTranscoderFailure(t *testing.T) {
+	f := passFactory{fail: errors.New("no encoder")}
+	s := newTestStudio(t, WithMounts(Mount{Name: "aac", Codec: CodecAAC, Transcoder: f.start}))
+	m := s.mount("aac")
+	waitFor(t, "the failure reported", func() bool {
+		st := m.snapshot()
+		return st.State == mountStateRetrying && st.LastError == "no encoder" && st.Restarts == 1
+	})
+	// listeners get the mount's codec even before it has audio
+	resp := listenMount(t, s, "aac")
+	if ct := resp.Header.Get("Content-Type"); ct != "audio/aac" {
+		t.Fatalf("Content-Type = %q", ct)
+	}
+}
+
+func TestFFmpegArgs(t *testing.T) {
+	args := ffmpegArgs(CodecMP3, CodecAAC, 48)
+	want := "-hide_banner -loglevel error -f mp3 -i pipe:0 -vn -c:a aac -b:a 48k -flush_packets 1 -f adts pipe:1"
+	if got := strings.Join(args, " "); got != want {
+		t.Fatalf("args = %q", got)
+	}
+}
+
+func TestExecTranscoder(t *testing.T) {
+	if _, err := exec.LookPath("cat"); err != nil {
+		t.Skip("no cat")
+	}
+	tr, err := ExecTranscoder("cat")(CodecMP3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tr.Write([]byte("audio")); err != nil {
+		t.Fatal(err)
+	}
+	got := make([]byte, 5)
+	if _, err := io.ReadFull(tr, got); err != nil || string(got) != "audio" {
+		t.Fatalf("read %q, %v", got, err)
+	}
+	tr.Close()
+	if _, err := io.Copy(io.Discard, tr); err == nil {
+		t.Fatal("a killed transcoder ended without an error")
+	}
+
+	tr, err = ExecTranscoder("sh", "-c", "echo broken >&2; exit 3")(CodecMP3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tr.Close()
+	if _, err := io.Copy(io.Discard, tr); err == nil || !strings.Contains(err.Error(), "broken") {
+		t.Fatalf("err = %v", err)
+	}
+}
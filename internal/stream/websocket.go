@@ -0,0 +1,255 @@
+package stream
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// A minimal RFC 6455 server side, enough for sources: the handshake, reading the client's masked
+// (and possibly fragmented) frames, answering pings and closing. Sources only ever send, so
+// there is no API for writing data messages.
+
+const (
+	wsGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+	wsOpContinuation = 0x0
+	wsOpText         = 0x1
+	wsOpBinary       = 0x2
+	wsOpClose        = 0x8
+	wsOpPing         = 0x9
+	wsOpPong         = 0xA
+
+	wsMaxText          = 64 * 1024
+	wsControlWrite     = 5 * time.Second
+	wsCloseNormal      = 1000
+	wsCloseProtocol    = 1002
+	wsCloseUnsupported = 1003
+)
+
+var errWSProtocol = errors.New("websocket protocol error")
+
+// isWebSocketUpgrade reports whether r asks to switch to the WebSocket protocol
+func isWebSocketUpgrade(r *http.Request) bool {
+	return headerHasToken(r.Header, "Connection", "upgrade") && strings.EqualFold(r.Header.Get("Upgrade"), "websocket")
+}
+
+func headerHasToken(h http.Header, key, token string) bool {
+	for _, v := range h.Values(key) {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func wsAcceptKey(key string) string {
+	h := sha1.Sum([]byte(key + wsGUID))
+	return base64.StdEncoding.EncodeToString(h[:])
+}
+
+// upgradeWebSocket completes the handshake and returns the hijacked connection and its reader.
+// On failure the response has already been written.
+func upgradeWebSocket(w http.ResponseWriter, r *http.Request) (net.Conn, *bufio.Reader, error) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return nil, nil, errors.New("websocket upgrade with " + r.Method)
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if key == "" || r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "unsupported websocket version", http.StatusUpgradeRequired)
+		return nil, nil, errors.New("bad websocket handshake")
+	}
+	conn, rw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		http.Error(w, "hijack not supported", http.StatusInternalServerError)
+		return nil, nil, err
+	}
+	_, _ = rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: " + wsAcceptKey(key) + "\r\n\r\n")
+	if err := rw.Flush(); err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	return conn, rw.Reader, nil
+}
+
+// writeWSFrame sends an unmasked final frame, as servers do
+func writeWSFrame(conn net.Conn, op byte, payload []byte) error {
+	hdr := []byte{0x80 | op, 0}
+	switch n := len(payload); {
+	case n < 126:
+		hdr[1] = byte(n)
+	case n <= 0xFFFF:
+		hdr[1] = 126
+		hdr = binary.BigEndian.AppendUint16(hdr, uint16(n))
+	default:
+		hdr[1] = 127
+		hdr = binary.BigEndian.AppendUint64(hdr, uint64(n))
+	}
+	_ = conn.SetWriteDeadline(time.Now().Add(wsControlWrite))
+	_, err := conn.Write(append(hdr, payload...))
+	return err
+}
+
+// wsReader reads the binary messages of a WebSocket connection as one byte stream. Text messages
+// go to onText, pings are answered, and a close frame is echoed and ends the stream with io.EOF.
+// Reads and control replies happen on the reading goroutine only.
+type wsReader struct {
+	conn   net.Conn
+	br     *bufio.Reader
+	onText func(string)
+
+	message   byte  // opcode of the data message being read (text or binary)
+	remaining int64 // payload left in the current binary frame
+	mask      [4]byte
+	maskPos   int
+	text      []byte
+	closed    bool
+}
+
+func (r *wsReader) Read(p []byte) (int, error) {
+	for r.remaining == 0 {
+		if r.closed {
+			return 0, io.EOF
+		}
+		if err := r.nextFrame(); err != nil {
+			return 0, err
+		}
+	}
+	if int64(len(p)) > r.remaining {
+		p = p[:r.remaining]
+	}
+	n, err := r.br.Read(p)
+	r.unmask(p[:n])
+	r.remaining -= int64(n)
+	if errors.Is(err, io.EOF) && r.remaining > 0 {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+func (r *wsReader) unmask(b []byte) {
+	for i := range b {
+		b[i] ^= r.mask[r.maskPos&3]
+		r.maskPos++
+	}
+}
+
+// nextFrame reads a frame header; binary payloads are left for Read, everything else is handled here
+func (r *wsReader) nextFrame() error {
+	var hdr [2]byte
+	if _, err := io.ReadFull(r.br, hdr[:]); err != nil {
+		return err
+	}
+	fin, op := hdr[0]&0x80 != 0, hdr[0]&0x0F
+	if hdr[1]&0x80 == 0 || hdr[0]&0x70 != 0 {
+		return r.fail() // clients must mask; no extensions were negotiated
+	}
+	length := int64(hdr[1] & 0x7F)
+	switch length {
+	case 126:
+		var b [2]byte
+		if _, err := io.ReadFull(r.br, b[:]); err != nil {
+			return err
+		}
+		length = int64(binary.BigEndian.Uint16(b[:]))
+	case 127:
+		var b [8]byte
+		if _, err := io.ReadFull(r.br, b[:]); err != nil {
+			return err
+		}
+		length = int64(binary.BigEndian.Uint64(b[:]) & (1<<63 - 1))
+	}
+	if _, err := io.ReadFull(r.br, r.mask[:]); err != nil {
+		return err
+	}
+	r.maskPos = 0
+
+	switch op {
+	case wsOpClose, wsOpPing, wsOpPong:
+		if !fin || length > 125 {
+			return r.fail()
+		}
+		payload := make([]byte, length)
+		if _, err := io.ReadFull(r.br, payload); err != nil {
+			return err
+		}
+		r.unmask(payload)
+		switch op {
+		case wsOpPing:
+			return writeWSFrame(r.conn, wsOpPong, payload)
+		case wsOpClose:
+			r.closed = true
+			if len(payload) >= 2 {
+				payload = payload[:2] // echo the status code
+			}
+			_ = writeWSFrame(r.conn, wsOpClose, payload)
+		}
+		return nil
+	case wsOpText, wsOpBinary:
+		if r.message != 0 {
+			return r.fail() // a new message inside a fragmented one
+		}
+		r.message = op
+	case wsOpContinuation:
+		if r.message == 0 {
+			return r.fail()
+		}
+	default:
+		return r.fail()
+	}
+
+	if r.message == wsOpBinary {
+		r.remaining = length
+	} else {
+		if int64(len(r.text))+length > wsMaxText {
+			return r.fail()
+		}
+		payload := make([]byte, length)
+		if _, err := io.ReadFull(r.br, payload); err != nil {
+			return err
+		}
+		r.unmask(payload)
+		r.text = append(r.text, payload...)
+		if fin && r.onText != nil {
+			r.onText(string(r.text))
+		}
+		if fin {
+			r.text = r.text[:0]
+		}
+	}
+	if fin {
+		r.message = 0
+	}
+	return nil
+}
+
+// fail closes the connection with a protocol error
+func (r *wsReader) fail() error {
+	r.closed = true
+	_ = writeWSFrame(r.conn, wsOpClose, binary.BigEndian.AppendUint16(nil, wsCloseProtocol))
+	return errWSProtocol
+}
+
+// close ends the connection from the server side, unless the client already did
+func (r *wsReader) close() {
+	r.closeWith(wsCloseNormal, "")
+}
+
+// closeWith ends the connection with a status code and a reason for the client
+func (r *wsReader) closeWith(code uint16, reason string) {
+	if !r.closed {
+		r.closed = true
+		_ = writeWSFrame(r.conn, wsOpClose, append(binary.BigEndian.AppendUint16(nil, code), reason...))
+	}
+}